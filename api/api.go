@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/wire"
 )
 
 // App contains the opc connection and the API routes
@@ -16,6 +20,103 @@ type App struct {
 	Conn   opc.Connection
 	Router *mux.Router
 	Config Config
+	// Browse returns the address space of the OPC server for the
+	// browse endpoint; if it is nil, the tree is built from Conn.Tags()
+	Browse func() (*opc.Tree, error)
+	// Namespace mounts the address space into a namespace of several
+	// servers for the browse endpoint; if it is nil, it is served as is
+	Namespace *Namespace
+}
+
+// Namespace combines the address space of the OPC server with the snapshots
+// of other servers and virtual folders into one browsable tree, see
+// opc.MergeTrees and opc.Overlay. The other servers are browse-only: their
+// tags cannot be read, written, added or queried through the API.
+type Namespace struct {
+	Prefix    string               // mount point of the OPC server, e.g. "Plant1/ServerA"
+	Snapshots map[string]*opc.Tree // trees of other servers by prefix
+	Overlay   *opc.Tree            // virtual folders and tags, may be nil
+
+	once sync.Once
+	tags map[string]bool
+}
+
+// mount returns the namespace with the tree of the OPC server at its prefix
+func (n *Namespace) mount(tree *opc.Tree) *opc.Tree {
+	trees := map[string]*opc.Tree{n.Prefix: tree}
+	for prefix, snapshot := range n.Snapshots {
+		if prefix != n.Prefix {
+			trees[prefix] = snapshot
+		}
+	}
+	merged := opc.MergeTrees(trees)
+	if n.Overlay != nil {
+		merged = opc.Overlay(merged, n.Overlay)
+	}
+	return merged
+}
+
+// has checks if the tag is in the snapshots or the overlay
+func (n *Namespace) has(tag string) bool {
+	n.once.Do(func() {
+		n.tags = make(map[string]bool)
+		for _, tree := range append([]*opc.Tree{n.Overlay}, mapValues(n.Snapshots)...) {
+			if tree == nil {
+				continue
+			}
+			for _, t := range opc.CollectTags(tree) {
+				n.tags[t] = true
+			}
+		}
+	})
+	return n.tags[tag]
+}
+
+// mapValues returns the trees of the map
+func mapValues(trees map[string]*opc.Tree) []*opc.Tree {
+	values := make([]*opc.Tree, 0, len(trees))
+	for _, tree := range trees {
+		values = append(values, tree)
+	}
+	return values
+}
+
+// foreign returns a check if a tag belongs to another server or the overlay of
+// the namespace and not to the OPC connection
+func (a *App) foreign() func(tag string) bool {
+	if a.Namespace == nil {
+		return func(string) bool { return false }
+	}
+	local := make(map[string]bool)
+	for _, tag := range a.Conn.Tags() {
+		local[tag] = true
+	}
+	return func(tag string) bool {
+		return !local[tag] && a.Namespace.has(tag)
+	}
+}
+
+// loadProperties reads the properties of the leaves of the OPC server with conn;
+// the leaves of other servers keep the properties of their snapshots
+func (a *App) loadProperties(conn opc.Connection, tree *opc.Tree) {
+	foreign := a.foreign()
+	var failed int
+	var last error
+	tree.Walk(func(path string, branch *opc.Tree, leaf *opc.Leaf) error {
+		if leaf == nil || foreign(leaf.Tag) {
+			return nil
+		}
+		info, err := conn.Properties(leaf.Tag)
+		if err != nil {
+			failed, last = failed+1, err
+			return nil
+		}
+		leaf.Info = &info
+		return nil
+	})
+	if failed > 0 {
+		opc.Logger().Warn("cannot load properties", "failed", failed, "error", last)
+	}
 }
 
 //Config determines what services shall be exposed
@@ -30,21 +131,73 @@ type Config struct {
 func (a *App) Initialize(conn opc.Connection) {
 	a.Conn = conn
 	a.Router = mux.NewRouter()
-	a.Router.HandleFunc("/tags", a.getTags).Methods("GET")          // Read
-	a.Router.HandleFunc("/tag", a.createTag).Methods("POST")        // Add(...)
-	a.Router.HandleFunc("/tag/{id}", a.getTag).Methods("GET")       // ReadItem(id)
-	a.Router.HandleFunc("/tag/{id}", a.deleteTag).Methods("DELETE") // Remove(id)
-	a.Router.HandleFunc("/tag/{id}", a.updateTag).Methods("PUT")    // Write(id, value)
+	a.Router.HandleFunc("/tags", a.getTags).Methods("GET")                      // Read
+	a.Router.HandleFunc("/tag", a.createTag).Methods("POST")                    // Add(...)
+	a.Router.HandleFunc("/tag/{id}", a.getTag).Methods("GET")                   // ReadItem(id)
+	a.Router.HandleFunc("/tag/{id}", a.deleteTag).Methods("DELETE")             // Remove(id)
+	a.Router.HandleFunc("/tag/{id}", a.updateTag).Methods("PUT")                // Write(id, value)
+	a.Router.HandleFunc("/tag/{id}/properties", a.getProperties).Methods("GET") // Properties(id)
+	a.Router.HandleFunc("/browse", a.browse).Methods("GET")                     // Browse()
+	a.Router.Use(instrument)
+}
+
+// conn returns the connection bound to the context of the request, e.g. to
+// trace the OPC operations as children of the span of the request
+func (a *App) conn(r *http.Request) opc.Connection {
+	return opc.WithContext(r.Context(), a.Conn)
+}
+
+// statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// instrument records the requests of the API with opc.DefaultMetrics
+// by method and route, e.g. "GET /tag/{id}"
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		operation := r.Method
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				operation += " " + path
+			}
+		}
+		opc.DefaultMetrics.ObserveRequest("api", operation, opc.HTTPStatus(rec.status), time.Since(t))
+	})
 }
 
 // Run starts serving the API
 func (a *App) Run(addr string) {
-	log.Fatal(http.ListenAndServe(addr, handlers.CORS(handlers.AllowedOrigins([]string{"*"}))(a.Router)))
+	log.Fatal(a.Server(addr).ListenAndServe())
 }
 
-// getTags returns all tags in the current opc connection, route: /tags
+// Server returns the HTTP server of the API, e.g. to stop it with Shutdown
+func (a *App) Server(addr string) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: handlers.CORS(handlers.AllowedOrigins([]string{"*"}))(a.Router),
+	}
+}
+
+// getTags returns a snapshot of all tags in the current opc connection, route: /tags
 func (a *App) getTags(w http.ResponseWriter, r *http.Request) {
-	respondWithJSON(w, http.StatusOK, a.Conn.Read())
+	snapshot := wire.NewSnapshot(a.conn(r).Read())
+	codec := wire.ForContentType(r.Header.Get("Accept"))
+	response, err := codec.MarshalSnapshot(snapshot)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithCodec(w, http.StatusOK, codec, response)
 }
 
 // createTag creates the tags in the opc connection, route: /tag
@@ -59,7 +212,14 @@ func (a *App) createTag(w http.ResponseWriter, r *http.Request) {
 		}
 		defer r.Body.Close()
 
-		err := a.Conn.Add(tags...)
+		foreign := a.foreign()
+		for _, tag := range tags {
+			if foreign(tag) {
+				respondWithError(w, http.StatusBadRequest, "tag of another server")
+				return
+			}
+		}
+		err := a.conn(r).Add(tags...)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Did not add tags")
 			return
@@ -70,23 +230,33 @@ func (a *App) createTag(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getTag returns the opc.Item for the given tag id, route: /tag/{id}
+// getTag returns the wire.Sample of the opc.Item for the given tag id, route: /tag/{id}
 func (a *App) getTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	item := a.Conn.ReadItem(vars["id"])
+	if a.foreign()(vars["id"]) {
+		respondWithError(w, http.StatusNotFound, "tag of another server")
+		return
+	}
+	item := a.conn(r).ReadItem(vars["id"])
 	empty := opc.Item{}
 	if item == empty {
 		respondWithError(w, http.StatusNotFound, "tag not found")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, item)
+	codec := wire.ForContentType(r.Header.Get("Accept"))
+	response, err := codec.MarshalSample(wire.NewSample(vars["id"], item))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithCodec(w, http.StatusOK, codec, response)
 }
 
 // deleteTag removes the tag in the opc connection
 func (a *App) deleteTag(w http.ResponseWriter, r *http.Request) {
 	if a.Config.DeleteTag {
 		vars := mux.Vars(r)
-		a.Conn.Remove(vars["id"])
+		a.conn(r).Remove(vars["id"])
 		respondWithJSON(w, http.StatusOK, map[string]interface{}{"result": "removed"})
 	} else {
 		respondWithError(w, http.StatusBadRequest, "deletions not allowed")
@@ -97,6 +267,10 @@ func (a *App) deleteTag(w http.ResponseWriter, r *http.Request) {
 func (a *App) updateTag(w http.ResponseWriter, r *http.Request) {
 	if a.Config.WriteTag {
 		vars := mux.Vars(r)
+		if a.foreign()(vars["id"]) {
+			respondWithError(w, http.StatusNotFound, "tag of another server")
+			return
+		}
 
 		var value interface{}
 		decoder := json.NewDecoder(r.Body)
@@ -107,7 +281,7 @@ func (a *App) updateTag(w http.ResponseWriter, r *http.Request) {
 		}
 		defer r.Body.Close()
 
-		err := a.Conn.Write(vars["id"], value)
+		err := a.conn(r).Write(vars["id"], value)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "value could not be written to tag")
 			return
@@ -118,6 +292,57 @@ func (a *App) updateTag(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getProperties returns the opc.TagInfo for the given tag id, route: /tag/{id}/properties
+func (a *App) getProperties(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if a.foreign()(vars["id"]) {
+		respondWithError(w, http.StatusNotFound, "tag of another server")
+		return
+	}
+	info, err := a.conn(r).Properties(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "properties not found")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, info)
+}
+
+// browse returns the opc.Tree of the OPC server or the namespace, route: /browse
+// optional query parameters: branch=name or branch=path to return a sub-branch only
+// and properties=true to include the properties of the leaves of the OPC server
+func (a *App) browse(w http.ResponseWriter, r *http.Request) {
+	browse := a.Browse
+	if browse == nil {
+		// without a browser, build the tree from the tags of the connection
+		browse = func() (*opc.Tree, error) {
+			return opc.TreeFromTags(a.Conn.Tags(), opc.DefaultTagSeparators), nil
+		}
+	}
+	tree, err := browse()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "browsing failed")
+		return
+	}
+	if a.Namespace != nil {
+		tree = a.Namespace.mount(tree)
+	}
+	if name := r.URL.Query().Get("branch"); name != "" {
+		if strings.Contains(name, opc.PathSeparator) {
+			tree = tree.Find(name)
+		} else {
+			tree = opc.ExtractBranchByName(tree, name)
+		}
+		if tree == nil {
+			respondWithError(w, http.StatusNotFound, "branch not found")
+			return
+		}
+	}
+	if r.URL.Query().Get("properties") == "true" {
+		a.loadProperties(a.conn(r), tree)
+	}
+	respondWithJSON(w, http.StatusOK, tree)
+}
+
 // responsWithError is a helper function to return a JSON error
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
@@ -131,3 +356,10 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(response)
 }
+
+// respondWithCodec is a helper function to return data encoded by a wire.Codec
+func respondWithCodec(w http.ResponseWriter, code int, codec wire.Codec, response []byte) {
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.WriteHeader(code)
+	w.Write(response)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/konimarti/opc"
 	"github.com/konimarti/opc/api"
+	"github.com/konimarti/opc/wire"
 )
 
 var a api.App
@@ -44,8 +45,12 @@ func TestEmptyTags(t *testing.T) {
 
 	checkResponseCode(t, http.StatusOK, response.Code)
 
-	if body := response.Body.String(); body != "{}" {
-		t.Errorf("Expected an empty map. Got %s", body)
+	var snapshot wire.Snapshot
+	if err := wire.JSON.UnmarshalSnapshot(response.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != wire.Version || len(snapshot.Samples) != 0 {
+		t.Errorf("Expected an empty snapshot. Got %s", response.Body.String())
 	}
 }
 
@@ -191,6 +196,53 @@ func TestUpdateTagReadOnly(t *testing.T) {
 	a.Config.WriteTag = true
 }
 
+// namespaceConn is a connection with the tag numeric.sin.float only
+type namespaceConn struct {
+	opc.Connection
+}
+
+func (namespaceConn) Tags() []string { return []string{"numeric.sin.float"} }
+
+func (namespaceConn) Properties(tag string) (opc.TagInfo, error) {
+	return opc.TagInfo{Description: tag}, nil
+}
+
+// test the browse-only namespace of several servers, route: /browse
+func TestNamespace(t *testing.T) {
+	local := opc.TreeFromTags([]string{"numeric.sin.float"}, ".")
+	other := opc.TreeFromTags([]string{"numeric.saw.float"}, ".")
+	app := api.App{Namespace: &api.Namespace{
+		Prefix:    "Plant1/ServerA",
+		Snapshots: map[string]*opc.Tree{"Plant1/ServerB": other},
+	}}
+	app.Initialize(namespaceConn{})
+	app.Browse = func() (*opc.Tree, error) { return local, nil }
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/browse?branch=Plant1&properties=true", nil)
+	app.Router.ServeHTTP(rr, req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var tree opc.Tree
+	if err := json.Unmarshal(rr.Body.Bytes(), &tree); err != nil {
+		t.Fatal(err)
+	}
+	a, b := tree.Find("ServerA/numeric/sin"), tree.Find("ServerB/numeric/saw")
+	if a == nil || b == nil {
+		t.Fatalf("servers not mounted: %s", rr.Body.String())
+	}
+	if a.Leaves[0].Info == nil || b.Leaves[0].Info != nil {
+		t.Error("properties must only be read for the tags of the connection")
+	}
+
+	// the tags of other servers are browse-only
+	for _, path := range []string{"/tag/numeric.saw.float", "/tag/numeric.saw.float/properties"} {
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		app.Router.ServeHTTP(rr, req)
+		checkResponseCode(t, http.StatusNotFound, rr.Code)
+	}
+}
+
 // test add TODO
 // test delete TODO
 
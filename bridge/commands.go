@@ -0,0 +1,315 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+// Value types of a WriteRule
+const (
+	TypeBool   = "bool"
+	TypeInt    = "int"   // written as int32
+	TypeFloat  = "float" // written as float64
+	TypeString = "string"
+)
+
+// ErrNotWritable is returned for tags that are not allowed by any WriteRule
+var ErrNotWritable = errors.New("bridge: tag is not writable")
+
+// CommandConfig configures the write-back of values from MQTT to the OPC server.
+// The topics are templates with the placeholder {tag}, e.g. "plant/cmd/{tag}". If
+// {tag} is the last element of the topic, the item ID may contain '/'.
+type CommandConfig struct {
+	Topic    string      `yaml:"topic" toml:"topic" json:"topic"`          // command topic template
+	Response string      `yaml:"response" toml:"response" json:"response"` // response topic template outside the command topics, e.g. "plant/response/{tag}"; empty disables responses
+	QoS      byte        `yaml:"qos" toml:"qos" json:"qos"`                // QoS of the subscription and the responses
+	Allow    []WriteRule `yaml:"allow" toml:"allow" json:"allow"`          // writable tags; nothing is writable without rules
+}
+
+// WriteRule allows writes to the tags matching its glob or 're:' patterns and
+// validates the values. The first matching rule is used.
+type WriteRule struct {
+	Tags []string `yaml:"tags" toml:"tags" json:"tags"`
+	Type string   `yaml:"type" toml:"type" json:"type"` // TypeBool, TypeInt, TypeFloat or TypeString; empty accepts all values
+	Min  *float64 `yaml:"min" toml:"min" json:"min"`    // minimum of numeric values
+	Max  *float64 `yaml:"max" toml:"max" json:"max"`    // maximum of numeric values
+}
+
+// CommandRequest is the payload of a command message. A payload that is not a
+// JSON object is used as value, e.g. "42" or "true".
+type CommandRequest struct {
+	Value         interface{} `json:"value"`
+	CorrelationID string      `json:"correlation_id"`
+}
+
+// CommandResponse is published on the response topic after every command
+type CommandResponse struct {
+	Tag           string    `json:"tag"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Success       bool      `json:"success"`
+	Error         string    `json:"error,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// writeRule is a compiled WriteRule
+type writeRule struct {
+	rule     WriteRule
+	patterns []*regexp.Regexp
+}
+
+// Commander writes the values of command messages to the OPC server
+type Commander struct {
+	cfg      CommandConfig
+	conn     opc.Connection
+	rules    []writeRule
+	prefix   string         // topic before {tag}
+	suffix   string         // topic after {tag}
+	response *regexp.Regexp // matches the response topics, nil without responses
+}
+
+// NewCommander checks the config and compiles the write rules. The response
+// topics must not match the command subscription, otherwise every response
+// would be received as the next command.
+func NewCommander(cfg CommandConfig, conn opc.Connection) (*Commander, error) {
+	if strings.Count(cfg.Topic, "{tag}") != 1 {
+		return nil, errors.New("bridge: command topic needs one {tag} placeholder")
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("bridge: invalid qos %d", cfg.QoS)
+	}
+	c := &Commander{cfg: cfg, conn: conn}
+	c.prefix, c.suffix, _ = strings.Cut(cfg.Topic, "{tag}")
+	if (c.prefix != "" && !strings.HasSuffix(c.prefix, "/")) || (c.suffix != "" && !strings.HasPrefix(c.suffix, "/")) {
+		return nil, errors.New("bridge: {tag} must be a complete topic level in " + cfg.Topic)
+	}
+	if strings.ContainsAny(cfg.Topic, "+#") {
+		return nil, errors.New("bridge: wildcards in command topic " + cfg.Topic)
+	}
+	if cfg.Response != "" {
+		if strings.ContainsAny(cfg.Response, "+#") {
+			return nil, errors.New("bridge: wildcards in response topic " + cfg.Response)
+		}
+		for _, tag := range []string{"tag", "a/tag"} {
+			if topicMatches(c.Subscription(), strings.ReplaceAll(cfg.Response, "{tag}", tag)) {
+				return nil, fmt.Errorf("bridge: response topic %s matches the command topic %s", cfg.Response, cfg.Topic)
+			}
+		}
+		parts := strings.Split(cfg.Response, "{tag}")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		c.response = regexp.MustCompile("^" + strings.Join(parts, ".+") + "$")
+	}
+	for _, rule := range cfg.Allow {
+		switch rule.Type {
+		case "", TypeBool, TypeInt, TypeFloat, TypeString:
+		default:
+			return nil, errors.New("bridge: unknown value type " + rule.Type)
+		}
+		r := writeRule{rule: rule}
+		for _, pattern := range rule.Tags {
+			re, err := opc.CompileTagPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			r.patterns = append(r.patterns, re)
+		}
+		c.rules = append(c.rules, r)
+	}
+	return c, nil
+}
+
+// Subscription returns the topic filter of the command topics
+func (c *Commander) Subscription() string {
+	if c.suffix == "" {
+		return c.prefix + "#"
+	}
+	return c.prefix + "+" + c.suffix
+}
+
+// QoS returns the QoS of the subscription
+func (c *Commander) QoS() byte {
+	return c.cfg.QoS
+}
+
+// IsResponse reports whether the topic is a response topic
+func (c *Commander) IsResponse(topic string) bool {
+	return c.response != nil && c.response.MatchString(topic)
+}
+
+// Tag returns the tag of a command topic. Response topics are not command topics.
+func (c *Commander) Tag(topic string) (string, bool) {
+	if c.IsResponse(topic) {
+		return "", false
+	}
+	if !strings.HasPrefix(topic, c.prefix) || !strings.HasSuffix(topic, c.suffix) || len(topic) <= len(c.prefix)+len(c.suffix) {
+		return "", false
+	}
+	return topic[len(c.prefix) : len(topic)-len(c.suffix)], true
+}
+
+// topicMatches reports whether the MQTT topic filter matches the topic
+func topicMatches(filter, topic string) bool {
+	filters, levels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range filters {
+		switch {
+		case f == "#":
+			return true
+		case i >= len(levels):
+			return false
+		case f != "+" && f != levels[i]:
+			return false
+		}
+	}
+	return len(filters) == len(levels)
+}
+
+// Handle writes the value of a command message to the tag of the topic and returns
+// the result and the response message, which is nil without response topic.
+// The write is bound to ctx with opc.WithContext.
+func (c *Commander) Handle(ctx context.Context, topic string, payload []byte) (CommandResponse, *Message) {
+	result := CommandResponse{Timestamp: time.Now()}
+	tag, valid := c.Tag(topic)
+	req, err := ParseCommand(payload)
+	result.Tag, result.CorrelationID = tag, req.CorrelationID
+	switch {
+	case !valid:
+		err = errors.New("bridge: not a command topic: " + topic)
+	case err == nil:
+		err = c.Write(ctx, tag, req.Value)
+	}
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if c.cfg.Response == "" || !valid {
+		return result, nil
+	}
+	b, _ := json.Marshal(result)
+	responseTopic := strings.ReplaceAll(c.cfg.Response, "{tag}", strings.NewReplacer("+", "_", "#", "_").Replace(tag))
+	return result, &Message{Topic: responseTopic, Payload: b, QoS: c.cfg.QoS}
+}
+
+// Write checks that the tag is writable, validates and converts the value and
+// writes it to the OPC server with the connection bound to ctx.
+func (c *Commander) Write(ctx context.Context, tag string, value interface{}) error {
+	rule, ok := c.rule(tag)
+	if !ok {
+		return ErrNotWritable
+	}
+	v, err := rule.convert(value)
+	if err != nil {
+		return fmt.Errorf("bridge: invalid value for %s: %v", tag, err)
+	}
+	return opc.WithContext(ctx, c.conn).Write(tag, v)
+}
+
+// rule returns the first write rule matching the tag
+func (c *Commander) rule(tag string) (WriteRule, bool) {
+	for _, r := range c.rules {
+		for _, re := range r.patterns {
+			if re.MatchString(tag) {
+				return r.rule, true
+			}
+		}
+	}
+	return WriteRule{}, false
+}
+
+// convert converts the value to the type of the rule and checks the limits
+func (r WriteRule) convert(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, opc.ErrNoValue
+	}
+	if n, ok := value.(json.Number); ok {
+		value = numberValue(n)
+	}
+	item := opc.Item{Value: value}
+	var v interface{}
+	var err error
+	switch r.Type {
+	case TypeBool:
+		v, err = item.Bool()
+	case TypeInt:
+		if f, ferr := item.Float64(); ferr == nil && f != math.Trunc(f) {
+			return nil, fmt.Errorf("%v is not an integer", f)
+		}
+		var n int64
+		if n, err = item.Int64(); err == nil {
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("%d out of int32 range", n)
+			}
+			v = int32(n)
+		}
+	case TypeFloat:
+		v, err = item.Float64()
+	case TypeString:
+		v, err = item.Text()
+	default:
+		v = value
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Min != nil || r.Max != nil {
+		f, err := opc.Item{Value: v}.Float64()
+		if err != nil {
+			return nil, errors.New("limits need a numeric value")
+		}
+		if r.Min != nil && f < *r.Min {
+			return nil, fmt.Errorf("%v below minimum %v", v, *r.Min)
+		}
+		if r.Max != nil && f > *r.Max {
+			return nil, fmt.Errorf("%v above maximum %v", v, *r.Max)
+		}
+	}
+	return v, nil
+}
+
+// numberValue returns a JSON number as int32, int64 or float64
+func numberValue(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			return int32(i)
+		}
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// ParseCommand decodes a JSON CommandRequest or a plain value. Plain values are
+// JSON scalars like 42 or true or otherwise the text of the payload.
+func ParseCommand(payload []byte) (CommandRequest, error) {
+	var req CommandRequest
+	trimmed := bytes.TrimSpace(payload)
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		if err := decoder.Decode(&req); err != nil {
+			return req, err
+		}
+		if req.Value == nil {
+			return req, errors.New("bridge: missing value")
+		}
+		return req, nil
+	}
+	if err := decoder.Decode(&req.Value); err != nil || decoder.More() {
+		req.Value = string(trimmed)
+	}
+	if len(trimmed) == 0 {
+		return req, errors.New("bridge: empty command")
+	}
+	return req, nil
+}
@@ -0,0 +1,152 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/konimarti/opc"
+)
+
+// writeConnection records the written values
+type writeConnection struct {
+	opc.Connection
+	written map[string]interface{}
+}
+
+func (c *writeConnection) Write(tag string, value interface{}) error {
+	if tag == "broken.tag" {
+		return errors.New("write failed")
+	}
+	c.written[tag] = value
+	return nil
+}
+
+func newTestCommander(t *testing.T, topic string) (*Commander, *writeConnection) {
+	conn := &writeConnection{written: make(map[string]interface{})}
+	min, max := 0.0, 100.0
+	c, err := NewCommander(CommandConfig{
+		Topic:    topic,
+		Response: "plant/response/{tag}",
+		QoS:      1,
+		Allow: []WriteRule{
+			{Tags: []string{"setpoints.*"}, Type: TypeFloat, Min: &min, Max: &max},
+			{Tags: []string{"modes.*"}, Type: TypeInt},
+			{Tags: []string{"flags.*"}, Type: TypeBool},
+			{Tags: []string{"broken.tag", "re:^free\\."}},
+		},
+	}, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, conn
+}
+
+func TestCommander(t *testing.T) {
+	c, conn := newTestCommander(t, "plant/cmd/{tag}")
+	if c.Subscription() != "plant/cmd/#" || c.QoS() != 1 {
+		t.Errorf("wrong subscription %s", c.Subscription())
+	}
+
+	cases := []struct {
+		topic, payload string
+		success        bool
+		written        interface{}
+	}{
+		{"plant/cmd/setpoints.temp", `{"value": 42.5, "correlation_id": "c1"}`, true, 42.5},
+		{"plant/cmd/setpoints.temp", `120`, false, nil},
+		{"plant/cmd/setpoints.temp", `"warm"`, false, nil},
+		{"plant/cmd/modes.pump", `3`, true, int32(3)},
+		{"plant/cmd/modes.pump", `3.5`, false, nil},
+		{"plant/cmd/flags.alarm", `true`, true, true},
+		{"plant/cmd/free.text", `hello world`, true, "hello world"},
+		{"plant/cmd/free.number", `7`, true, int32(7)},
+		{"plant/cmd/other.tag", `1`, false, nil},
+		{"plant/cmd/broken.tag", `1`, false, nil},
+		{"plant/cmd/setpoints.temp", `{"correlation_id": "c2"}`, false, nil},
+	}
+	for _, tc := range cases {
+		conn.written = make(map[string]interface{})
+		result, msg := c.Handle(context.Background(), tc.topic, []byte(tc.payload))
+		if msg == nil {
+			t.Fatalf("%s: no response", tc.topic)
+		}
+		var response CommandResponse
+		if err := json.Unmarshal(msg.Payload, &response); err != nil {
+			t.Fatal(err)
+		}
+		if result.Success != response.Success {
+			t.Errorf("%s: result and response differ", tc.topic)
+		}
+		tag, _ := c.Tag(tc.topic)
+		if response.Success != tc.success || response.Tag != tag || msg.Topic != "plant/response/"+tag || msg.QoS != 1 {
+			t.Errorf("%s %s: wrong response %s %+v", tc.topic, tc.payload, msg.Topic, response)
+		}
+		if !tc.success && response.Error == "" {
+			t.Errorf("%s %s: missing error", tc.topic, tc.payload)
+		}
+		if tc.written != nil && conn.written[tag] != tc.written {
+			t.Errorf("%s %s: expected %v (%T), got %v (%T)", tc.topic, tc.payload, tc.written, tc.written, conn.written[tag], conn.written[tag])
+		}
+		if tc.written == nil && len(conn.written) > 0 {
+			t.Errorf("%s %s: unexpected write %v", tc.topic, tc.payload, conn.written)
+		}
+	}
+
+	result, _ := c.Handle(context.Background(), "plant/cmd/setpoints.temp", []byte(`{"value": 1, "correlation_id": "abc"}`))
+	if result.CorrelationID != "abc" || !result.Success {
+		t.Errorf("wrong result %+v", result)
+	}
+	if err := c.Write(context.Background(), "other.tag", 1); err != ErrNotWritable {
+		t.Errorf("expected ErrNotWritable, got %v", err)
+	}
+}
+
+func TestCommanderTopics(t *testing.T) {
+	c, _ := newTestCommander(t, "plant/{tag}/set")
+	if c.Subscription() != "plant/+/set" {
+		t.Errorf("wrong subscription %s", c.Subscription())
+	}
+	if tag, ok := c.Tag("plant/modes.pump/set"); !ok || tag != "modes.pump" {
+		t.Errorf("wrong tag %s", tag)
+	}
+	if _, ok := c.Tag("plant/modes.pump/get"); ok {
+		t.Error("tag of other topic")
+	}
+
+	for _, cfg := range []CommandConfig{
+		{Topic: "plant/cmd"},
+		{Topic: "plant/cmd-{tag}"},
+		{Topic: "plant/+/{tag}"},
+		{Topic: "plant/{tag}", QoS: 3},
+		{Topic: "plant/{tag}", Allow: []WriteRule{{Type: "decimal"}}},
+		{Topic: "plant/{tag}", Allow: []WriteRule{{Tags: []string{"re:("}}}},
+		{Topic: "plant/cmd/{tag}", Response: "plant/cmd/{tag}/response"},
+		{Topic: "plant/cmd/{tag}", Response: "plant/cmd/responses"},
+		{Topic: "plant/{tag}/set", Response: "plant/{tag}/set"},
+		{Topic: "plant/{tag}", Response: "plant/+/{tag}"},
+	} {
+		if _, err := NewCommander(cfg, nil); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestCommanderIgnoresResponses(t *testing.T) {
+	c, err := NewCommander(CommandConfig{
+		Topic:    "plant/{tag}/set",
+		Response: "plant/{tag}/set/response",
+		Allow:    []WriteRule{{Tags: []string{"**"}}},
+	}, &writeConnection{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IsResponse("plant/modes.pump/set") || !c.IsResponse("plant/modes.pump/set/response") {
+		t.Error("wrong response topic detection")
+	}
+	result, response := c.Handle(context.Background(), "plant/modes.pump/set/response", []byte(`{"value": 1}`))
+	if result.Success || response != nil {
+		t.Errorf("response handled as command: %+v %v", result, response)
+	}
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+// ExceptionConfig configures report-by-exception: a tag is only reported if its
+// value changed by more than the deadband, its quality changed or it was not
+// reported for the heartbeat interval. Without deadbands, every change is reported.
+type ExceptionConfig struct {
+	Deadband  float64        `yaml:"deadband" toml:"deadband" json:"deadband"`    // absolute deadband of numeric values
+	Percent   float64        `yaml:"percent" toml:"percent" json:"percent"`       // deadband in percent of the last reported value
+	Heartbeat string         `yaml:"heartbeat" toml:"heartbeat" json:"heartbeat"` // maximum silence of a tag, e.g. "5m"; empty disables the heartbeat
+	Deadbands []DeadbandRule `yaml:"deadbands" toml:"deadbands" json:"deadbands"` // deadbands of individual tags
+}
+
+// DeadbandRule sets the deadbands of the tags matching its glob or 're:' patterns.
+// The first matching rule is used.
+type DeadbandRule struct {
+	Tags     []string `yaml:"tags" toml:"tags" json:"tags"`
+	Deadband float64  `yaml:"deadband" toml:"deadband" json:"deadband"`
+	Percent  float64  `yaml:"percent" toml:"percent" json:"percent"`
+}
+
+// deadband is a compiled DeadbandRule
+type deadband struct {
+	patterns []*regexp.Regexp
+	absolute float64
+	percent  float64
+}
+
+// reported is the last reported state of a tag
+type reported struct {
+	value   interface{}
+	quality opc.Quality
+	time    time.Time
+}
+
+// Exception filters the items of consecutive reads by exception.
+// Reset may be called concurrently, e.g. from a reconnect handler.
+type Exception struct {
+	heartbeat time.Duration
+	fallback  deadband
+	deadbands []deadband
+
+	mu   sync.Mutex
+	last map[string]reported
+}
+
+// NewException compiles the deadbands of the config
+func NewException(cfg ExceptionConfig) (*Exception, error) {
+	e := &Exception{
+		fallback: deadband{absolute: cfg.Deadband, percent: cfg.Percent},
+		last:     make(map[string]reported),
+	}
+	if cfg.Heartbeat != "" {
+		heartbeat, err := time.ParseDuration(cfg.Heartbeat)
+		if err != nil {
+			return nil, fmt.Errorf("bridge: invalid heartbeat: %v", err)
+		}
+		e.heartbeat = heartbeat
+	}
+	for _, rule := range cfg.Deadbands {
+		d := deadband{absolute: rule.Deadband, percent: rule.Percent}
+		for _, pattern := range rule.Tags {
+			re, err := opc.CompileTagPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			d.patterns = append(d.patterns, re)
+		}
+		e.deadbands = append(e.deadbands, d)
+	}
+	for _, d := range append(e.deadbands, e.fallback) {
+		if d.absolute < 0 || d.percent < 0 {
+			return nil, errors.New("bridge: negative deadband")
+		}
+	}
+	return e, nil
+}
+
+// Filter returns the items to report at time now. The items are reported again
+// until they are passed to Commit.
+func (e *Exception) Filter(items map[string]opc.Item, now time.Time) map[string]opc.Item {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	output := make(map[string]opc.Item)
+	for tag, item := range items {
+		last, ok := e.last[tag]
+		if ok && !e.changed(tag, last, item) && (e.heartbeat == 0 || now.Sub(last.time) < e.heartbeat) {
+			continue
+		}
+		output[tag] = item
+	}
+	return output
+}
+
+// Commit remembers the items returned by Filter as reported at time now, i.e.
+// after they were published or queued.
+func (e *Exception) Commit(items map[string]opc.Item, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for tag, item := range items {
+		e.last[tag] = reported{value: item.Value, quality: item.Quality, time: now}
+	}
+}
+
+// Reset forgets the reported values, e.g. after a reconnect, so that all tags
+// are reported with the next call of Filter.
+func (e *Exception) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = make(map[string]reported)
+}
+
+// changed checks if the item differs from the last reported state of the tag
+func (e *Exception) changed(tag string, last reported, item opc.Item) bool {
+	if item.Quality != last.quality {
+		return true
+	}
+	previous, err1 := opc.Item{Value: last.value}.Float64()
+	current, err2 := item.Float64()
+	_, isString := item.Value.(string)
+	if err1 != nil || err2 != nil || isString {
+		return !reflect.DeepEqual(item.Value, last.value)
+	}
+	d := e.deadband(tag)
+	diff := math.Abs(current - previous)
+	if d.absolute == 0 && d.percent == 0 {
+		return diff != 0
+	}
+	if d.absolute > 0 && diff > d.absolute {
+		return true
+	}
+	return d.percent > 0 && diff > math.Abs(previous)*d.percent/100
+}
+
+// deadband returns the deadband of the first matching rule or the default
+func (e *Exception) deadband(tag string) deadband {
+	for _, d := range e.deadbands {
+		for _, re := range d.patterns {
+			if re.MatchString(tag) {
+				return d
+			}
+		}
+	}
+	return e.fallback
+}
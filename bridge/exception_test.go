@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+func TestException(t *testing.T) {
+	e, err := NewException(ExceptionConfig{
+		Deadband:  0.5,
+		Heartbeat: "1m",
+		Deadbands: []DeadbandRule{{Tags: []string{"pct.*"}, Percent: 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := func(v interface{}) opc.Item {
+		return opc.Item{Value: v, Quality: opc.OPCQualityGood, Timestamp: time.Now()}
+	}
+	start := time.Now()
+
+	steps := []struct {
+		items    map[string]opc.Item
+		after    time.Duration
+		expected []string
+	}{
+		{map[string]opc.Item{"abs.a": item(10.0), "pct.a": item(100.0), "text": item("on")}, 0, []string{"abs.a", "pct.a", "text"}},
+		{map[string]opc.Item{"abs.a": item(10.4), "pct.a": item(109.0), "text": item("on")}, time.Second, nil},
+		{map[string]opc.Item{"abs.a": item(10.6), "pct.a": item(111.0), "text": item("off")}, 2 * time.Second, []string{"abs.a", "pct.a", "text"}},
+		{map[string]opc.Item{"abs.a": {Value: 10.6, Quality: opc.OPCQualityBad}}, 3 * time.Second, []string{"abs.a"}},
+		{map[string]opc.Item{"pct.a": item(111.0), "text": item("off")}, 2*time.Second + time.Minute, []string{"pct.a", "text"}},
+	}
+	for i, step := range steps {
+		output := e.Filter(step.items, start.Add(step.after))
+		e.Commit(output, start.Add(step.after))
+		if len(output) != len(step.expected) {
+			t.Errorf("step %d: expected %v, got %v", i, step.expected, output)
+			continue
+		}
+		for _, tag := range step.expected {
+			if _, ok := output[tag]; !ok {
+				t.Errorf("step %d: %s not reported", i, tag)
+			}
+		}
+	}
+
+	e.Reset()
+	if output := e.Filter(map[string]opc.Item{"abs.a": item(10.6)}, start); len(output) != 1 {
+		t.Error("tag not reported after reset")
+	}
+}
+
+func TestExceptionEveryChange(t *testing.T) {
+	e, err := NewException(ExceptionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	e.Commit(e.Filter(map[string]opc.Item{"a": {Value: int32(1)}}, now), now)
+	if len(e.Filter(map[string]opc.Item{"a": {Value: int32(1)}}, now.Add(time.Hour))) != 0 {
+		t.Error("unchanged value reported without heartbeat")
+	}
+	if len(e.Filter(map[string]opc.Item{"a": {Value: int32(2)}}, now.Add(time.Hour))) != 1 {
+		t.Error("change not reported")
+	}
+
+	for _, cfg := range []ExceptionConfig{{Heartbeat: "sometimes"}, {Deadband: -1}, {Deadbands: []DeadbandRule{{Tags: []string{"re:("}}}}} {
+		if _, err := NewException(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestExceptionCommit(t *testing.T) {
+	e, err := NewException(ExceptionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	items := map[string]opc.Item{"a": {Value: int32(1)}}
+	if len(e.Filter(items, now)) != 1 {
+		t.Fatal("first value not reported")
+	}
+	// e.g. the publish failed
+	if len(e.Filter(items, now.Add(time.Second))) != 1 {
+		t.Error("uncommitted value not reported again")
+	}
+	e.Commit(items, now.Add(time.Second))
+	if len(e.Filter(items, now.Add(2*time.Second))) != 0 {
+		t.Error("committed value reported again")
+	}
+}
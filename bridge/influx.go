@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"context"
+	"strings"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/models"
+	"github.com/konimarti/opc"
+)
+
+// rejections are the messages of InfluxDB 1.x for points that are rejected for
+// good (HTTP 400); writing them again fails the same way
+var rejections = []string{
+	"unable to parse",
+	"partial write",
+	"field type conflict",
+	"points beyond retention policy",
+	"max-values-per-tag limit exceeded",
+}
+
+// InfluxRejected reports whether InfluxDB rejected the points of a write for good,
+// e.g. because of a line protocol error or a field type conflict. All other errors,
+// like an unreachable server, 5xx responses or a database that does not exist yet,
+// are temporary and the points should be written again later.
+func InfluxRejected(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, r := range rejections {
+		if strings.Contains(msg, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalBatch encodes the points of a batch as line protocol with ns timestamps,
+// e.g. to append them to a Queue. It returns nil for an empty batch.
+func MarshalBatch(bp client.BatchPoints) []byte {
+	lines := make([]string, 0, len(bp.Points()))
+	for _, pt := range bp.Points() {
+		if pt != nil {
+			lines = append(lines, pt.String())
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// ForwardBatches writes the batches of the queue in order with the client. It stops
+// at the first temporary error, so the batch stays queued; rejected batches and
+// batches that cannot be parsed are dropped instead of blocking the queue. It also
+// stops when ctx is done, e.g. to bound the final forward on shutdown.
+func ForwardBatches(ctx context.Context, q *Queue, c client.Client, cfg client.BatchPointsConfig) (int, error) {
+	return q.Forward(func(data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		points, err := models.ParsePoints(data)
+		if err != nil {
+			opc.Logger().Error("dropping invalid queued points", "queue", q.name, "error", err)
+			return nil
+		}
+		bp, err := client.NewBatchPoints(cfg)
+		if err != nil {
+			return err
+		}
+		for _, pt := range points {
+			bp.AddPoint(client.NewPointFrom(pt))
+		}
+		err = c.Write(bp)
+		if InfluxRejected(err) {
+			opc.Logger().Error("dropping points rejected by influx", "queue", q.name, "points", len(points), "error", err)
+			return nil
+		}
+		return err
+	})
+}
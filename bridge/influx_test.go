@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// influxClient fails the writes with the errors in order and records the written points
+type influxClient struct {
+	client.Client
+	errs    []error
+	written []*client.Point
+}
+
+func (c *influxClient) Write(bp client.BatchPoints) error {
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	c.written = append(c.written, bp.Points()...)
+	return nil
+}
+
+func TestForwardBatches(t *testing.T) {
+	q, err := OpenQueue("influx", QueueConfig{Dir: t.TempDir(), Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	ctx := context.Background()
+	cfg := client.BatchPointsConfig{Database: "test", Precision: "s"}
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 123, time.UTC)
+	for i := 0; i < 3; i++ {
+		bp, _ := client.NewBatchPoints(cfg)
+		pt, _ := client.NewPoint("numeric", map[string]string{"type": "sin"}, map[string]interface{}{"float": float64(i)}, ts.Add(time.Duration(i)*time.Second))
+		bp.AddPoint(pt)
+		if err := q.Append(MarshalBatch(bp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// InfluxDB is starting or overloaded: the batches stay queued
+	c := &influxClient{errs: []error{
+		errors.New(`{"error":"database not found: \"test\""}`),
+	}}
+	if n, err := ForwardBatches(ctx, q, c, cfg); n != 0 || err == nil || q.Len() != 3 {
+		t.Fatalf("batch dropped on temporary error: %d forwarded, %d queued: %v", n, q.Len(), err)
+	}
+	c.errs = []error{errors.New("503 Service Unavailable")}
+	if n, _ := ForwardBatches(ctx, q, c, cfg); n != 0 || q.Len() != 3 {
+		t.Fatalf("batch dropped on 5xx error: %d queued", q.Len())
+	}
+
+	// the second batch is rejected for good and dropped
+	c.errs = []error{nil, errors.New(`{"error":"partial write: field type conflict: input field \"float\" on measurement \"numeric\" is type float, already exists as type string dropped=1"}`)}
+	n, err := ForwardBatches(ctx, q, c, cfg)
+	if n != 3 || err != nil || q.Len() != 0 {
+		t.Fatalf("%d forwarded, %d queued: %v", n, q.Len(), err)
+	}
+	if len(c.written) != 2 || !c.written[0].Time().Equal(ts) || !c.written[1].Time().Equal(ts.Add(2*time.Second)) {
+		t.Errorf("wrong points %v", c.written)
+	}
+
+	// nothing is written after the context is done
+	appendRecords(t, q, 0, 1)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if n, err := ForwardBatches(cancelled, q, c, cfg); n != 0 || !errors.Is(err, context.Canceled) || q.Len() != 1 {
+		t.Errorf("forwarded after cancel: %d forwarded, %d queued: %v", n, q.Len(), err)
+	}
+
+	if InfluxRejected(nil) || InfluxRejected(errors.New("dial tcp: connection refused")) || !InfluxRejected(errors.New(`{"error":"unable to parse 'x': missing fields"}`)) {
+		t.Error("wrong classification")
+	}
+}
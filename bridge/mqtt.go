@@ -0,0 +1,181 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Prefixes of secrets that are loaded from the environment or from files
+const (
+	SecretEnv  = "env:"  // e.g. "env:MQTT_PASSWORD"
+	SecretFile = "file:" // e.g. "file:/run/secrets/mqtt_password"
+)
+
+// DefaultClientID is the client ID template if none is configured. It is unique
+// per process, so that several bridges on one host do not disconnect each other.
+const DefaultClientID = "opcmqtt-{hostname}-{pid}"
+
+// MQTTOptions configure the connection to the MQTT broker. Username, password
+// and the TLS key can be loaded with SecretEnv or SecretFile.
+type MQTTOptions struct {
+	Addr         string    `yaml:"addr" toml:"addr" json:"addr"`                            // broker URL, e.g. "tcp://localhost:1883" or "ssl://broker:8883"
+	Username     string    `yaml:"username" toml:"username" json:"username"`                // user name or secret reference
+	Password     string    `yaml:"password" toml:"password" json:"password"`                // password or secret reference
+	ClientID     string    `yaml:"client_id" toml:"client_id" json:"client_id"`             // template with {hostname}, {pid} and {random}, default DefaultClientID
+	CleanSession *bool     `yaml:"clean_session" toml:"clean_session" json:"clean_session"` // default true
+	KeepAlive    string    `yaml:"keepalive" toml:"keepalive" json:"keepalive"`             // duration, e.g. "30s"
+	TLS          TLSConfig `yaml:"tls" toml:"tls" json:"tls"`
+}
+
+// TLSConfig configures TLS with a custom CA and client certificates.
+// Use a "ssl://", "tls://" or "mqtts://" broker URL for TLS connections.
+type TLSConfig struct {
+	CA                 string `yaml:"ca" toml:"ca" json:"ca"`                                                       // PEM file of the CA certificates, default system roots
+	Cert               string `yaml:"cert" toml:"cert" json:"cert"`                                                 // PEM file of the client certificate
+	Key                string `yaml:"key" toml:"key" json:"key"`                                                    // PEM file of the client key or secret reference
+	ServerName         string `yaml:"server_name" toml:"server_name" json:"server_name"`                            // name to verify the server certificate
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" toml:"insecure_skip_verify" json:"insecure_skip_verify"` // do not verify the server certificate
+}
+
+// Enabled returns true if any TLS option is set
+func (c TLSConfig) Enabled() bool {
+	return c != TLSConfig{}
+}
+
+// Config loads the certificates and returns the tls.Config
+func (c TLSConfig) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("bridge: no certificates in " + c.CA)
+		}
+	}
+	if c.Cert != "" || c.Key != "" {
+		cert, err := os.ReadFile(c.Cert)
+		if err != nil {
+			return nil, err
+		}
+		key, err := loadKey(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// loadKey reads the key from a file or a secret reference
+func loadKey(key string) ([]byte, error) {
+	if strings.HasPrefix(key, SecretEnv) || strings.HasPrefix(key, SecretFile) {
+		s, err := ResolveSecret(key)
+		return []byte(s), err
+	}
+	return os.ReadFile(key)
+}
+
+// ClientOptions returns the paho client options of the broker connection
+func (o MQTTOptions) ClientOptions() (*mqtt.ClientOptions, error) {
+	if o.Addr == "" {
+		return nil, errors.New("bridge: missing broker address")
+	}
+	opts := mqtt.NewClientOptions().AddBroker(o.Addr)
+
+	clientID := o.ClientID
+	if clientID == "" {
+		clientID = DefaultClientID
+	}
+	opts.SetClientID(ClientID(clientID))
+
+	username, err := ResolveSecret(o.Username)
+	if err != nil {
+		return nil, err
+	}
+	password, err := ResolveSecret(o.Password)
+	if err != nil {
+		return nil, err
+	}
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+
+	if o.CleanSession != nil {
+		opts.SetCleanSession(*o.CleanSession)
+	}
+	if o.KeepAlive != "" {
+		keepAlive, err := time.ParseDuration(o.KeepAlive)
+		if err != nil {
+			return nil, fmt.Errorf("bridge: invalid keepalive: %v", err)
+		}
+		opts.SetKeepAlive(keepAlive)
+	}
+
+	if o.TLS.Enabled() {
+		config, err := o.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(config)
+	}
+	return opts, nil
+}
+
+// ClientID replaces the placeholders {hostname}, {pid} and {random} of a client ID template
+func ClientID(template string) string {
+	return placeholder.ReplaceAllStringFunc(template, func(s string) string {
+		switch s {
+		case "{hostname}":
+			hostname, _ := os.Hostname()
+			return hostname
+		case "{pid}":
+			return strconv.Itoa(os.Getpid())
+		case "{random}":
+			b := make([]byte, 4)
+			rand.Read(b)
+			return hex.EncodeToString(b)
+		}
+		return s
+	})
+}
+
+// ResolveSecret returns the value of an environment variable for SecretEnv, the
+// trimmed content of a file for SecretFile or the string itself otherwise.
+func ResolveSecret(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, SecretEnv):
+		name := strings.TrimPrefix(s, SecretEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("bridge: environment variable " + name + " not set")
+		}
+		return value, nil
+	case strings.HasPrefix(s, SecretFile):
+		b, err := os.ReadFile(strings.TrimPrefix(s, SecretFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return s, nil
+}
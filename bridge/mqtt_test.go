@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate creates a self-signed certificate and its key in dir
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "opcmqtt"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestClientOptions(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	passwordFile := filepath.Join(dir, "password")
+	os.WriteFile(passwordFile, []byte("s3cret\n"), 0600)
+	t.Setenv("OPCMQTT_USER", "bridge")
+
+	clean := false
+	opts, err := MQTTOptions{
+		Addr:         "ssl://localhost:8883",
+		Username:     "env:OPCMQTT_USER",
+		Password:     "file:" + passwordFile,
+		ClientID:     "opc-{pid}",
+		CleanSession: &clean,
+		KeepAlive:    "15s",
+		TLS:          TLSConfig{CA: certFile, Cert: certFile, Key: keyFile},
+	}.ClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Username != "bridge" || opts.Password != "s3cret" {
+		t.Errorf("wrong credentials: %s %s", opts.Username, opts.Password)
+	}
+	if opts.ClientID != "opc-"+strconv.Itoa(os.Getpid()) || opts.CleanSession || opts.KeepAlive != 15 {
+		t.Errorf("wrong session options: %s %v %d", opts.ClientID, opts.CleanSession, opts.KeepAlive)
+	}
+	if opts.TLSConfig == nil || opts.TLSConfig.RootCAs == nil || len(opts.TLSConfig.Certificates) != 1 {
+		t.Error("TLS not configured")
+	}
+
+	opts, err = MQTTOptions{Addr: "tcp://localhost:1883"}.ClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(opts.ClientID, "opcmqtt-") || !opts.CleanSession || opts.TLSConfig != nil {
+		t.Errorf("wrong default options: %+v", opts)
+	}
+}
+
+func TestClientOptionsErrors(t *testing.T) {
+	for _, o := range []MQTTOptions{
+		{},
+		{Addr: "tcp://localhost:1883", Password: "env:OPCMQTT_UNDEFINED_PASSWORD"},
+		{Addr: "tcp://localhost:1883", Password: "file:/nonexistent/password"},
+		{Addr: "tcp://localhost:1883", KeepAlive: "often"},
+		{Addr: "ssl://localhost:8883", TLS: TLSConfig{CA: "/nonexistent/ca.pem"}},
+	} {
+		if _, err := o.ClientOptions(); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	hostname, _ := os.Hostname()
+	if id := ClientID("opc-{hostname}"); id != "opc-"+hostname {
+		t.Errorf("wrong client ID %s", id)
+	}
+	if a, b := ClientID("{random}"), ClientID("{random}"); a == b || len(a) != 8 {
+		t.Errorf("client IDs not random: %s %s", a, b)
+	}
+	if id := ClientID("opc-{unknown}"); id != "opc-{unknown}" {
+		t.Errorf("unknown placeholder replaced: %s", id)
+	}
+}
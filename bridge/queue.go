@@ -0,0 +1,528 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+// Sync policies of a Queue
+const (
+	SyncAlways  = "always"  // fsync after every record and acknowledgment
+	SyncSegment = "segment" // fsync when a segment is full
+	SyncNever   = "never"   // leave it to the operating system
+)
+
+// DefaultSegmentSize is the size of the segment files if not configured
+const DefaultSegmentSize = 8 << 20
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+	headerSize = 8 // length and CRC-32 of a record
+)
+
+// QueueConfig configures a durable queue that buffers the outgoing data of a
+// bridge while the broker or database is unreachable.
+type QueueConfig struct {
+	Dir         string `yaml:"dir" toml:"dir" json:"dir"`                            // directory of the segment files
+	SegmentSize int64  `yaml:"segment_size" toml:"segment_size" json:"segment_size"` // bytes per segment file, default 8 MiB
+	MaxSize     int64  `yaml:"max_size" toml:"max_size" json:"max_size"`             // bytes of all segments, the oldest are dropped; 0 is unlimited
+	MaxAge      string `yaml:"max_age" toml:"max_age" json:"max_age"`                // drop segments older than this duration, e.g. "72h"; empty is unlimited
+	Sync        string `yaml:"sync" toml:"sync" json:"sync"`                         // SyncAlways (default), SyncSegment or SyncNever
+}
+
+// segment is a file of records named after its sequence number
+type segment struct {
+	id      uint64
+	size    int64 // bytes in the file
+	entries int   // records after the read position
+	modTime time.Time
+}
+
+// Queue is a durable FIFO queue of records stored in segment files. Records are
+// appended to the last segment and forwarded from the first one; the read position
+// is kept in a cursor file, so that records survive restarts. Records are forwarded
+// at least once: a record is sent again after a crash before its acknowledgment.
+// The backlog is reported with opc.DefaultMetrics.SetBacklog. It is safe for
+// concurrent use.
+type Queue struct {
+	name   string
+	cfg    QueueConfig
+	maxAge time.Duration
+
+	forwarding sync.Mutex // serializes Forward
+
+	mu       sync.Mutex
+	segments []*segment // oldest first, the last one is written
+	w        *os.File   // last segment
+	r        *os.File   // first segment
+	head     int64      // offset of the next record in the first segment
+	lastID   uint64     // sequence number of the last segment
+	dropped  int
+}
+
+// OpenQueue opens or creates the queue in cfg.Dir. The name is used as component
+// of the backlog metric, e.g. "mqtt". A damaged tail of the last segment, e.g.
+// after a power failure, is truncated.
+func OpenQueue(name string, cfg QueueConfig) (*Queue, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("bridge: queue needs a directory")
+	}
+	switch cfg.Sync {
+	case "":
+		cfg.Sync = SyncAlways
+	case SyncAlways, SyncSegment, SyncNever:
+	default:
+		return nil, errors.New("bridge: unknown sync policy " + cfg.Sync)
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	q := &Queue{name: name, cfg: cfg}
+	if cfg.MaxAge != "" {
+		d, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("bridge: invalid max_age: %v", err)
+		}
+		q.maxAge = d
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := q.load(); err != nil {
+		q.Close()
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.enforceLimits(time.Now()); err != nil {
+		q.close()
+		return nil, err
+	}
+	q.report()
+	return q, nil
+}
+
+// load scans the segment files and opens the last one for writing
+func (q *Queue) load() error {
+	names, err := filepath.Glob(filepath.Join(q.cfg.Dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	var ids []uint64
+	for _, name := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	cursorID, cursorOffset := q.readCursor()
+	q.lastID = cursorID
+	for i, id := range ids {
+		if id < cursorID {
+			// forwarded before the last shutdown
+			os.Remove(q.path(id))
+			continue
+		}
+		q.lastID = id
+		offset := int64(0)
+		if id == cursorID {
+			offset = cursorOffset
+		}
+		s, err := q.scan(id, offset, i == len(ids)-1)
+		if err != nil {
+			return err
+		}
+		if len(q.segments) == 0 {
+			q.head = offset
+		}
+		q.segments = append(q.segments, s)
+	}
+
+	if len(q.segments) == 0 {
+		return q.rotate()
+	}
+	last := q.segments[len(q.segments)-1]
+	q.w, err = os.OpenFile(q.path(last.id), os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// scan counts the records of a segment after the offset. The damaged tail of the
+// last segment is truncated; other segments keep their valid records.
+func (q *Queue) scan(id uint64, offset int64, last bool) (*segment, error) {
+	f, err := os.Open(q.path(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{id: id, size: info.Size(), modTime: info.ModTime()}
+	if offset > s.size {
+		offset = s.size
+	}
+	pos := int64(0)
+	for pos < s.size {
+		data, err := readRecord(f, pos, s.size)
+		if err != nil {
+			opc.Logger().Warn("damaged queue segment", "queue", q.name, "segment", q.path(id), "offset", pos, "error", err)
+			break
+		}
+		if pos >= offset {
+			s.entries++
+		}
+		pos += headerSize + int64(len(data))
+	}
+	if pos < s.size {
+		if last {
+			if err := os.Truncate(q.path(id), pos); err != nil {
+				return nil, err
+			}
+		}
+		s.size = pos
+	}
+	return s, nil
+}
+
+// Append adds a record to the end of the queue
+func (q *Queue) Append(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.w == nil {
+		return errors.New("bridge: queue is closed")
+	}
+
+	last := q.segments[len(q.segments)-1]
+	if last.size > 0 && last.size+headerSize+int64(len(data)) > q.cfg.SegmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+		last = q.segments[len(q.segments)-1]
+	}
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+	if _, err := q.w.Write(record); err != nil {
+		return err
+	}
+	if q.cfg.Sync == SyncAlways {
+		if err := q.w.Sync(); err != nil {
+			return err
+		}
+	}
+	last.size += int64(len(record))
+	last.entries++
+	last.modTime = time.Now()
+
+	err := q.enforceLimits(last.modTime)
+	q.report()
+	return err
+}
+
+// Forward sends the records in order and removes them after send returned nil.
+// It stops at the first error and returns the number of forwarded records. The
+// queue is not locked while send runs, so a slow sink does not block Append;
+// concurrent calls of Forward wait for each other.
+func (q *Queue) Forward(send func(data []byte) error) (int, error) {
+	q.forwarding.Lock()
+	defer q.forwarding.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.report()
+		q.mu.Unlock()
+	}()
+
+	n := 0
+	for {
+		q.mu.Lock()
+		data, id, head, err := q.peek()
+		q.mu.Unlock()
+		if err != nil || data == nil {
+			return n, err
+		}
+		if err := send(data); err != nil {
+			return n, err
+		}
+		n++
+		q.mu.Lock()
+		err = q.ack(id, head, int64(len(data)))
+		q.mu.Unlock()
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// peek returns the first record with its segment and offset or nil if the queue
+// is empty; q.mu must be held
+func (q *Queue) peek() ([]byte, uint64, int64, error) {
+	if q.w == nil {
+		return nil, 0, 0, errors.New("bridge: queue is closed")
+	}
+	for len(q.segments) > 0 {
+		first := q.segments[0]
+		if first.entries == 0 {
+			// skip the rest of a damaged segment
+			if len(q.segments) == 1 {
+				break
+			}
+			q.removeFirst()
+			if err := q.writeCursor(); err != nil {
+				return nil, 0, 0, err
+			}
+			continue
+		}
+		if q.r == nil {
+			f, err := os.Open(q.path(first.id))
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			q.r = f
+		}
+		data, err := readRecord(q.r, q.head, first.size)
+		return data, first.id, q.head, err
+	}
+	return nil, 0, 0, nil
+}
+
+// ack removes the forwarded record at the offset of the segment unless it was
+// dropped by the limits while it was sent; q.mu must be held
+func (q *Queue) ack(id uint64, head, length int64) error {
+	if q.w == nil {
+		return errors.New("bridge: queue is closed")
+	}
+	if len(q.segments) == 0 || q.segments[0].id != id || q.head != head {
+		return nil
+	}
+	q.head += headerSize + length
+	q.segments[0].entries--
+	return q.advance()
+}
+
+// advance removes the first segment if it was forwarded and stores the cursor
+func (q *Queue) advance() error {
+	first := q.segments[0]
+	if first.entries == 0 {
+		if len(q.segments) == 1 {
+			// start a new segment instead of growing the forwarded one
+			if err := q.rotate(); err != nil {
+				return err
+			}
+		}
+		q.removeFirst()
+	}
+	return q.writeCursor()
+}
+
+// Len returns the number of records in the queue
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, s := range q.segments {
+		n += s.entries
+	}
+	return n
+}
+
+// Size returns the bytes of the records in the queue
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size()
+}
+
+// Dropped returns the number of records dropped because of the size and age limits
+func (q *Queue) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Close syncs and closes the segment files
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.close()
+}
+
+func (q *Queue) close() error {
+	var err error
+	if q.w != nil {
+		if q.cfg.Sync != SyncNever {
+			err = q.w.Sync()
+		}
+		if cerr := q.w.Close(); err == nil {
+			err = cerr
+		}
+		q.w = nil
+	}
+	if q.r != nil {
+		q.r.Close()
+		q.r = nil
+	}
+	return err
+}
+
+// size returns the bytes after the read position
+func (q *Queue) size() int64 {
+	var size int64
+	for _, s := range q.segments {
+		size += s.size
+	}
+	if len(q.segments) > 0 {
+		size -= q.head
+	}
+	return size
+}
+
+// rotate closes the last segment and starts a new one
+func (q *Queue) rotate() error {
+	id := q.lastID + 1
+	if q.w != nil {
+		if q.cfg.Sync != SyncNever {
+			if err := q.w.Sync(); err != nil {
+				return err
+			}
+		}
+		q.w.Close()
+	}
+	f, err := os.OpenFile(q.path(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		q.w = nil
+		return err
+	}
+	q.w = f
+	q.lastID = id
+	q.segments = append(q.segments, &segment{id: id, modTime: time.Now()})
+	return nil
+}
+
+// removeFirst deletes the first segment
+func (q *Queue) removeFirst() {
+	if q.r != nil {
+		q.r.Close()
+		q.r = nil
+	}
+	if err := os.Remove(q.path(q.segments[0].id)); err != nil {
+		opc.Logger().Warn("cannot remove queue segment", "queue", q.name, "error", err)
+	}
+	q.segments = q.segments[1:]
+	q.head = 0
+}
+
+// enforceLimits drops the oldest segments while the queue is too large or too old
+func (q *Queue) enforceLimits(now time.Time) error {
+	for {
+		first := q.segments[0]
+		tooLarge := q.cfg.MaxSize > 0 && q.size() > q.cfg.MaxSize
+		tooOld := q.maxAge > 0 && first.entries > 0 && now.Sub(first.modTime) > q.maxAge
+		if !tooLarge && !tooOld {
+			return nil
+		}
+		if len(q.segments) == 1 {
+			if first.entries == 0 {
+				return nil
+			}
+			if err := q.rotate(); err != nil {
+				return err
+			}
+		}
+		q.dropped += first.entries
+		opc.Logger().Warn("dropping queued records", "queue", q.name, "records", first.entries, "too_large", tooLarge, "too_old", tooOld)
+		q.removeFirst()
+		if err := q.writeCursor(); err != nil {
+			return err
+		}
+	}
+}
+
+// report sets the backlog metric
+func (q *Queue) report() {
+	n := 0
+	for _, s := range q.segments {
+		n += s.entries
+	}
+	opc.DefaultMetrics.SetBacklog(q.name, n, q.size())
+}
+
+// path returns the file name of a segment
+func (q *Queue) path(id uint64) string {
+	return filepath.Join(q.cfg.Dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// readCursor returns the segment and offset of the read position
+func (q *Queue) readCursor() (uint64, int64) {
+	b, err := os.ReadFile(filepath.Join(q.cfg.Dir, cursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	var id uint64
+	var offset int64
+	if _, err := fmt.Sscan(string(b), &id, &offset); err != nil {
+		opc.Logger().Warn("invalid queue cursor", "queue", q.name, "error", err)
+		return 0, 0
+	}
+	return id, offset
+}
+
+// writeCursor stores the read position atomically
+func (q *Queue) writeCursor() error {
+	name := filepath.Join(q.cfg.Dir, cursorFile)
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %d\n", q.segments[0].id, q.head)
+	if err == nil && q.cfg.Sync == SyncAlways {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// readRecord reads and checks the record at the offset
+func readRecord(r io.ReaderAt, offset, size int64) ([]byte, error) {
+	var header [headerSize]byte
+	if offset+headerSize > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if offset+headerSize+length > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, offset+headerSize); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("bridge: checksum mismatch")
+	}
+	return data, nil
+}
@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func appendRecords(t *testing.T, q *Queue, from, to int) {
+	for i := from; i < to; i++ {
+		if err := q.Append([]byte(fmt.Sprintf("record %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue("test", QueueConfig{Dir: dir, SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, q, 0, 10)
+	if q.Len() != 10 || q.Size() != 10*(headerSize+8) {
+		t.Errorf("wrong backlog %d records, %d bytes", q.Len(), q.Size())
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) != 3 {
+		t.Errorf("expected 3 segments, got %d", len(segments))
+	}
+
+	// the sink fails after 3 records
+	var sent []string
+	n, err := q.Forward(func(data []byte) error {
+		if len(sent) == 3 {
+			return errors.New("offline")
+		}
+		sent = append(sent, string(data))
+		return nil
+	})
+	if n != 3 || err == nil || q.Len() != 7 {
+		t.Fatalf("forwarded %d records, %d left: %v", n, q.Len(), err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the remaining records survive a restart and keep their order
+	q, err = OpenQueue("test", QueueConfig{Dir: dir, SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 7 {
+		t.Fatalf("expected 7 records after restart, got %d", q.Len())
+	}
+	appendRecords(t, q, 10, 12)
+	n, err = q.Forward(func(data []byte) error {
+		sent = append(sent, string(data))
+		return nil
+	})
+	if n != 9 || err != nil || q.Len() != 0 || q.Size() != 0 {
+		t.Fatalf("forwarded %d records, %d left: %v", n, q.Len(), err)
+	}
+	for i, s := range sent {
+		if s != fmt.Sprintf("record %d", i) {
+			t.Fatalf("wrong order: %v", sent)
+		}
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) != 1 {
+		t.Errorf("forwarded segments not removed: %v", segments)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	q, err := OpenQueue("test", QueueConfig{Dir: t.TempDir(), SegmentSize: 64, MaxSize: 100, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	appendRecords(t, q, 0, 10)
+	if q.Size() > 100 || q.Dropped() == 0 || q.Len()+q.Dropped() != 10 {
+		t.Errorf("limit not enforced: %d records, %d bytes, %d dropped", q.Len(), q.Size(), q.Dropped())
+	}
+	var first string
+	q.Forward(func(data []byte) error {
+		if first == "" {
+			first = string(data)
+		}
+		return nil
+	})
+	if first != fmt.Sprintf("record %d", q.Dropped()) {
+		t.Errorf("oldest records not dropped first: %s", first)
+	}
+
+	for _, cfg := range []QueueConfig{{}, {Dir: t.TempDir(), Sync: "sometimes"}, {Dir: t.TempDir(), MaxAge: "forever"}} {
+		if _, err := OpenQueue("test", cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestQueueDamagedTail(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue("test", QueueConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, q, 0, 3)
+	q.Close()
+
+	// a partial record after a power failure
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	q, err = OpenQueue("test", QueueConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	appendRecords(t, q, 3, 4)
+	var sent []string
+	if _, err := q.Forward(func(data []byte) error { sent = append(sent, string(data)); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 4 || sent[3] != "record 3" {
+		t.Errorf("wrong records after truncation: %v", sent)
+	}
+}
+
+func TestQueueAppendWhileForwarding(t *testing.T) {
+	q, err := OpenQueue("test", QueueConfig{Dir: t.TempDir(), Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	appendRecords(t, q, 0, 1)
+
+	// a slow sink does not block appending new records
+	sending, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := q.Forward(func([]byte) error {
+			close(sending)
+			<-release
+			return errors.New("timeout")
+		})
+		done <- err
+	}()
+	<-sending
+	appendRecords(t, q, 1, 3)
+	if q.Len() != 3 {
+		t.Errorf("expected 3 records, got %d", q.Len())
+	}
+	close(release)
+	if err := <-done; err == nil {
+		t.Error("expected send error")
+	}
+	if q.Len() != 3 {
+		t.Errorf("failed record removed, %d left", q.Len())
+	}
+}
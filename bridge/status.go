@@ -0,0 +1,138 @@
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+// Payloads of the status topic
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// DefaultHealthInterval is the interval of the health messages if not configured
+const DefaultHealthInterval = time.Minute
+
+// StatusConfig configures the status topics of a bridge. The status topic holds
+// the retained "online" message and the "offline" last will; the health topic
+// receives the Health of the bridge periodically and when the OPC connection
+// state changes. The offline message needs the last will of the MQTT client, so
+// it cannot be combined with another last will like the Sparkplug NDEATH.
+type StatusConfig struct {
+	Topic    string `yaml:"topic" toml:"topic" json:"topic"`          // status topic, e.g. "opcmqtt/line1/status"
+	Health   string `yaml:"health" toml:"health" json:"health"`       // health topic, default is the status topic + "/health"
+	Interval string `yaml:"interval" toml:"interval" json:"interval"` // health interval, e.g. "30s", default "1m"
+	QoS      byte   `yaml:"qos" toml:"qos" json:"qos"`
+}
+
+// Health is the payload of the health topic
+type Health struct {
+	Status         string     `json:"status"`
+	OPCConnected   bool       `json:"opc_connected"`
+	TagsConfigured int        `json:"tags_configured"`
+	TagsGood       int        `json:"tags_good"`
+	LastRead       *time.Time `json:"last_read,omitempty"`
+	Timestamp      time.Time  `json:"timestamp"`
+}
+
+// Status tracks the state of a bridge and creates the messages of the status
+// topics. It is safe for concurrent use.
+type Status struct {
+	cfg      StatusConfig
+	interval time.Duration
+	tags     int
+
+	mu        sync.Mutex
+	connected bool
+	good      int
+	lastRead  time.Time
+}
+
+// NewStatus checks the config; tags is the number of configured tags. The OPC
+// connection is assumed to be connected until SetConnected is called.
+func NewStatus(cfg StatusConfig, tags int) (*Status, error) {
+	if cfg.Topic == "" || strings.ContainsAny(cfg.Topic+cfg.Health, "+#") {
+		return nil, errors.New("bridge: invalid status topic " + cfg.Topic)
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("bridge: invalid qos %d", cfg.QoS)
+	}
+	if cfg.Health == "" {
+		cfg.Health = cfg.Topic + "/health"
+	}
+	s := &Status{cfg: cfg, interval: DefaultHealthInterval, tags: tags, connected: true}
+	if cfg.Interval != "" {
+		d, err := time.ParseDuration(cfg.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("bridge: invalid health interval %q", cfg.Interval)
+		}
+		s.interval = d
+	}
+	return s, nil
+}
+
+// Interval returns the interval of the health messages
+func (s *Status) Interval() time.Duration {
+	return s.interval
+}
+
+// Online returns the retained message published after every connect
+func (s *Status) Online() Message {
+	return Message{Topic: s.cfg.Topic, Payload: []byte(StatusOnline), QoS: s.cfg.QoS, Retain: true}
+}
+
+// Offline returns the retained message registered as last will and published
+// before a clean disconnect
+func (s *Status) Offline() Message {
+	return Message{Topic: s.cfg.Topic, Payload: []byte(StatusOffline), QoS: s.cfg.QoS, Retain: true}
+}
+
+// SetConnected sets the state of the OPC connection and reports whether it changed
+func (s *Status) SetConnected(connected bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.connected != connected
+	s.connected = connected
+	return changed
+}
+
+// ObserveRead counts the tags with good quality of a read
+func (s *Status) ObserveRead(items map[string]opc.Item, now time.Time) {
+	good := 0
+	for _, item := range items {
+		if item.Good() {
+			good++
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.good = good
+	s.lastRead = now
+}
+
+// Health returns the health message with the current state
+func (s *Status) Health(now time.Time) Message {
+	s.mu.Lock()
+	h := Health{
+		Status:         StatusOnline,
+		OPCConnected:   s.connected,
+		TagsConfigured: s.tags,
+		TagsGood:       s.good,
+		Timestamp:      now,
+	}
+	if !s.lastRead.IsZero() {
+		lastRead := s.lastRead
+		h.LastRead = &lastRead
+	}
+	s.mu.Unlock()
+
+	b, _ := json.Marshal(h)
+	return Message{Topic: s.cfg.Health, Payload: b, QoS: s.cfg.QoS, Retain: true}
+}
@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+func TestStatus(t *testing.T) {
+	s, err := NewStatus(StatusConfig{Topic: "opcmqtt/line1/status", QoS: 1}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Interval() != DefaultHealthInterval {
+		t.Errorf("wrong interval %v", s.Interval())
+	}
+	if m := s.Online(); m.Topic != "opcmqtt/line1/status" || string(m.Payload) != StatusOnline || !m.Retain || m.QoS != 1 {
+		t.Errorf("wrong online message %+v", m)
+	}
+	if m := s.Offline(); string(m.Payload) != StatusOffline || !m.Retain {
+		t.Errorf("wrong offline message %+v", m)
+	}
+
+	var h Health
+	m := s.Health(time.Now())
+	if err := json.Unmarshal(m.Payload, &h); err != nil {
+		t.Fatal(err)
+	}
+	if m.Topic != "opcmqtt/line1/status/health" || !h.OPCConnected || h.TagsConfigured != 3 || h.LastRead != nil {
+		t.Errorf("wrong health before the first read: %s %+v", m.Topic, h)
+	}
+
+	now := time.Now()
+	s.ObserveRead(map[string]opc.Item{
+		"a": {Quality: opc.OPCQualityGood},
+		"b": {Quality: opc.OPCQualityGoodButForced},
+		"c": {Quality: opc.OPCQualityBad},
+	}, now)
+	if !s.SetConnected(false) || s.SetConnected(false) {
+		t.Error("wrong state change")
+	}
+	if err := json.Unmarshal(s.Health(now).Payload, &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.OPCConnected || h.TagsGood != 2 || h.LastRead == nil || !h.LastRead.Equal(now) {
+		t.Errorf("wrong health %+v", h)
+	}
+
+	for _, cfg := range []StatusConfig{{}, {Topic: "a/+/status"}, {Topic: "a", Interval: "often"}, {Topic: "a", QoS: 3}} {
+		if _, err := NewStatus(cfg, 0); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
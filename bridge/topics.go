@@ -0,0 +1,286 @@
+// Package bridge contains the building blocks of the bridges that forward OPC
+// data to MQTT brokers and databases, like the mapping of tags to MQTT topics
+// and payloads.
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/wire"
+)
+
+// Payload formats of a TopicRule
+const (
+	PayloadSample   = "sample"   // the item as wire.Sample with value, quality and timestamp in the encoding of the bridge
+	PayloadValue    = "value"    // the value as plain text, e.g. "21.5"
+	PayloadSnapshot = "snapshot" // all items with the same topic as wire.Snapshot in the encoding of the bridge
+)
+
+// placeholder matches the placeholders of topic templates, e.g. "{tag}"
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// builtins are the placeholders that are available for every tag
+var builtins = []string{"tag", "name", "path"}
+
+// TopicRule maps the tags matching its patterns to an MQTT topic. The topic is a
+// template with placeholders in braces, e.g. "plant/{line}/{name}":
+//
+//	{tag}   the item ID, e.g. "Line1.Pump3.Temp"
+//	{name}  the last element of the item ID, e.g. "Temp"
+//	{path}  the item ID with '/' as separator, e.g. "Line1/Pump3/Temp"
+//
+// The named groups of 're:' patterns and the Vars are available as well, e.g.
+// "re:^(?P<line>Line[0-9]+)\." for {line}.
+type TopicRule struct {
+	Tags     []string          `yaml:"tags" toml:"tags" json:"tags"`             // glob or 're:' patterns of the item IDs, empty matches all tags
+	Topic    string            `yaml:"topic" toml:"topic" json:"topic"`          // topic template
+	Payload  string            `yaml:"payload" toml:"payload" json:"payload"`    // PayloadSample (default), PayloadValue or PayloadSnapshot
+	Template string            `yaml:"template" toml:"template" json:"template"` // Go template of the payload, executed with the wire.Sample or wire.Snapshot
+	QoS      byte              `yaml:"qos" toml:"qos" json:"qos"`                // MQTT quality of service 0, 1 or 2
+	Retain   bool              `yaml:"retain" toml:"retain" json:"retain"`       // MQTT retain flag
+	Vars     map[string]string `yaml:"vars" toml:"vars" json:"vars"`             // additional placeholders
+}
+
+// Message is an MQTT message created by a Router
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// route is a compiled TopicRule
+type route struct {
+	rule     TopicRule
+	patterns []*regexp.Regexp
+	template *template.Template
+}
+
+// Router creates the MQTT messages of a read with the topic rules. Every rule is
+// applied independently, so a tag is published on the topics of all matching rules.
+type Router struct {
+	codec  wire.Codec
+	routes []route
+}
+
+// NewRouter compiles the topic rules. Samples and snapshots are encoded with codec.
+func NewRouter(rules []TopicRule, codec wire.Codec) (*Router, error) {
+	r := &Router{codec: codec}
+	for i, rule := range rules {
+		rt, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("bridge: topic rule %d: %v", i+1, err)
+		}
+		r.routes = append(r.routes, rt)
+	}
+	return r, nil
+}
+
+// compileRule checks the rule and compiles its patterns and templates
+func compileRule(rule TopicRule) (route, error) {
+	rt := route{rule: rule}
+	if rule.Topic == "" {
+		return rt, errors.New("missing topic")
+	}
+	if rule.QoS > 2 {
+		return rt, fmt.Errorf("invalid qos %d", rule.QoS)
+	}
+	switch rule.Payload {
+	case "":
+		rt.rule.Payload = PayloadSample
+	case PayloadSample, PayloadValue, PayloadSnapshot:
+	default:
+		return rt, errors.New("unknown payload " + rule.Payload)
+	}
+
+	known := make(map[string]bool)
+	for _, name := range builtins {
+		known[name] = true
+	}
+	for name := range rule.Vars {
+		known[name] = true
+	}
+	for _, pattern := range rule.Tags {
+		re, err := opc.CompileTagPattern(pattern)
+		if err != nil {
+			return rt, err
+		}
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				known[name] = true
+			}
+		}
+		rt.patterns = append(rt.patterns, re)
+	}
+	for _, m := range placeholder.FindAllStringSubmatch(rule.Topic, -1) {
+		if !known[m[1]] {
+			return rt, errors.New("unknown placeholder {" + m[1] + "} in topic " + rule.Topic)
+		}
+	}
+
+	if rule.Template != "" {
+		tmpl, err := template.New(rule.Topic).Funcs(template.FuncMap{"json": toJSON}).Parse(rule.Template)
+		if err != nil {
+			return rt, err
+		}
+		rt.template = tmpl
+	}
+	return rt, nil
+}
+
+// match returns the placeholder values of the tag if the rule matches it
+func (rt *route) match(tag string) (map[string]string, bool) {
+	vars := map[string]string{
+		"tag":  tag,
+		"name": tag,
+		"path": tag,
+	}
+	if i := strings.LastIndexAny(tag, opc.DefaultTagSeparators); i >= 0 {
+		vars["name"] = tag[i+1:]
+	}
+	vars["path"] = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(opc.DefaultTagSeparators, r) {
+			return '/'
+		}
+		return r
+	}, tag)
+	for name, value := range rt.rule.Vars {
+		vars[name] = value
+	}
+	if len(rt.patterns) == 0 {
+		return vars, true
+	}
+	for _, re := range rt.patterns {
+		m := re.FindStringSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				vars[name] = m[i]
+			}
+		}
+		return vars, true
+	}
+	return nil, false
+}
+
+// topic replaces the placeholders of the topic template. The MQTT wildcards
+// '+' and '#' are replaced by '_' in the values.
+func (rt *route) topic(vars map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(rt.rule.Topic, func(s string) string {
+		return strings.NewReplacer("+", "_", "#", "_").Replace(vars[s[1:len(s)-1]])
+	})
+}
+
+// Messages returns the messages of all rules for the items of a read, ordered by
+// rule and tag. The messages of items that could not be encoded are skipped and
+// the errors returned together with the other messages.
+func (r *Router) Messages(items map[string]opc.Item) ([]Message, error) {
+	tags := make([]string, 0, len(items))
+	for tag := range items {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var messages []Message
+	var errs []error
+	for i := range r.routes {
+		rt := &r.routes[i]
+		var topics []string
+		groups := make(map[string]map[string]opc.Item)
+		for _, tag := range tags {
+			vars, ok := rt.match(tag)
+			if !ok {
+				continue
+			}
+			topic := rt.topic(vars)
+			if rt.rule.Payload == PayloadSnapshot {
+				if groups[topic] == nil {
+					groups[topic] = make(map[string]opc.Item)
+					topics = append(topics, topic)
+				}
+				groups[topic][tag] = items[tag]
+				continue
+			}
+			payload, err := r.samplePayload(rt, wire.NewSample(tag, items[tag]))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("bridge: %s: %v", tag, err))
+				continue
+			}
+			messages = append(messages, rt.message(topic, payload))
+		}
+		for _, topic := range topics {
+			payload, err := r.snapshotPayload(rt, wire.NewSnapshot(groups[topic]))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("bridge: %s: %v", topic, err))
+				continue
+			}
+			messages = append(messages, rt.message(topic, payload))
+		}
+	}
+	return messages, errors.Join(errs...)
+}
+
+// message creates a message with the QoS and retain flag of the rule
+func (rt *route) message(topic string, payload []byte) Message {
+	return Message{Topic: topic, Payload: payload, QoS: rt.rule.QoS, Retain: rt.rule.Retain}
+}
+
+// samplePayload encodes a single sample with the template or payload format of the rule
+func (r *Router) samplePayload(rt *route, s wire.Sample) ([]byte, error) {
+	if rt.template != nil {
+		return execute(rt.template, s)
+	}
+	if rt.rule.Payload == PayloadValue {
+		return []byte(FormatValue(s.Value)), nil
+	}
+	return r.codec.MarshalSample(s)
+}
+
+// snapshotPayload encodes a snapshot with the template or the codec
+func (r *Router) snapshotPayload(rt *route, s wire.Snapshot) ([]byte, error) {
+	if rt.template != nil {
+		return execute(rt.template, s)
+	}
+	return r.codec.MarshalSnapshot(s)
+}
+
+// execute runs the payload template
+func execute(tmpl *template.Template, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FormatValue returns a value as plain text: numbers in the shortest representation,
+// timestamps in RFC 3339, arrays as JSON and nothing for empty values.
+func FormatValue(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		return toJSON(v)
+	}
+	s, _ := opc.Item{Value: v}.Text()
+	return s
+}
+
+// toJSON is the "json" function of the payload templates
+func toJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
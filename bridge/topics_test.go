@@ -0,0 +1,132 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/wire"
+)
+
+func testItems() map[string]opc.Item {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return map[string]opc.Item{
+		"Line1.Pump3.Temp":  {Value: 21.5, Quality: opc.OPCQualityGood, Timestamp: ts},
+		"Line1.Pump3.Speed": {Value: int32(1200), Quality: opc.OPCQualityGood, Timestamp: ts},
+		"Line2.Pump1.Temp":  {Value: 19.0, Quality: opc.OPCQualityGood, Timestamp: ts},
+		"numeric.sin.float": {Value: float32(0.5), Quality: opc.OPCQualityGood, Timestamp: ts},
+	}
+}
+
+func TestRouterTopics(t *testing.T) {
+	router, err := NewRouter([]TopicRule{
+		{
+			Tags:    []string{`re:^(?P<line>Line[0-9]+)\.`},
+			Topic:   "{site}/{line}/{name}",
+			Payload: PayloadValue,
+			QoS:     1,
+			Retain:  true,
+			Vars:    map[string]string{"site": "plant"},
+		},
+		{Tags: []string{"numeric.*.*"}, Topic: "sim/{path}"},
+	}, wire.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := router.Messages(testItems())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ topic, payload string }{
+		{"plant/Line1/Speed", "1200"},
+		{"plant/Line1/Temp", "21.5"},
+		{"plant/Line2/Temp", "19"},
+	}
+	if len(messages) != len(expected)+1 {
+		t.Fatalf("expected %d messages, got %d", len(expected)+1, len(messages))
+	}
+	for i, e := range expected {
+		m := messages[i]
+		if m.Topic != e.topic || string(m.Payload) != e.payload || m.QoS != 1 || !m.Retain {
+			t.Errorf("expected %s=%s, got %+v", e.topic, e.payload, m)
+		}
+	}
+
+	last := messages[3]
+	var sample wire.Sample
+	if err := wire.JSON.UnmarshalSample(last.Payload, &sample); err != nil {
+		t.Fatal(err)
+	}
+	if last.Topic != "sim/numeric/sin/float" || sample.Tag != "numeric.sin.float" || sample.QualityString != "good" || last.Retain {
+		t.Errorf("wrong sample message: %s %+v", last.Topic, sample)
+	}
+}
+
+func TestRouterSnapshotAndTemplate(t *testing.T) {
+	router, err := NewRouter([]TopicRule{
+		{Tags: []string{`re:^(?P<line>Line[0-9]+)\.`}, Topic: "plant/{line}", Payload: PayloadSnapshot},
+		{Tags: []string{"*.*.Temp"}, Topic: "temp/{tag}", Template: `{"v":{{json .Value}},"good":{{.Quality.Good}}}`},
+	}, wire.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := router.Messages(testItems())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(messages))
+	}
+
+	var snapshot wire.Snapshot
+	if err := wire.JSON.UnmarshalSnapshot(messages[0].Payload, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if messages[0].Topic != "plant/Line1" || len(snapshot.Samples) != 2 || messages[1].Topic != "plant/Line2" {
+		t.Errorf("wrong snapshot message: %s %+v", messages[0].Topic, snapshot)
+	}
+
+	if messages[2].Topic != "temp/Line1.Pump3.Temp" || string(messages[2].Payload) != `{"v":21.5,"good":true}` {
+		t.Errorf("wrong template message: %s %s", messages[2].Topic, messages[2].Payload)
+	}
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	for _, rule := range []TopicRule{
+		{},
+		{Topic: "plant/{line}/{tag}"},
+		{Topic: "plant/{tag}", QoS: 3},
+		{Topic: "plant/{tag}", Payload: "xml"},
+		{Topic: "plant/{tag}", Tags: []string{"re:("}},
+		{Topic: "plant/{tag}", Template: "{{.Value"},
+	} {
+		if _, err := NewRouter([]TopicRule{rule}, wire.JSON); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, ""},
+		{true, "true"},
+		{float32(0.1), "0.1"},
+		{"a+b", "a+b"},
+		{ts, "2024-05-01T12:00:00Z"},
+		{[]interface{}{int32(1), int32(2)}, "[1,2]"},
+	} {
+		if s := FormatValue(c.value); s != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, s)
+		}
+	}
+	if !strings.Contains(FormatValue(int64(-3)), "-3") {
+		t.Error("wrong integer")
+	}
+}
@@ -0,0 +1,139 @@
+package opc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//ErrBrowseLimit is returned with the partial tree if browsing stopped at BrowseOptions.MaxNodes.
+var ErrBrowseLimit = errors.New("opc: browse limit reached")
+
+//ErrBranchNotFound is returned by a Browser for an unknown path.
+var ErrBranchNotFound = errors.New("opc: branch not found")
+
+//Browser navigates the address space of an OPC server on demand.
+type Browser interface {
+	//Children returns the names of the sub-branches and the leaves of the branch
+	//at path, e.g. "Line1/Pump3". The root is at the empty path.
+	Children(ctx context.Context, path string) ([]string, []Leaf, error)
+}
+
+//BrowseOptions limit the part of the address space that is loaded.
+type BrowseOptions struct {
+	MaxDepth int                  // levels to load below the branch; 0 loads all levels
+	MaxNodes int                  // maximum number of branches and leaves; 0 means no limit
+	Progress func(BrowseProgress) // called after each branch that was loaded
+}
+
+//BrowseProgress reports the branch that was loaded and the number of
+//branches and leaves found so far.
+type BrowseProgress struct {
+	Path     string
+	Branches int
+	Leaves   int
+}
+
+//Browse loads the tree from the root. Branches at MaxDepth are returned without
+//their content and can be loaded later with ExpandBranch. If the context is
+//cancelled or MaxNodes is reached, the partial tree is returned with the error.
+func Browse(ctx context.Context, b Browser, opts BrowseOptions) (*Tree, error) {
+	t := time.Now()
+	root := &Tree{Name: "root", Branches: []*Tree{}, Leaves: []Leaf{}}
+	w := &browseWalker{browser: b, opts: opts}
+	err := w.expand(ctx, root, "", 0)
+	DefaultMetrics.ObserveBrowse(time.Since(t), err)
+	return root, err
+}
+
+//ExpandBranch replaces the content of a branch of a tree returned by Browse
+//with the branches and leaves loaded from the browser.
+func ExpandBranch(ctx context.Context, b Browser, branch *Tree, opts BrowseOptions) error {
+	branch.Branches = []*Tree{}
+	branch.Leaves = []Leaf{}
+	w := &browseWalker{browser: b, opts: opts}
+	return w.expand(ctx, branch, branch.Path(), 0)
+}
+
+//browseWalker loads the branches with the browser and counts the nodes
+type browseWalker struct {
+	browser  Browser
+	opts     BrowseOptions
+	branches int
+	leaves   int
+}
+
+//full checks if MaxNodes is reached
+func (w *browseWalker) full() bool {
+	return w.opts.MaxNodes > 0 && w.branches+w.leaves >= w.opts.MaxNodes
+}
+
+//expand is the recursive helper function for Browse and ExpandBranch
+func (w *browseWalker) expand(ctx context.Context, branch *Tree, path string, depth int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	names, leaves, err := w.browser.Children(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("entering branch", "path", path)
+
+	for _, l := range leaves {
+		if w.full() {
+			return ErrBrowseLimit
+		}
+		branch.Leaves = append(branch.Leaves, l)
+		w.leaves++
+	}
+	for _, name := range names {
+		if w.full() {
+			return ErrBrowseLimit
+		}
+		branch.Branches = append(branch.Branches, &Tree{Name: name, Parent: branch, Branches: []*Tree{}, Leaves: []Leaf{}})
+		w.branches++
+	}
+	if w.opts.Progress != nil {
+		w.opts.Progress(BrowseProgress{Path: path, Branches: w.branches, Leaves: w.leaves})
+	}
+
+	if w.opts.MaxDepth > 0 && depth+1 >= w.opts.MaxDepth {
+		return nil
+	}
+	for _, b := range branch.Branches {
+		if err := w.expand(ctx, b, joinPath(path, b.Name), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//TreeBrowser serves the branches of a tree, e.g. a snapshot loaded with LoadTree.
+//It simulates an OPC server for tests and offline work.
+type TreeBrowser struct {
+	Tree *Tree
+}
+
+//NewTreeBrowser returns a browser for the tree.
+func NewTreeBrowser(tree *Tree) *TreeBrowser {
+	return &TreeBrowser{Tree: tree}
+}
+
+//Children returns the sub-branches and leaves of the branch at path.
+func (b *TreeBrowser) Children(ctx context.Context, path string) ([]string, []Leaf, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	branch := b.Tree.Find(path)
+	if branch == nil {
+		return nil, nil, ErrBranchNotFound
+	}
+	names := make([]string, len(branch.Branches))
+	for i, sub := range branch.Branches {
+		names[i] = sub.Name
+	}
+	leaves := make([]Leaf, len(branch.Leaves))
+	copy(leaves, branch.Leaves)
+	return names, leaves, nil
+}
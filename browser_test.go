@@ -0,0 +1,85 @@
+package opc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBrowseComplete(t *testing.T) {
+	source := testingCreateNestedTree()
+
+	var progress []BrowseProgress
+	tree, err := Browse(context.Background(), NewTreeBrowser(source), BrowseOptions{
+		Progress: func(p BrowseProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(CollectTags(tree), CollectTags(source)) {
+		t.Errorf("tags do not match: %v", CollectTags(tree))
+	}
+	if branch := tree.Find("Line1/Pump3/Setpoints"); branch == nil || branch.Path() != "Line1/Pump3/Setpoints" {
+		t.Error("branch or parent links missing")
+	}
+	last := progress[len(progress)-1]
+	if last.Leaves != len(CollectTags(source)) || progress[0].Path != "" {
+		t.Errorf("wrong progress: %v", progress)
+	}
+}
+
+func TestBrowseMaxDepthAndExpand(t *testing.T) {
+	source := testingCreateNestedTree()
+	browser := NewTreeBrowser(source)
+
+	tree, err := Browse(context.Background(), browser, BrowseOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Branches) != len(source.Branches) || len(CollectTags(tree)) != len(source.Leaves) {
+		t.Fatal("only the first level should be loaded")
+	}
+
+	line1 := tree.Find("Line1")
+	if err := ExpandBranch(context.Background(), browser, line1, BrowseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(CollectTags(line1), CollectTags(source.Find("Line1"))) {
+		t.Errorf("branch not expanded: %v", CollectTags(line1))
+	}
+}
+
+func TestBrowseMaxNodes(t *testing.T) {
+	tree, err := Browse(context.Background(), NewTreeBrowser(testingCreateNestedTree()), BrowseOptions{MaxNodes: 3})
+	if !errors.Is(err, ErrBrowseLimit) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+	nodes := 0
+	tree.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		if path != "" {
+			nodes++
+		}
+		return nil
+	})
+	if nodes != 3 {
+		t.Errorf("expected 3 nodes, got %d", nodes)
+	}
+}
+
+func TestBrowseCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := Browse(ctx, NewTreeBrowser(testingCreateNestedTree()), BrowseOptions{
+		Progress: func(BrowseProgress) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
+
+func TestTreeBrowserUnknownBranch(t *testing.T) {
+	_, _, err := NewTreeBrowser(testingCreateNestedTree()).Children(context.Background(), "Line9")
+	if err != ErrBranchNotFound {
+		t.Errorf("expected ErrBranchNotFound, got %v", err)
+	}
+}
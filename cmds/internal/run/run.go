@@ -0,0 +1,198 @@
+// Package run contains the run loop shared by the commands: signal handling,
+// periodic ticks, graceful shutdown and exit codes.
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the commands
+const (
+	ExitOK          = 0
+	ExitError       = 1 // runtime failure or shutdown timeout
+	ExitUsage       = 2 // invalid flags or config
+	ExitUnavailable = 3 // OPC server, broker or database not reachable at startup
+	ExitBreaking    = 4 // opc-cli diff found breaking changes
+)
+
+// DefaultTimeout limits the shutdown if not configured
+const DefaultTimeout = 10 * time.Second
+
+// Error is an error with the exit code of the command
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Errorf returns an error with an exit code
+func Errorf(code int, format string, args ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode returns the exit code of an error: ExitOK for nil, the code of an
+// Error or ExitError otherwise
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ExitError
+}
+
+// Context returns a context that is cancelled by SIGINT or SIGTERM. A second
+// signal after stop terminates the process immediately.
+func Context() (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Shutdown runs cleanup functions in the reverse order of their registration,
+// like deferred calls
+type Shutdown struct {
+	mu    sync.Mutex
+	funcs []cleanup
+}
+
+type cleanup struct {
+	name     string
+	fn       func(ctx context.Context) error
+	detached bool // safe while the command is running
+}
+
+// Add registers a cleanup function of a resource the command uses, e.g. to flush
+// a buffer or close a connection. It is skipped if the command does not stop in
+// time, because closing the resource would race with the command. fn should
+// return when ctx is done.
+func (s *Shutdown) Add(name string, fn func(ctx context.Context) error) {
+	s.add(cleanup{name: name, fn: fn})
+}
+
+// AddDetached registers a cleanup function that is safe to call while the command
+// is still running, e.g. to flush the telemetry
+func (s *Shutdown) AddDetached(name string, fn func(ctx context.Context) error) {
+	s.add(cleanup{name: name, fn: fn, detached: true})
+}
+
+func (s *Shutdown) add(c cleanup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.funcs = append(s.funcs, c)
+}
+
+// Run calls the cleanup functions in reverse order, each with its own timeout,
+// and returns their errors. A function that does not return in time is abandoned
+// and the next one is called. If the command is still running, only the detached
+// functions are called.
+func (s *Shutdown) Run(timeout time.Duration, running bool) error {
+	s.mu.Lock()
+	funcs := s.funcs
+	s.funcs = nil
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(funcs) - 1; i >= 0; i-- {
+		c := funcs[i]
+		if running && !c.detached {
+			slog.Warn("skipping cleanup of a resource in use", "name", c.name)
+			continue
+		}
+		if err := c.run(timeout); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run calls the function and waits until it returns or the timeout expired
+func (c cleanup) run(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- c.fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Main runs the command until it returns or a signal arrives, then runs the
+// cleanup functions and exits with the exit code. The command has the timeout
+// to return after the signal, e.g. to finish an in-flight publish, and every
+// cleanup function has the timeout to flush buffers or close a connection.
+func Main(timeout time.Duration, command func(ctx context.Context, shutdown *Shutdown) error) {
+	os.Exit(run(timeout, command))
+}
+
+func run(timeout time.Duration, command func(ctx context.Context, shutdown *Shutdown) error) int {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, stop := Context()
+	defer stop()
+
+	shutdown := &Shutdown{}
+	done := make(chan error, 1)
+	go func() { done <- command(ctx, shutdown) }()
+
+	var err error
+	running := false
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		stop()
+		slog.Info("shutting down", "timeout", timeout)
+		select {
+		case err = <-done:
+		case <-time.After(timeout):
+			err = errors.New("command did not stop in time")
+			running = true
+		}
+	}
+	if err != nil {
+		slog.Error("stopped with error", "error", err)
+	}
+
+	if serr := shutdown.Run(timeout, running); serr != nil {
+		slog.Error("shutdown failed", "error", serr)
+		if err == nil {
+			err = serr
+		}
+	}
+	return ExitCode(err)
+}
+
+// Tick calls tick every interval until ctx is done or tick returns an error.
+// A tick that takes longer than the interval delays the next one instead of
+// queueing ticks. It returns nil after ctx is done.
+func Tick(ctx context.Context, interval time.Duration, tick func(ctx context.Context, t time.Time) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t := <-ticker.C:
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := tick(ctx, t); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{errors.New("failed"), ExitError},
+		{Errorf(ExitUsage, "invalid config"), ExitUsage},
+		{fmt.Errorf("wrapped: %w", Errorf(ExitUnavailable, "offline")), ExitUnavailable},
+	}
+	for _, tc := range cases {
+		if code := ExitCode(tc.err); code != tc.code {
+			t.Errorf("%v: expected %d, got %d", tc.err, tc.code, code)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	var order []string
+	s := &Shutdown{}
+	s.Add("connection", func(context.Context) error { order = append(order, "connection"); return nil })
+	s.Add("queue", func(context.Context) error { order = append(order, "queue"); return errors.New("sync failed") })
+	s.Add("client", func(context.Context) error { order = append(order, "client"); return nil })
+	err := s.Run(time.Second, false)
+	if fmt.Sprint(order) != "[client queue connection]" {
+		t.Errorf("wrong order %v", order)
+	}
+	if err == nil || err.Error() != "queue: sync failed" {
+		t.Errorf("wrong error %v", err)
+	}
+
+	// blocked cleanup functions are abandoned after the timeout, the others still run
+	order = nil
+	s.Add("connection", func(context.Context) error { order = append(order, "connection"); return nil })
+	s.Add("blocked", func(context.Context) error { select {} })
+	if err := s.Run(10*time.Millisecond, false); !errors.Is(err, context.DeadlineExceeded) || fmt.Sprint(order) != "[connection]" {
+		t.Errorf("expected deadline and closed connection, got %v %v", err, order)
+	}
+
+	// resources of a running command are not closed
+	order = nil
+	s.Add("connection", func(context.Context) error { order = append(order, "connection"); return nil })
+	s.AddDetached("telemetry", func(context.Context) error { order = append(order, "telemetry"); return nil })
+	if err := s.Run(time.Second, true); err != nil || fmt.Sprint(order) != "[telemetry]" {
+		t.Errorf("wrong cleanups of a running command: %v %v", order, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	closed := false
+	code := run(time.Second, func(ctx context.Context, s *Shutdown) error {
+		s.Add("connection", func(context.Context) error { closed = true; return nil })
+		return Errorf(ExitUnavailable, "opc server not reachable")
+	})
+	if code != ExitUnavailable || !closed {
+		t.Errorf("wrong exit code %d or connection not closed", code)
+	}
+}
+
+func TestTick(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := 0
+	err := Tick(ctx, time.Millisecond, func(ctx context.Context, _ time.Time) error {
+		ticks++
+		if ticks == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil || ticks != 3 {
+		t.Errorf("expected 3 ticks, got %d: %v", ticks, err)
+	}
+
+	failed := errors.New("failed")
+	if err := Tick(context.Background(), time.Millisecond, func(context.Context, time.Time) error { return failed }); err != failed {
+		t.Errorf("expected error, got %v", err)
+	}
+}
@@ -1,136 +1,224 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
 	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/cmds/internal/run"
 	"github.com/spf13/cobra"
 )
 
 var Debug bool
 
+var LogLevel, LogFormat string
+
+var Include, Exclude []string
+
+var Save string
+
+var Render opc.RenderOptions
+
+var Format string
+
+var Names bool
+
+var Limits opc.BrowseOptions
+
+var Progress bool
+
+// render prints the tree with the render flags
+func render(tree *opc.Tree) {
+	opts := Render
+	opts.Format = opc.RenderFormat(Format)
+	opts.ItemIDs = !Names
+	if err := tree.Render(os.Stdout, opts); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// addRenderFlags adds the flags for the output of trees
+func addRenderFlags(cmd *cobra.Command) {
+	formats := make([]string, len(opc.RenderFormats))
+	for i, f := range opc.RenderFormats {
+		formats[i] = string(f)
+	}
+	cmd.Flags().StringVar(&Format, "format", string(opc.RenderText), "output format: "+strings.Join(formats, ", "))
+	cmd.Flags().IntVar(&Render.MaxDepth, "depth", 0, "maximum depth to show (0 shows all levels)")
+	cmd.Flags().BoolVar(&Names, "names", false, "show the names of the tags instead of their item IDs")
+	cmd.Flags().BoolVar(&Render.LeafCounts, "counts", false, "show the number of tags per branch")
+	cmd.Flags().BoolVar(&Render.Unicode, "unicode", false, "draw the tree with box-drawing characters")
+}
+
+// selectBranch returns the optional branch given by name or path
+// and applies the filter flags
+func selectBranch(tree *opc.Tree, args []string) *opc.Tree {
+	if len(args) > 0 {
+		branch := args[0]
+		if strings.Contains(branch, opc.PathSeparator) {
+			tree = tree.Find(branch)
+		} else {
+			tree = opc.ExtractBranchByName(tree, branch)
+		}
+		if tree == nil {
+			fmt.Printf("Branch '%s' not found.\n", branch)
+			os.Exit(run.ExitError)
+		}
+	}
+	if len(Include) > 0 || len(Exclude) > 0 {
+		filter, err := opc.NewFilter(opc.FilterRules{Include: Include, Exclude: Exclude})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(run.ExitUsage)
+		}
+		tree = filter.Apply(tree)
+	}
+	return tree
+}
+
+// CheckDebug sets up the OPC logging with --debug or --log-level
 func CheckDebug() {
+	level := LogLevel
 	if Debug {
-		opc.Debug()
+		level = "debug"
+	}
+	if level == "" {
+		return
+	}
+	if err := opc.ConfigureLogging(level, LogFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
 
 func main() {
 
-	var cmdList = &cobra.Command{
-		Use:   "list [node]",
-		Short: "Lists the OPC servers available on a specific node.",
+	var cmdView = &cobra.Command{
+		Use:   "view [file] [branch]",
+		Short: "Show OPC tags of a tree saved with 'browse --save'. Works offline.",
 		Long:  ``,
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			node := args[0]
-			CheckDebug()
-			servers_found := opc.NewAutomationObject().GetOPCServers(node)
-			fmt.Printf("Found %d server(s) on '%s':\n", len(servers_found), node)
-			for _, server := range servers_found {
-				fmt.Println(server)
-			}
-		},
-	}
-
-	var cmdInfo = &cobra.Command{
-		Use:   "info [node] [server]",
-		Short: "Try connect the OPC server on a specific node and check if it is running.",
-		Long:  ``,
-		Args:  cobra.MinimumNArgs(2),
-		Run: func(cmd *cobra.Command, args []string) {
-			nodes := []string{args[0]}
-			server := args[1]
-			CheckDebug()
-			obj := opc.NewAutomationObject()
-			_, err := obj.TryConnect(server, nodes)
+			tree, err := opc.LoadTree(args[0])
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
-			if obj.IsConnected() {
-				fmt.Printf("%s on '%v' is up and running.\n", server, nodes[0])
-			} else {
-				fmt.Printf("%s on '%v' is not running.\n", server, nodes[0])
-			}
-
+			render(selectBranch(tree, args[1:]))
 		},
 	}
+	cmdView.Flags().StringSliceVarP(&Include, "filter", "f", nil, "only show branches and tags matching glob or 're:' regex patterns")
+	cmdView.Flags().StringSliceVarP(&Exclude, "exclude", "x", nil, "hide branches and tags matching glob or 're:' regex patterns")
+	addRenderFlags(cmdView)
 
-	var cmdBrowse = &cobra.Command{
-		Use:   "browse [node] [server] [branch_name]",
-		Short: "Browse OPC tags. If only sub-branch is requested, use optional branch_name.",
+	var overlayFile string
+	var cmdMerge = &cobra.Command{
+		Use:   "merge [prefix=snapshot...]",
+		Short: "Combine snapshots saved by 'browse --save' into one tree with each snapshot under its prefix (e.g. Plant1/ServerA=a.json). Works offline.",
 		Long:  ``,
-		Args:  cobra.MinimumNArgs(2),
+		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			nodes := []string{args[0]}
-			server := args[1]
-			name := "root"
-			CheckDebug()
-			if len(args) > 2 {
-				name = args[2]
+			trees := make(map[string]*opc.Tree)
+			for _, arg := range args {
+				parts := strings.SplitN(arg, "=", 2)
+				if len(parts) != 2 {
+					fmt.Printf("Invalid argument '%s', expected prefix=snapshot.\n", arg)
+					os.Exit(1)
+				}
+				tree, err := opc.LoadTree(parts[1])
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				trees[parts[0]] = tree
 			}
-			tree, err := opc.CreateBrowser(server, nodes)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			tree := opc.MergeTrees(trees)
+			if overlayFile != "" {
+				virtual, err := opc.LoadTree(overlayFile)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				tree = opc.Overlay(tree, virtual)
+			}
+			if Save != "" {
+				if err := opc.SaveTree(Save, tree); err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
 			}
-			opc.PrettyPrint(opc.ExtractBranchByName(tree, name))
+			render(tree)
 		},
 	}
+	cmdMerge.Flags().StringVar(&overlayFile, "overlay", "", "add the virtual folders and tags of this tree file")
+	cmdMerge.Flags().StringVarP(&Save, "save", "s", "", "save the merged tree to a .json, .yml or .csv file")
+	addRenderFlags(cmdMerge)
 
-	var cmdRead = &cobra.Command{
-		Use:   "read [node] [server] [tags...]",
-		Short: "Read OPC tags.",
+	var naming, reportFormat string
+	var withProperties bool
+	var cmdReport = &cobra.Command{
+		Use:   "report [node server | snapshot]",
+		Short: "Summarize the OPC tags on the server or in a snapshot saved by 'browse --save': counts, depths, duplicate item IDs, naming violations and data types.",
 		Long:  ``,
-		Args:  cobra.MinimumNArgs(3),
+		Args:  cobra.RangeArgs(1, 2),
 		Run: func(cmd *cobra.Command, args []string) {
-			nodes := []string{args[0]}
-			server := args[1]
-			tags := args[2:]
-			CheckDebug()
-			conn, err := opc.NewConnection(
-				server,
-				nodes,
-				tags,
-			)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			var re *regexp.Regexp
+			if naming != "" {
+				var err error
+				if re, err = regexp.Compile(naming); err != nil {
+					fmt.Println(err)
+					os.Exit(run.ExitUsage)
+				}
+			}
+			if reportFormat != "text" && reportFormat != "json" {
+				fmt.Println("unknown report format:", reportFormat)
+				os.Exit(run.ExitUsage)
 			}
-			fmt.Println(conn.Read())
-		},
-	}
 
-	var cmdWrite = &cobra.Command{
-		Use:   "write [node] [server] [tag] [value]",
-		Short: "Write value to OPC tag.",
-		Long:  ``,
-		Args:  cobra.MinimumNArgs(4),
-		Run: func(cmd *cobra.Command, args []string) {
-			nodes := []string{args[0]}
-			server := args[1]
-			tag := args[2]
-			value := args[3]
-			CheckDebug()
-			conn, err := opc.NewConnection(
-				server,
-				nodes,
-				[]string{tag},
-			)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			var tree *opc.Tree
+			if len(args) == 1 {
+				var err error
+				if tree, err = opc.LoadTree(args[0]); err != nil {
+					fmt.Println(err)
+					os.Exit(run.ExitError)
+				}
+			} else {
+				CheckDebug()
+				var err error
+				if tree, err = browseTree(args[0], args[1], withProperties); err != nil {
+					fmt.Println(err)
+					os.Exit(run.ExitUnavailable)
+				}
 			}
-			conn.Write(tag, value)
+
+			stats := tree.Stats(re)
+			if reportFormat == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				encoder.Encode(stats)
+				return
+			}
+			stats.WriteTo(os.Stdout)
 		},
 	}
+	cmdReport.Flags().StringVar(&naming, "naming", "", "regex the names of all branches and tags must match, e.g. '^[A-Z][A-Za-z0-9_]*$'")
+	cmdReport.Flags().StringVar(&reportFormat, "format", "text", "output format: text, json")
+	cmdReport.Flags().BoolVar(&withProperties, "properties", false, "read the item properties for the data type distribution")
 
 	var rootCmd = &cobra.Command{Use: "opc-cli"}
 
 	rootCmd.PersistentFlags().BoolVarP(&Debug, "debug", "d", false, "set OPC logging")
+	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "", "set OPC logging with level debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", opc.LogText, "log format: text, json")
 
-	rootCmd.AddCommand(cmdList, cmdInfo, cmdBrowse, cmdRead, cmdWrite)
-	rootCmd.Execute()
+	// the commands that need a server are only available on Windows
+	rootCmd.AddCommand(serverCommands()...)
+	rootCmd.AddCommand(cmdView, cmdReport, cmdMerge)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(run.ExitUsage)
+	}
 }
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/konimarti/opc"
+	"github.com/spf13/cobra"
+)
+
+// browseTree is not available without the OPC Automation interface of Windows
+func browseTree(node, server string, properties bool) (*opc.Tree, error) {
+	return nil, errors.New("opc-cli: connecting to an OPC server requires Windows")
+}
+
+// serverCommands returns no commands, because connecting to an OPC server
+// requires Windows. The snapshot commands work on all platforms.
+func serverCommands() []*cobra.Command {
+	return nil
+}
@@ -0,0 +1,224 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/cmds/internal/run"
+	"github.com/spf13/cobra"
+)
+
+// browseTree browses the server and optionally loads the item properties of
+// all tags. The connection is closed before it returns.
+func browseTree(node, server string, properties bool) (*opc.Tree, error) {
+	object := opc.NewAutomationObject()
+	defer object.Close()
+	if _, err := object.TryConnect(server, []string{node}); err != nil {
+		return nil, err
+	}
+	tree, err := object.CreateBrowser()
+	if err == nil && properties {
+		// leaves without properties are reported as unknown
+		opc.LoadProperties(tree, object)
+	}
+	return tree, err
+}
+
+// serverCommands returns the commands that connect to an OPC server
+func serverCommands() []*cobra.Command {
+	var cmdList = &cobra.Command{
+		Use:   "list [node]",
+		Short: "Lists the OPC servers available on a specific node.",
+		Long:  ``,
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			node := args[0]
+			CheckDebug()
+			servers_found := opc.NewAutomationObject().GetOPCServers(node)
+			fmt.Printf("Found %d server(s) on '%s':\n", len(servers_found), node)
+			for _, server := range servers_found {
+				fmt.Println(server)
+			}
+		},
+	}
+
+	var cmdInfo = &cobra.Command{
+		Use:   "info [node] [server] [tags...]",
+		Short: "Try connect the OPC server on a specific node and check if it is running. Shows the properties of the optional tags.",
+		Long:  ``,
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			nodes := []string{args[0]}
+			server := args[1]
+			tags := args[2:]
+			CheckDebug()
+			obj := opc.NewAutomationObject()
+			_, err := obj.TryConnect(server, nodes)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if obj.IsConnected() {
+				fmt.Printf("%s on '%v' is up and running.\n", server, nodes[0])
+			} else {
+				fmt.Printf("%s on '%v' is not running.\n", server, nodes[0])
+			}
+			for _, tag := range tags {
+				info, err := obj.Properties(tag)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				fmt.Print(info)
+			}
+		},
+	}
+
+	var cmdBrowse = &cobra.Command{
+		Use:   "browse [node] [server] [branch]",
+		Short: "Browse OPC tags. If only sub-branch is requested, use optional branch name or path (e.g. Line1/Pump3/Setpoints).",
+		Long:  ``,
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			nodes := []string{args[0]}
+			server := args[1]
+			CheckDebug()
+			if Progress {
+				Limits.Progress = func(p opc.BrowseProgress) {
+					fmt.Fprintf(os.Stderr, "\r%d branches, %d tags", p.Branches, p.Leaves)
+				}
+			}
+			// Ctrl-C stops browsing and shows the partial tree
+			ctx, stop := run.Context()
+			defer stop()
+			tree, err := opc.BrowseServer(ctx, server, nodes, Limits)
+			stop()
+			if Progress {
+				fmt.Fprintln(os.Stderr)
+			}
+			interrupted := errors.Is(err, context.Canceled)
+			if errors.Is(err, opc.ErrBrowseLimit) {
+				fmt.Fprintf(os.Stderr, "Stopped after %d branches and tags.\n", Limits.MaxNodes)
+			} else if interrupted && tree != nil {
+				fmt.Fprintln(os.Stderr, "Interrupted, the tree is incomplete.")
+			} else if err != nil {
+				fmt.Println(err)
+				os.Exit(run.ExitUnavailable)
+			}
+			tree = selectBranch(tree, args[2:])
+			if Save != "" {
+				if err := opc.SaveTree(Save, tree); err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+			render(tree)
+			if interrupted {
+				os.Exit(run.ExitError)
+			}
+		},
+	}
+	cmdBrowse.Flags().StringSliceVarP(&Include, "filter", "f", nil, "only show branches and tags matching glob or 're:' regex patterns")
+	cmdBrowse.Flags().StringSliceVarP(&Exclude, "exclude", "x", nil, "hide branches and tags matching glob or 're:' regex patterns")
+	cmdBrowse.Flags().StringVarP(&Save, "save", "s", "", "save the browsed tree to a .json, .yml or .csv file")
+	cmdBrowse.Flags().IntVar(&Limits.MaxDepth, "max-depth", 0, "maximum depth to browse on the server (0 browses all levels)")
+	cmdBrowse.Flags().IntVar(&Limits.MaxNodes, "max-nodes", 0, "stop browsing after this number of branches and tags (0 means no limit)")
+	cmdBrowse.Flags().BoolVar(&Progress, "progress", false, "show the browse progress on stderr")
+	addRenderFlags(cmdBrowse)
+
+	var cmdDiff = &cobra.Command{
+		Use:   "diff [node] [server] [snapshot] [branch]",
+		Short: "Compare the OPC tags on the server with a snapshot saved by 'browse --save'. Use the branch and filter flags of the snapshot. Exits with status 4 on breaking changes.",
+		Long:  ``,
+		Args:  cobra.MinimumNArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			nodes := []string{args[0]}
+			server := args[1]
+			CheckDebug()
+			snapshot, err := opc.LoadTree(args[2])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(run.ExitError)
+			}
+			tree, err := opc.CreateBrowser(server, nodes)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(run.ExitUnavailable)
+			}
+			// compare the same part of the address space as saved
+			snapshot = selectBranch(snapshot, nil)
+			tree = selectBranch(tree, args[3:])
+			diff := opc.DiffTrees(snapshot, tree)
+			diff.WriteTo(os.Stdout)
+			if diff.Breaking() {
+				fmt.Println("Breaking changes found.")
+				os.Exit(run.ExitBreaking)
+			}
+			if diff.Empty() {
+				fmt.Println("No changes.")
+			}
+		},
+	}
+	cmdDiff.Flags().StringSliceVarP(&Include, "filter", "f", nil, "only compare branches and tags matching glob or 're:' regex patterns")
+	cmdDiff.Flags().StringSliceVarP(&Exclude, "exclude", "x", nil, "ignore branches and tags matching glob or 're:' regex patterns")
+
+	var cmdRead = &cobra.Command{
+		Use:   "read [node] [server] [tags...]",
+		Short: "Read OPC tags.",
+		Long:  ``,
+		Args:  cobra.MinimumNArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			nodes := []string{args[0]}
+			server := args[1]
+			tags := args[2:]
+			CheckDebug()
+			conn, err := opc.NewConnection(
+				server,
+				nodes,
+				tags,
+			)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(run.ExitUnavailable)
+			}
+			defer conn.Close()
+			fmt.Println(conn.Read())
+		},
+	}
+
+	var cmdWrite = &cobra.Command{
+		Use:   "write [node] [server] [tag] [value]",
+		Short: "Write value to OPC tag.",
+		Long:  ``,
+		Args:  cobra.MinimumNArgs(4),
+		Run: func(cmd *cobra.Command, args []string) {
+			nodes := []string{args[0]}
+			server := args[1]
+			tag := args[2]
+			value := args[3]
+			CheckDebug()
+			conn, err := opc.NewConnection(
+				server,
+				nodes,
+				[]string{tag},
+			)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(run.ExitUnavailable)
+			}
+			err = conn.Write(tag, value)
+			conn.Close()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(run.ExitError)
+			}
+		},
+	}
+
+	return []*cobra.Command{cmdList, cmdInfo, cmdBrowse, cmdDiff, cmdRead, cmdWrite}
+}
@@ -4,69 +4,152 @@ package main
 // & {$ENV:OPC_SERVER="Graybox.Simulator"; $ENV:OPC_NODES="localhost";  go run main.go -addr ":8765"}
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
 	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/konimarti/opc"
 	"github.com/konimarti/opc/api"
+	"github.com/konimarti/opc/cmds/internal/run"
+	"github.com/konimarti/opc/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	addr    = flag.String("addr", ":8765", "enter address to start api")
-	cfgFile = flag.String("conf", "opcapi.conf", "config file name")
+	addr      = flag.String("addr", ":8765", "enter address to start api")
+	cfgFile   = flag.String("conf", "opcapi.conf", "config file name")
+	logLevel  = flag.String("log-level", "info", "log level: debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "log format: text, json")
+	timeout   = flag.Duration("shutdown-timeout", run.DefaultTimeout, "time to finish the requests and close the connection after SIGINT or SIGTERM")
 )
 
 type tmlConfig struct {
-	Config api.Config `toml:"config"`
-	Opc    opcConfig  `toml:"opc"`
+	Config    api.Config       `toml:"config"`
+	Opc       opcConfig        `toml:"opc"`
+	Metrics   metricsConfig    `toml:"metrics"`
+	Telemetry telemetry.Config `toml:"telemetry"`
+	Namespace namespaceConfig  `toml:"namespace"`
 }
 
 type opcConfig struct {
 	Server string `toml:"server"`
 	Nodes  []string
 	Tags   []string
+	Filter *opc.FilterRules `toml:"filter"`
+}
+
+// namespaceConfig mounts the server with snapshots of other servers into one
+// browse-only namespace
+type namespaceConfig struct {
+	Prefix    string            `toml:"prefix"`
+	Snapshots map[string]string `toml:"snapshots"`
+	Overlay   string            `toml:"overlay"`
+}
+
+// load returns the namespace of the config or nil if it is empty
+func (c namespaceConfig) load() (*api.Namespace, error) {
+	if c.Prefix == "" && len(c.Snapshots) == 0 && c.Overlay == "" {
+		return nil, nil
+	}
+	n := &api.Namespace{Prefix: c.Prefix, Snapshots: make(map[string]*opc.Tree)}
+	for prefix, file := range c.Snapshots {
+		tree, err := opc.LoadTree(file)
+		if err != nil {
+			return nil, err
+		}
+		n.Snapshots[prefix] = tree
+	}
+	if c.Overlay != "" {
+		tree, err := opc.LoadTree(c.Overlay)
+		if err != nil {
+			return nil, err
+		}
+		n.Overlay = tree
+	}
+	return n, nil
+}
+
+type metricsConfig struct {
+	Addr         string   `toml:"addr"`
+	Tags         []string `toml:"tags"`
+	AllTags      bool     `toml:"all_tags"`
+	QualityLabel bool     `toml:"quality_label"`
 }
 
 func main() {
 	flag.Parse()
+	run.Main(*timeout, serve)
+}
 
-	opc.Debug()
+// serve connects the OPC server and serves the API until ctx is done
+func serve(ctx context.Context, shutdown *run.Shutdown) error {
+	if err := opc.ConfigureLogging(*logLevel, *logFormat); err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
 
 	// parse config
 	data, err := ioutil.ReadFile(*cfgFile)
 	if err != nil {
-		panic(err)
+		return run.Errorf(run.ExitUsage, "%v", err)
 	}
 
 	// parse config
 	var cfg tmlConfig
 	if _, err := toml.Decode(string(data), &cfg); err != nil {
-		log.Fatal(err)
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
+
+	// OpenTelemetry tracing and metrics
+	if cfg.Telemetry.ServiceName == "" {
+		cfg.Telemetry.ServiceName = "opcapi"
+	}
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), cfg.Telemetry)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
 	}
+	shutdown.AddDetached("telemetry", shutdownTelemetry)
 
 	server := cfg.Opc.Server
 	if server == "" {
 		server = strings.Trim(os.Getenv("OPC_SERVER"), " ")
 		if server == "" {
-			panic("OPC_SERVER not set")
+			return run.Errorf(run.ExitUsage, "OPC_SERVER not set")
 		}
 	}
 	nodes := cfg.Opc.Nodes
 	if len(nodes) == 0 {
 		nodes = strings.Split(os.Getenv("OPC_NODES"), ",")
 		if len(nodes) == 0 {
-			panic("OPC_NODES not set; separate nodes with ','")
+			return run.Errorf(run.ExitUsage, "OPC_NODES not set; separate nodes with ','")
 		}
 	}
 	for i := range nodes {
 		nodes[i] = strings.Trim(nodes[i], " ")
 	}
 
+	// select tags by pattern
+	if cfg.Opc.Filter != nil {
+		filter, err := opc.NewFilter(*cfg.Opc.Filter)
+		if err != nil {
+			return run.Errorf(run.ExitUsage, "%v", err)
+		}
+		tree, err := opc.CreateBrowser(server, nodes)
+		if err != nil {
+			return run.Errorf(run.ExitUnavailable, "%v", err)
+		}
+		cfg.Opc.Tags = opc.AddTags(cfg.Opc.Tags, opc.CollectTags(filter.Apply(tree))...)
+	}
+
+	namespace, err := cfg.Namespace.load()
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "namespace error: %v", err)
+	}
+
 	fmt.Println("API starting with OPC", server, nodes, *addr)
 
 	client, err := opc.NewConnection(
@@ -74,14 +157,57 @@ func main() {
 		nodes,
 		cfg.Opc.Tags,
 	)
-	defer client.Close()
-
 	if err != nil {
-		panic(err)
+		return run.Errorf(run.ExitUnavailable, "%v", err)
+	}
+	shutdown.Add("opc", func(context.Context) error {
+		client.Close()
+		return nil
+	})
+
+	// expose metrics and tag values to Prometheus
+	if cfg.Metrics.Addr != "" {
+		reg := prometheus.NewRegistry()
+		if err := opc.RegisterMetrics(reg); err != nil {
+			return err
+		}
+		if cfg.Metrics.AllTags || len(cfg.Metrics.Tags) > 0 {
+			reg.MustRegister(opc.NewTagCollector(client, opc.TagCollectorOptions{
+				Tags:         cfg.Metrics.Tags,
+				QualityLabel: cfg.Metrics.QualityLabel,
+			}))
+		}
+		metrics := opc.NewMetricsServer(cfg.Metrics.Addr, reg)
+		shutdown.AddDetached("metrics", metrics.Shutdown)
+		go func() {
+			if err := metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				opc.Logger().Error("metrics server failed", "addr", cfg.Metrics.Addr, "error", err)
+			}
+		}()
+	}
+
+	if cfg.Telemetry.Enabled() {
+		client = telemetry.TraceConnection(client, nil)
 	}
 
-	app := api.App{Config: cfg.Config}
+	app := api.App{Config: cfg.Config, Namespace: namespace}
 	app.Initialize(client)
+	if cfg.Telemetry.Enabled() {
+		app.Router.Use(telemetry.Middleware(nil))
+	}
+	app.Browse = func() (*opc.Tree, error) {
+		return opc.CreateBrowser(server, nodes)
+	}
 
-	app.Run(*addr)
+	// finish the running requests before closing the connection
+	srv := app.Server(*addr)
+	shutdown.Add("api", srv.Shutdown)
+	errC := make(chan error, 1)
+	go func() { errC <- srv.ListenAndServe() }()
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errC:
+		return run.Errorf(run.ExitUnavailable, "api server failed: %v", err)
+	}
 }
@@ -3,23 +3,31 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/bridge"
+	"github.com/konimarti/opc/cmds/internal/run"
+	"github.com/konimarti/opc/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 	govaluate "gopkg.in/Knetic/govaluate.v3"
 	yaml "gopkg.in/yaml.v2"
 )
 
 var (
-	config = flag.String("conf", "influx.yml", "yaml config file for tag descriptions")
-	rr     = flag.String("rate", "10s", "refresh rate as duration, e.g. 100ms, 5s, 10s, 2m")
+	config    = flag.String("conf", "influx.yml", "yaml config file for tag descriptions")
+	rr        = flag.String("rate", "10s", "refresh rate as duration, e.g. 100ms, 5s, 10s, 2m")
+	logLevel  = flag.String("log-level", "info", "log level: debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "log format: text, json")
+	timeout   = flag.Duration("shutdown-timeout", run.DefaultTimeout, "time to finish writing and close the connections after SIGINT or SIGTERM")
 )
 
 // M stores an InfluxDB measurement
@@ -42,28 +50,51 @@ type Conf struct {
 	Server       string
 	Nodes        []string
 	Monitoring   string
+	Telemetry    telemetry.Config
 	Influx       Database
+	Queue        *bridge.QueueConfig // buffer the points on disk while InfluxDB is unreachable
 	Measurements map[string][]M
 }
 
 func main() {
 	flag.Parse()
+	run.Main(*timeout, bridgeInflux)
+}
+
+// bridgeInflux connects the OPC server and writes the measurements to InfluxDB until ctx is done
+func bridgeInflux(ctx context.Context, shutdown *run.Shutdown) error {
+	if err := opc.ConfigureLogging(*logLevel, *logFormat); err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
 
 	//set refresh rate
 	refreshRate, err := time.ParseDuration(*rr)
 	if err != nil {
-		log.Fatalf("error setting refresh rate")
+		return run.Errorf(run.ExitUsage, "error setting refresh rate: %v", err)
 	}
-	fmt.Println("refresh rate: ", refreshRate)
+	slog.Info("refresh rate", "duration", refreshRate)
 
 	// read config
-	conf := getConfig(*config)
+	conf, err := getConfig(*config)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
 
 	//app monitoring
 	if conf.Monitoring != "" {
 		opc.StartMonitoring(conf.Monitoring)
 	}
 
+	// OpenTelemetry tracing and metrics
+	if conf.Telemetry.ServiceName == "" {
+		conf.Telemetry.ServiceName = "opcflux"
+	}
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), conf.Telemetry)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "telemetry error: %v", err)
+	}
+	shutdown.AddDetached("telemetry", shutdownTelemetry)
+
 	// extract tags
 	tags := []string{}
 	exprMap := make(map[string]*govaluate.EvaluableExpression)
@@ -72,8 +103,7 @@ func main() {
 			for _, f := range m.Fields {
 				expr, err := govaluate.NewEvaluableExpression(f)
 				if err != nil {
-					fmt.Println("Could not parse", f)
-					panic(err)
+					return run.Errorf(run.ExitUsage, "could not parse %s: %v", f, err)
 				}
 				exprMap[f] = expr
 				tags = append(tags, expr.Vars()...)
@@ -89,11 +119,18 @@ func main() {
 		//Password: conf.Influx.Password,
 	})
 	if err != nil {
-		fmt.Println(err)
-		panic("Error creating InfluxDB Client")
+		return run.Errorf(run.ExitUsage, "error creating InfluxDB client: %v", err)
+	}
+	shutdown.Add("influx", func(context.Context) error { return c.Close() })
+	slog.Info("writing to influx", "database", conf.Influx.Database, "addr", conf.Influx.Addr)
+
+	batchconfig := client.BatchPointsConfig{
+		Database:  conf.Influx.Database,
+		Precision: conf.Influx.Precision, // "s"
+	}
+	if _, err := client.NewBatchPoints(batchconfig); err != nil {
+		return run.Errorf(run.ExitUsage, "influx config error: %v", err)
 	}
-	defer c.Close()
-	fmt.Println("Writing to", conf.Influx.Database, "at", conf.Influx.Addr)
 
 	if conf.Server == "" {
 		conf.Server = strings.Trim(os.Getenv("OPC_SERVER"), " ")
@@ -108,101 +145,184 @@ func main() {
 		tags,
 	)
 	if err != nil {
-		fmt.Println("Could not create OPC connection.")
-		panic(err)
+		return run.Errorf(run.ExitUnavailable, "could not create OPC connection: %v", err)
+	}
+	shutdown.Add("opc", func(context.Context) error {
+		conn.Close()
+		return nil
+	})
+	if conf.Telemetry.Enabled() {
+		conn = telemetry.TraceConnection(conn, nil)
 	}
 
-	timeC := make(chan time.Time, 10)
-
-	// start go routine
-	go writeState(timeC, c, conn, conf, exprMap)
-
-	// start ticker
-	ticker := time.NewTicker(refreshRate)
-	for tick := range ticker.C {
-		timeC <- tick
+	w := &writer{c: tracedClient{Client: c, database: conf.Influx.Database}, conn: conn, conf: conf, batchconfig: batchconfig, exprMap: exprMap}
+	if conf.Queue != nil {
+		if w.queue, err = bridge.OpenQueue("influx", *conf.Queue); err != nil {
+			return run.Errorf(run.ExitUsage, "queue error: %v", err)
+		}
+		shutdown.Add("queue", func(ctx context.Context) error {
+			// the backlog has the shutdown timeout to drain
+			w.forward(ctx)
+			return w.queue.Close()
+		})
+		slog.Info("buffering points", "dir", conf.Queue.Dir, "backlog", w.queue.Len())
 	}
+
+	return run.Tick(ctx, refreshRate, w.writeState)
 }
 
 // getConfig parses configuration file
-func getConfig(config string) *Conf {
-	log.Println("config file: ", config)
+func getConfig(config string) (*Conf, error) {
+	slog.Info("reading config", "file", config)
 
 	content, err := ioutil.ReadFile(config)
 	if err != nil {
-		log.Fatalf("error reading config file %s", config)
+		return nil, fmt.Errorf("error reading config file %s: %v", config, err)
 	}
 
 	conf := Conf{}
 	err = yaml.Unmarshal([]byte(content), &conf)
 	if err != nil {
-		log.Fatalf("error yaml unmarshalling: %v", err)
+		return nil, fmt.Errorf("error yaml unmarshalling: %v", err)
 	}
 
 	// fmt.Printf("--- conf:\n%v\n\n", conf)
 
-	return &conf
+	return &conf, nil
 }
 
-// writeState collects data and writes it to the influx database
-func writeState(timeC chan time.Time, c client.Client, conn opc.Connection, conf *Conf, exprMap map[string]*govaluate.EvaluableExpression) {
-
-	batchconfig := client.BatchPointsConfig{
-		Database:  conf.Influx.Database,
-		Precision: conf.Influx.Precision, // "s"
-	}
+// writer writes the measurements of every read to the database
+type writer struct {
+	c           tracedClient
+	conn        opc.Connection
+	queue       *bridge.Queue
+	conf        *Conf
+	batchconfig client.BatchPointsConfig
+	exprMap     map[string]*govaluate.EvaluableExpression
+}
 
-	for t := range timeC {
+// writeState collects data and writes it to the influx database. Failed writes
+// are logged; an error stops the bridge. The read and the writes are children
+// of the tick span.
+func (w *writer) writeState(ctx context.Context, t time.Time) error {
+	return telemetry.WithSpan(ctx, "influx.tick", func(ctx context.Context) error {
+		return w.write(ctx, t)
+	})
+}
 
-		// read data
-		data := adapter(conn.Read())
+// write reads the tags and writes the points of time t
+func (w *writer) write(ctx context.Context, t time.Time) error {
+	// read data
+	data := adapter(opc.WithContext(ctx, w.conn).Read())
 
-		// create a new point batch
-		bp, err := client.NewBatchPoints(batchconfig)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	// create a new point batch
+	bp, err := client.NewBatchPoints(w.batchconfig)
+	if err != nil {
+		return fmt.Errorf("cannot create batch points: %v", err)
+	}
 
-		// define measurement and create data points
-		for measurement, group := range conf.Measurements {
-			//t := time.Now().Local()
-			//data := conn.Read()
-			for _, m := range group {
-				tagMap := m.Tags
-				fieldMap := make(map[string]interface{})
-
-				for fieldKey, f := range m.Fields {
-					ist, err := exprMap[f].Evaluate(data)
-					if err != nil {
-						fmt.Println(err)
-						continue
-					}
-					fieldMap[fieldKey] = ist
-				}
+	// define measurement and create data points
+	for measurement, group := range w.conf.Measurements {
+		for _, m := range group {
+			tagMap := m.Tags
+			fieldMap := make(map[string]interface{})
 
-				// create influx data points
-				pt, err := client.NewPoint(measurement, tagMap, fieldMap, t)
+			for fieldKey, f := range m.Fields {
+				ist, err := w.exprMap[f].Evaluate(data)
 				if err != nil {
-					fmt.Println("Error: ", err.Error())
+					slog.Warn("cannot evaluate field", "measurement", measurement, "field", fieldKey, "expression", f, "error", err)
+					continue
 				}
+				fieldMap[fieldKey] = ist
+			}
 
-				// add data point to batch
-				bp.AddPoint(pt)
+			// create influx data points
+			pt, err := client.NewPoint(measurement, tagMap, fieldMap, t)
+			if err != nil {
+				slog.Error("cannot create point", "measurement", measurement, "error", err)
+				continue
 			}
+
+			// add data point to batch
+			bp.AddPoint(pt)
 		}
+	}
+
+	if w.queue == nil {
+		w.c.withContext(ctx).Write(bp)
+		return nil
+	}
 
-		// write to database
-		if err := c.Write(bp); err != nil {
-			fmt.Println(err)
+	// queue the batch as line protocol with the timestamps of the points
+	if data := bridge.MarshalBatch(bp); data != nil {
+		if err := w.queue.Append(data); err != nil {
+			slog.Error("cannot queue points", "error", err)
 		}
 	}
+	w.forward(ctx)
+	return nil
+}
+
+// forward writes the queued batches until the database fails or ctx is done
+func (w *writer) forward(ctx context.Context) {
+	if n, err := bridge.ForwardBatches(ctx, w.queue, w.c.withContext(ctx), w.batchconfig); err != nil {
+		slog.Warn("influx points buffered", "forwarded", n, "backlog", w.queue.Len(), "error", err)
+	}
+}
+
+// tracedClient traces and logs the writes of the client
+type tracedClient struct {
+	client.Client
+	database string
+	ctx      context.Context // parent of the write spans, may be nil
+}
+
+// withContext returns a copy of the client that writes with ctx as parent span
+func (c tracedClient) withContext(ctx context.Context) tracedClient {
+	c.ctx = ctx
+	return c
+}
+
+// Write writes the batch to the database
+func (c tracedClient) Write(bp client.BatchPoints) error {
+	start := time.Now()
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err := telemetry.WithSpan(ctx, "influx.write", func(ctx context.Context) error {
+		return c.Client.Write(bp)
+	}, attribute.Int("influx.points", len(bp.Points())))
+	if err != nil {
+		opc.DefaultMetrics.ObserveRequest("influx", "write", "failed", time.Since(start))
+		slog.Error("influx write failed", "database", c.database, "duration", time.Since(start), "error", err)
+		return err
+	}
+	opc.DefaultMetrics.ObserveRequest("influx", "write", "success", time.Since(start))
+	return nil
 }
 
 func adapter(input map[string]opc.Item) map[string]interface{} {
 	output := make(map[string]interface{})
 	for key, item := range input {
-		output[key] = item.Value
+		output[key] = evaluable(item)
 	}
 	return output
 }
+
+// evaluable converts the item value to a type govaluate can work with:
+// bool and string are kept, numbers are converted to float64
+// and timestamps are formatted as strings.
+func evaluable(item opc.Item) interface{} {
+	switch item.Value.(type) {
+	case bool, string:
+		return item.Value
+	case time.Time:
+		s, _ := item.Text()
+		return s
+	}
+	if f, err := item.Float64(); err == nil {
+		return f
+	}
+	return item.Value
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/bridge"
+	"github.com/konimarti/opc/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// subscribeCommands subscribes to the command topics and writes the values to the
+// OPC server. The results are published on the response topic.
+func subscribeCommands(c mqtt.Client, commander *bridge.Commander) {
+	topic := commander.Subscription()
+	token := c.Subscribe(topic, commander.QoS(), func(c mqtt.Client, m mqtt.Message) {
+		if commander.IsResponse(m.Topic()) {
+			return
+		}
+		// the write and the response are children of the command span
+		telemetry.WithSpan(context.Background(), "mqtt.command", func(ctx context.Context) error {
+			t := time.Now()
+			result, response := commander.Handle(ctx, m.Topic(), m.Payload())
+			if result.Success {
+				opc.DefaultMetrics.ObserveRequest("mqtt", "command", "success", time.Since(t))
+				slog.Info("mqtt command", "tag", result.Tag, "correlation_id", result.CorrelationID, "duration", time.Since(t))
+			} else {
+				opc.DefaultMetrics.ObserveRequest("mqtt", "command", "failed", time.Since(t))
+				slog.Warn("mqtt command failed", "topic", m.Topic(), "tag", result.Tag, "correlation_id", result.CorrelationID, "error", result.Error)
+			}
+			if response != nil {
+				publish(ctx, c, *response)
+			}
+			if !result.Success {
+				return errors.New(result.Error)
+			}
+			return nil
+		}, attribute.String("mqtt.topic", m.Topic()))
+	})
+	if token.Wait() && token.Error() != nil {
+		slog.Error("mqtt subscribe failed", "topic", topic, "error", token.Error())
+		return
+	}
+	slog.Info("subscribed to commands", "topic", topic)
+}
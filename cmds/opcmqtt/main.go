@@ -1,122 +1,440 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/bridge"
+	"github.com/konimarti/opc/cmds/internal/run"
+	"github.com/konimarti/opc/sparkplug"
+	"github.com/konimarti/opc/telemetry"
+	"github.com/konimarti/opc/wire"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v2"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 )
 
 var (
-	config = flag.String("conf", "./cmds/opcmqtt/mqtt.yml", "yaml config file for tag transport descriptions")
+	config    = flag.String("conf", "./cmds/opcmqtt/mqtt.yml", "yaml config file for tag transport descriptions")
+	logLevel  = flag.String("log-level", "info", "log level: debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "log format: text, json")
+	timeout   = flag.Duration("shutdown-timeout", run.DefaultTimeout, "time to finish publishing and disconnect after SIGINT or SIGTERM")
 )
 
 type MqttBroker struct {
-	Addr     string
-	Username string
-	Password string
-	Topic    string
+	bridge.MQTTOptions `yaml:",inline"`   // address, credentials, client ID, session and TLS
+	Topic              string             // topic of the snapshot of all tags, optional with topics
+	Encoding           string             `yaml:"encoding"` // json (default), cbor or protobuf
+	QoS                byte               `yaml:"qos"`      // QoS of the snapshot topic
+	Retain             bool               `yaml:"retain"`   // retain flag of the snapshot topic
+	Topics             []bridge.TopicRule `yaml:"topics"`   // per tag or per group topics
 }
 
 type Conf struct {
-	Server      string     `yaml:"server"`
-	Nodes       []string   `yaml:"nodes"`
-	RefreshRate string     `yaml:"refreshRate"`
-	Mqtt        MqttBroker `yaml:"mqtt"`
-	Tags        []string   `yaml:"tags"`
+	Server      string                  `yaml:"server"`
+	Nodes       []string                `yaml:"nodes"`
+	RefreshRate string                  `yaml:"refreshRate"`
+	Mqtt        MqttBroker              `yaml:"mqtt"`
+	Tags        []string                `yaml:"tags"`
+	Filter      *opc.FilterRules        `yaml:"filter"`
+	Exception   *bridge.ExceptionConfig `yaml:"exception"` // report by exception
+	Sparkplug   *sparkplug.Config       `yaml:"sparkplug"` // publish as Sparkplug B edge node
+	Commands    *bridge.CommandConfig   `yaml:"commands"`  // write values from MQTT to OPC
+	Queue       *bridge.QueueConfig     `yaml:"queue"`     // buffer messages on disk while the broker is unreachable
+	Status      *bridge.StatusConfig    `yaml:"status"`    // online/offline and health topics
+	Telemetry   telemetry.Config        `yaml:"telemetry"`
 }
 
 // getConfig parses configuration file
-func getConfig(config string) *Conf {
-	log.Println("config file: ", config)
+func getConfig(config string) (*Conf, error) {
+	slog.Info("reading config", "file", config)
 
 	content, err := os.ReadFile(config)
 	if err != nil {
-		log.Fatalf("error reading config file %s", config)
+		return nil, fmt.Errorf("error reading config file %s: %v", config, err)
 	}
 
 	conf := Conf{}
 	err = yaml.Unmarshal(content, &conf)
 	if err != nil {
-		log.Fatalf("error yaml unmarshalling: %v", err)
+		return nil, fmt.Errorf("error yaml unmarshalling: %v", err)
 	}
 
-	return &conf
+	return &conf, nil
 }
 
 func main() {
 	flag.Parse()
+	run.Main(*timeout, bridgeMQTT)
+}
+
+// bridgeMQTT connects the OPC server and the broker and publishes the tags until ctx is done
+func bridgeMQTT(ctx context.Context, shutdown *run.Shutdown) error {
+	if err := opc.ConfigureLogging(*logLevel, *logFormat); err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
+
+	conf, err := getConfig(*config)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
 
-	conf := getConfig(*config)
+	//set refresh rate
+	refreshRate, err := time.ParseDuration(conf.RefreshRate)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "error setting refresh rate: %v", err)
+	}
+
+	// OpenTelemetry tracing and metrics
+	if conf.Telemetry.ServiceName == "" {
+		conf.Telemetry.ServiceName = "opcmqtt"
+	}
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), conf.Telemetry)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "telemetry error: %v", err)
+	}
+	shutdown.AddDetached("telemetry", shutdownTelemetry)
+
+	if conf.Status != nil && conf.Sparkplug != nil {
+		// MQTT has a single last will per client, the offline status or the NDEATH
+		return run.Errorf(run.ExitUsage, "status and sparkplug cannot be combined, sparkplug reports the state with NBIRTH and NDEATH")
+	}
+
+	// select tags by pattern
+	if conf.Filter != nil {
+		tags, err := filterTags(conf.Server, conf.Nodes, *conf.Filter)
+		if err != nil {
+			return run.Errorf(run.ExitUnavailable, "opc browse error: %v", err)
+		}
+		conf.Tags = opc.AddTags(conf.Tags, tags...)
+	}
+
+	// report the state of the bridge and the OPC connection
+	var status *bridge.Status
+	var connMqtt mqtt.Client
+	if conf.Status != nil {
+		if status, err = bridge.NewStatus(*conf.Status, len(conf.Tags)); err != nil {
+			return run.Errorf(run.ExitUsage, "status config error: %v", err)
+		}
+		watchConnection(status, func() mqtt.Client { return connMqtt })
+	}
 
 	// connect opc server
-	opc.Debug()
 	connOpc, err := opc.NewConnection(conf.Server, conf.Nodes, conf.Tags)
 	if err != nil {
-		log.Fatalf("opc connection error: %v", err)
+		return run.Errorf(run.ExitUnavailable, "opc connection error: %v", err)
+	}
+	shutdown.Add("opc", func(context.Context) error {
+		connOpc.Close()
+		return nil
+	})
+	if conf.Telemetry.Enabled() {
+		connOpc = telemetry.TraceConnection(connOpc, nil)
 	}
 
 	// connect mqtt broker
-	opts := mqtt.NewClientOptions().AddBroker(conf.Mqtt.Addr)
-	opts.SetClientID("opc tags")
+	opts, err := conf.Mqtt.ClientOptions()
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "mqtt config error: %v", err)
+	}
+	var commander *bridge.Commander
+	if conf.Commands != nil {
+		if commander, err = bridge.NewCommander(*conf.Commands, connOpc); err != nil {
+			return run.Errorf(run.ExitUsage, "commands config error: %v", err)
+		}
+	}
+	if status != nil {
+		offline := status.Offline()
+		opts.SetBinaryWill(offline.Topic, offline.Payload, offline.QoS, offline.Retain)
+	}
+	var node *edgeNode
+	if conf.Sparkplug != nil {
+		if node, err = newEdgeNode(*conf.Sparkplug, conf.Tags, connOpc, commander, opts); err != nil {
+			return run.Errorf(run.ExitUsage, "sparkplug config error: %v", err)
+		}
+	}
+	t, err := newTransport(conf, connOpc, node, status)
+	if err != nil {
+		return run.Errorf(run.ExitUsage, "%v", err)
+	}
+	if conf.Queue != nil {
+		if t.queue, err = bridge.OpenQueue("mqtt", *conf.Queue); err != nil {
+			return run.Errorf(run.ExitUsage, "queue error: %v", err)
+		}
+		// closed after the disconnect, which is registered later
+		shutdown.Add("queue", func(context.Context) error { return t.queue.Close() })
+		slog.Info("buffering messages", "dir", conf.Queue.Dir, "backlog", t.queue.Len())
+	}
 
-	connMqtt := mqtt.NewClient(opts)
-	if token := connMqtt.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("mqtt connect error: %v", token.Error())
+	// subscribe again after every reconnect; handlers publish, so they must not block the client
+	opts.SetOrderMatters(false)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		if t.exception != nil {
+			// consumers get all tags after a reconnect, not only the next changes
+			t.exception.Reset()
+		}
+		if status != nil {
+			publishOnline(c, status)
+		}
+		if commander != nil {
+			subscribeCommands(c, commander)
+		}
+		if node != nil {
+			node.onConnect(c)
+		}
+	})
+	opts.SetAutoReconnect(true)
+	if t.queue != nil {
+		// buffer the messages while the broker is unreachable, also at startup
+		opts.SetConnectRetry(true)
 	}
+	slog.Info("connecting to mqtt broker", "addr", conf.Mqtt.Addr, "client_id", opts.ClientID)
 
-	//set refresh rate
-	refreshRate, err := time.ParseDuration(conf.RefreshRate)
-	if err != nil {
-		log.Fatalf("error setting refresh rate")
+	connMqtt = mqtt.NewClient(opts)
+	token := connMqtt.Connect()
+	if t.queue == nil {
+		if token.Wait() && token.Error() != nil {
+			return run.Errorf(run.ExitUnavailable, "mqtt connect error: %v", token.Error())
+		}
+	} else if !token.WaitTimeout(opts.ConnectTimeout) {
+		// the token completes after the first successful connect
+		slog.Warn("mqtt broker not reachable, buffering messages", "addr", conf.Mqtt.Addr)
+	} else if token.Error() != nil {
+		return run.Errorf(run.ExitUnavailable, "mqtt connect error: %v", token.Error())
 	}
+	t.connMqtt = connMqtt
+	shutdown.Add("mqtt", func(ctx context.Context) error {
+		if t.queue != nil {
+			// the backlog has the shutdown timeout to drain
+			forward(ctx, connMqtt, t.queue)
+		}
+		// a clean disconnect does not publish the last will
+		if node != nil {
+			node.death(ctx, connMqtt)
+		}
+		if status != nil {
+			publish(ctx, connMqtt, status.Offline())
+		}
+		connMqtt.Disconnect(1000)
+		slog.Info("disconnected from mqtt broker", "addr", conf.Mqtt.Addr)
+		return nil
+	})
 
-	timeC := make(chan time.Time, 10)
+	if status != nil {
+		go reportHealth(ctx, connMqtt, status)
+	}
 
-	// Do ticker task
-	go transport(timeC, connOpc, connMqtt, conf)
+	return run.Tick(ctx, refreshRate, t.tick)
+}
 
-	// start ticker
-	ticker := time.NewTicker(refreshRate)
-	for tick := range ticker.C {
-		timeC <- tick
+// filterTags browses the server and returns the tags selected by the rules
+func filterTags(server string, nodes []string, rules opc.FilterRules) ([]string, error) {
+	filter, err := opc.NewFilter(rules)
+	if err != nil {
+		return nil, err
 	}
+	tree, err := opc.CreateBrowser(server, nodes)
+	if err != nil {
+		return nil, err
+	}
+	tags := opc.CollectTags(filter.Apply(tree))
+	slog.Info("filter selected tags", "server", server, "tags", len(tags))
+	return tags, nil
 }
 
-func adapter(data map[string]opc.Item) map[string]interface{} {
-	output := make(map[string]interface{})
+func adapter(data map[string]opc.Item) map[string]opc.Item {
+	output := make(map[string]opc.Item)
 	for k, item := range data {
 		if item.Good() {
-			output[k] = item.Value
+			output[k] = item
 		} else {
-			log.Printf("k=%v, item=%v not good tag\n", k, item)
+			slog.Debug("skipping tag with bad quality", "tag", k, "quality", item.Quality, "value", item.Value)
 		}
 	}
 	return output
 }
 
-func transport(timeC chan time.Time, connOpc opc.Connection, connMqtt mqtt.Client, conf *Conf) {
-	for range timeC {
-		data := adapter(connOpc.Read())
+// transport publishes the tags of every read
+type transport struct {
+	connOpc   opc.Connection
+	connMqtt  mqtt.Client
+	codec     wire.Codec
+	router    *bridge.Router
+	exception *bridge.Exception
+	node      *edgeNode
+	queue     *bridge.Queue
+	status    *bridge.Status
+}
+
+// newTransport creates the router and the exception filter of the config
+func newTransport(conf *Conf, connOpc opc.Connection, node *edgeNode, status *bridge.Status) (*transport, error) {
+	t := &transport{connOpc: connOpc, node: node, status: status}
+	var err error
+	if t.codec, err = wire.Lookup(conf.Mqtt.Encoding); err != nil {
+		return nil, fmt.Errorf("mqtt encoding error: %v", err)
+	}
+	rules := conf.Mqtt.Topics
+	if conf.Mqtt.Topic != "" {
+		// publish the snapshot of all tags like before the topic rules
+		rules = append([]bridge.TopicRule{{
+			Topic:   conf.Mqtt.Topic,
+			Payload: bridge.PayloadSnapshot,
+			QoS:     conf.Mqtt.QoS,
+			Retain:  conf.Mqtt.Retain,
+		}}, rules...)
+	}
+	if t.router, err = bridge.NewRouter(rules, t.codec); err != nil {
+		return nil, fmt.Errorf("mqtt topics error: %v", err)
+	}
+	if conf.Exception != nil {
+		if t.exception, err = bridge.NewException(*conf.Exception); err != nil {
+			return nil, fmt.Errorf("exception config error: %v", err)
+		}
+	}
+	return t, nil
+}
+
+// tick reads the tags and publishes them; errors are logged, so the bridge keeps running.
+// All publishes of a tick share one publishTimeout, so a tick over many topics or a
+// backlog cannot block the shutdown.
+func (t *transport) tick(ctx context.Context, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	// the read and the publishes are children of the tick span
+	telemetry.WithSpan(ctx, "mqtt.tick", t.report)
+	return nil
+}
+
+// report reads the tags and publishes the changes and the backlog; the publish
+// error is recorded in the tick span
+func (t *transport) report(ctx context.Context) error {
+	if t.queue != nil {
+		// publish the backlog even if there is no new data
+		forward(ctx, t.connMqtt, t.queue)
+	}
+
+	items := opc.WithContext(ctx, t.connOpc).Read()
+	if t.status != nil {
+		t.status.ObserveRead(items, time.Now())
+	}
+
+	var data map[string]opc.Item
+	read := time.Now()
+	if t.exception != nil {
+		// report quality changes including bad quality
+		data = t.exception.Filter(items, read)
 		if len(data) == 0 {
-			log.Printf("empty data")
-			continue
+			slog.Debug("no changes to publish")
+			return nil
+		}
+	} else {
+		data = adapter(items)
+		if len(data) == 0 {
+			slog.Warn("no good tags to publish")
+			return nil
+		}
+	}
+
+	// the changes are reported again with the next tick unless all were sent or queued
+	var failed error
+	if t.node != nil {
+		failed = t.node.data(ctx, t.connMqtt, data)
+	}
+
+	messages, err := t.router.Messages(data)
+	if err != nil {
+		slog.Error("cannot encode payload", "encoding", t.codec.Name(), "error", err)
+	}
+	if t.queue != nil {
+		if err := enqueue(t.queue, messages); err != nil {
+			failed = err
 		}
+		forward(ctx, t.connMqtt, t.queue)
+	} else {
+		for _, m := range messages {
+			if err := publish(ctx, t.connMqtt, m); err != nil {
+				failed = err
+			}
+		}
+	}
+	if t.exception != nil && failed == nil {
+		t.exception.Commit(data, read)
+	}
+	return failed
+}
 
-		b, err := json.Marshal(data)
+// enqueue appends the messages to the queue and returns the last error
+func enqueue(queue *bridge.Queue, messages []bridge.Message) error {
+	var failed error
+	for _, m := range messages {
+		b, err := json.Marshal(m)
+		if err == nil {
+			err = queue.Append(b)
+		}
 		if err != nil {
-			log.Printf("error marshalling: %v, data: %v\n", err, data)
-			continue
+			slog.Error("cannot queue message", "topic", m.Topic, "error", err)
+			failed = err
 		}
+	}
+	return failed
+}
 
-		if token := connMqtt.Publish(conf.Mqtt.Topic, 0, false, b); token.Wait() && token.Error() != nil {
-			log.Printf("mqtt publish error: %v", token.Error())
-			continue
+// forward publishes the queued messages in order until the broker fails
+func forward(ctx context.Context, connMqtt mqtt.Client, queue *bridge.Queue) {
+	if queue.Len() == 0 {
+		return
+	}
+	n, err := queue.Forward(func(data []byte) error {
+		var m bridge.Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			// drop messages that cannot be decoded instead of blocking the queue
+			slog.Error("invalid queued message", "error", err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		log.Printf("send data=%+v success\n", data)
+		if !connMqtt.IsConnectionOpen() {
+			return errors.New("mqtt not connected")
+		}
+		return publish(ctx, connMqtt, m)
+	})
+	if err != nil {
+		slog.Warn("mqtt messages buffered", "forwarded", n, "backlog", queue.Len(), "error", err)
+	}
+}
+
+// publishTimeout limits the wait for the acknowledgment of a publish
+const publishTimeout = 10 * time.Second
+
+// publish sends a message to the broker and waits for the acknowledgment until
+// ctx is done, at most publishTimeout
+func publish(ctx context.Context, connMqtt mqtt.Client, m bridge.Message) error {
+	t := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	err := telemetry.WithSpan(ctx, "mqtt.publish", func(ctx context.Context) error {
+		token := connMqtt.Publish(m.Topic, m.QoS, m.Retain, m.Payload)
+		select {
+		case <-token.Done():
+			return token.Error()
+		case <-ctx.Done():
+			// e.g. QoS 1 messages while the client reconnects
+			return errors.New("mqtt publish timed out")
+		}
+	}, attribute.String("mqtt.topic", m.Topic))
+	if err != nil {
+		opc.DefaultMetrics.ObserveRequest("mqtt", "publish", "failed", time.Since(t))
+		slog.Error("mqtt publish failed", "topic", m.Topic, "duration", time.Since(t), "error", err)
+		return err
 	}
+	opc.DefaultMetrics.ObserveRequest("mqtt", "publish", "success", time.Since(t))
+	slog.Debug("mqtt publish", "topic", m.Topic, "bytes", len(m.Payload), "duration", time.Since(t))
+	return nil
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/bridge"
+	"github.com/konimarti/opc/sparkplug"
+	"github.com/konimarti/opc/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// edgeNode publishes the tags as Sparkplug B edge node
+type edgeNode struct {
+	*sparkplug.EdgeNode
+	conn      opc.Connection
+	commander *bridge.Commander // allows writes, nil if writes are disabled
+	mu        sync.Mutex        // publishes births and data in the order of their sequence numbers
+}
+
+// newEdgeNode registers the death certificate as last will in the client options
+// and a new one with the next bdSeq before every reconnect. onConnect has to be
+// called after every connect.
+func newEdgeNode(cfg sparkplug.Config, tags []string, conn opc.Connection, commander *bridge.Commander, opts *mqtt.ClientOptions) (*edgeNode, error) {
+	node, err := sparkplug.NewEdgeNode(cfg, tags)
+	if err != nil {
+		return nil, err
+	}
+	death, err := node.Death()
+	if err != nil {
+		return nil, err
+	}
+	n := &edgeNode{EdgeNode: node, conn: conn, commander: commander}
+	opts.SetBinaryWill(death.Topic, death.Payload, death.QoS, death.Retain)
+	opts.SetReconnectingHandler(func(_ mqtt.Client, o *mqtt.ClientOptions) {
+		death, err := node.Reconnect()
+		if err != nil {
+			// keep the will of the last session, the birth still matches it
+			slog.Error("sparkplug bdSeq not updated", "error", err)
+			return
+		}
+		o.SetBinaryWill(death.Topic, death.Payload, death.QoS, death.Retain)
+	})
+	return n, nil
+}
+
+// onConnect subscribes to the command topics and publishes the births
+func (n *edgeNode) onConnect(c mqtt.Client) {
+	for _, topic := range n.CommandTopics() {
+		if token := c.Subscribe(topic, 1, n.onCommand); token.Wait() && token.Error() != nil {
+			slog.Error("sparkplug subscribe failed", "topic", topic, "error", token.Error())
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.birth(context.Background(), c)
+}
+
+// onCommand handles rebirth requests and writes the values of the allowed tags
+func (n *edgeNode) onCommand(c mqtt.Client, m mqtt.Message) {
+	cmd, err := n.Command(m.Topic(), m.Payload())
+	if err != nil {
+		slog.Warn("invalid sparkplug command", "topic", m.Topic(), "error", err)
+	}
+	for tag, value := range cmd.Writes {
+		if n.commander == nil {
+			slog.Warn("sparkplug write ignored without commands config", "tag", tag)
+			continue
+		}
+		t := time.Now()
+		err := telemetry.WithSpan(context.Background(), "sparkplug.command", func(ctx context.Context) error {
+			return n.commander.Write(ctx, tag, value)
+		}, attribute.String("opc.tag", tag))
+		if err != nil {
+			opc.DefaultMetrics.ObserveRequest("sparkplug", "command", "failed", time.Since(t))
+			slog.Warn("sparkplug write failed", "tag", tag, "error", err)
+			continue
+		}
+		opc.DefaultMetrics.ObserveRequest("sparkplug", "command", "success", time.Since(t))
+		slog.Info("sparkplug write", "tag", tag, "duration", time.Since(t))
+	}
+	if cmd.Rebirth {
+		slog.Info("sparkplug rebirth requested", "topic", m.Topic())
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.birth(context.Background(), c)
+	}
+}
+
+// birth publishes the births with the current values; n.mu must be held
+func (n *edgeNode) birth(ctx context.Context, c mqtt.Client) {
+	messages, err := n.Birth(n.conn.Read(), time.Now())
+	if err != nil {
+		slog.Error("cannot encode sparkplug birth", "error", err)
+		return
+	}
+	for _, m := range messages {
+		publish(ctx, c, m)
+	}
+}
+
+// death publishes the death certificate before a clean disconnect
+func (n *edgeNode) death(ctx context.Context, c mqtt.Client) {
+	m, err := n.Death()
+	if err != nil {
+		slog.Error("cannot encode sparkplug death", "error", err)
+		return
+	}
+	publish(ctx, c, m)
+}
+
+// data publishes the items and the births before if the data types changed and
+// returns the publish error
+func (n *edgeNode) data(ctx context.Context, c mqtt.Client, items map[string]opc.Item) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	m, err := n.Data(items, time.Now())
+	if errors.Is(err, sparkplug.ErrRebirth) {
+		n.birth(ctx, c)
+		m, err = n.Data(items, time.Now())
+	}
+	if err != nil {
+		// not a delivery failure, the data would fail again
+		slog.Error("cannot encode sparkplug data", "error", err)
+		return nil
+	}
+	return publish(ctx, c, m)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/bridge"
+)
+
+// statusMetrics reports the state of the OPC connection to the status topics
+type statusMetrics struct {
+	opc.NopMetrics
+	onChange func(connected bool)
+}
+
+func (m statusMetrics) SetConnected(connected bool) {
+	m.onChange(connected)
+}
+
+// watchConnection publishes the health when the state of the OPC connection
+// changes. It replaces opc.DefaultMetrics and must be called before connecting.
+func watchConnection(status *bridge.Status, client func() mqtt.Client) {
+	opc.DefaultMetrics = opc.MultiMetrics(opc.DefaultMetrics, statusMetrics{onChange: func(connected bool) {
+		if !status.SetConnected(connected) {
+			return
+		}
+		slog.Info("opc connection state changed", "connected", connected)
+		if c := client(); c != nil && c.IsConnectionOpen() {
+			publish(context.Background(), c, status.Health(time.Now()))
+		}
+	}})
+}
+
+// publishOnline publishes the online message and the health after a connect
+func publishOnline(c mqtt.Client, status *bridge.Status) {
+	publish(context.Background(), c, status.Online())
+	publish(context.Background(), c, status.Health(time.Now()))
+}
+
+// reportHealth publishes the health periodically until ctx is done, also while
+// reads are blocked by reconnects to the OPC server
+func reportHealth(ctx context.Context, c mqtt.Client, status *bridge.Status) {
+	ticker := time.NewTicker(status.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if c.IsConnectionOpen() {
+				publish(ctx, c, status.Health(now))
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package opc
 
 import (
+	"context"
 	"time"
 )
 
@@ -14,14 +15,14 @@ const (
 
 	//OPCQuality defines the quality of the OPC items:
 	//Bad
-	OPCQualityBad int16 = 0
+	OPCQualityBad Quality = 0
 	//Good
-	OPCQualityGood          int16 = 192
-	OPCQualityGoodButForced int16 = 216
+	OPCQualityGood          Quality = 192
+	OPCQualityGoodButForced Quality = 216
 	//Maks
-	OPCQualityMask int16 = 192
+	OPCQualityMask Quality = 192
 	//Uncertain
-	OPCQualityUncertain int16 = 64
+	OPCQualityUncertain Quality = 64
 
 	//OPCServerState defines the state of the server:
 	//Disconnected
@@ -46,20 +47,33 @@ type Connection interface {
 	ReadItem(string) Item
 	Tags() []string
 	Write(string, interface{}) error
+	Properties(string) (TagInfo, error)
 	Close()
 }
 
+//ContextConnection is a Connection that passes a context to its operations,
+//e.g. to trace them as children of the span of a request.
+type ContextConnection interface {
+	Connection
+	WithContext(ctx context.Context) Connection
+}
+
+//WithContext returns conn bound to ctx if it is a ContextConnection and conn otherwise.
+func WithContext(ctx context.Context, conn Connection) Connection {
+	if c, ok := conn.(ContextConnection); ok {
+		return c.WithContext(ctx)
+	}
+	return conn
+}
+
 //Item stores the result of an OPC item from the OPC server.
 type Item struct {
 	Value     interface{}
-	Quality   int16
+	Quality   Quality
 	Timestamp time.Time
 }
 
 //Good checks the quality of the Item
 func (i *Item) Good() bool {
-	if i.Quality == OPCQualityGood || i.Quality == OPCQualityGoodButForced {
-		return true
-	}
-	return false
+	return i.Quality.Good()
 }
@@ -3,8 +3,10 @@
 package opc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,9 +34,21 @@ type AutomationObject struct {
 	object  *ole.IDispatch
 }
 
-//CreateBrowser returns the OPCBrowser object from the OPCServer.
+//CreateBrowser returns the complete tree of the OPCServer.
 //It only works if there is a successful connection.
 func (ao *AutomationObject) CreateBrowser() (*Tree, error) {
+	browser, err := ao.NewBrowser()
+	if err != nil {
+		return nil, err
+	}
+	defer browser.Close()
+	return Browse(context.Background(), browser, BrowseOptions{})
+}
+
+//NewBrowser returns a Browser on the OPCBrowser object of the OPCServer.
+//It only works if there is a successful connection. Close releases the
+//OPCBrowser object.
+func (ao *AutomationObject) NewBrowser() (*OPCBrowser, error) {
 	// check if server is running, if not return error
 	if !ao.IsConnected() {
 		return nil, errors.New("Cannot create browser because we are not connected.")
@@ -45,68 +59,164 @@ func (ao *AutomationObject) CreateBrowser() (*Tree, error) {
 	if err != nil {
 		return nil, errors.New("Failed to create OPCBrowser")
 	}
+	return &OPCBrowser{browser: browser.ToIDispatch()}, nil
+}
 
-	// move to root
-	oleutil.MustCallMethod(browser.ToIDispatch(), "MoveToRoot")
-
-	// create tree
-	root := Tree{"root", nil, []*Tree{}, []Leaf{}}
-	buildTree(browser.ToIDispatch(), &root)
-
-	return &root, nil
+//OPCBrowser implements the Browser interface with the OPCBrowser object
+//of the automation wrapper.
+type OPCBrowser struct {
+	browser *ole.IDispatch
+	object  *AutomationObject // closed with the browser if opened by OpenBrowser
 }
 
-//buildTree runs through the OPCBrowser and creates a tree with the OPC tags
-func buildTree(browser *ole.IDispatch, branch *Tree) {
-	var count int32
+//Close releases the OPCBrowser object and the connection of OpenBrowser.
+func (b *OPCBrowser) Close() {
+	if b.browser != nil {
+		b.browser.Release()
+		b.browser = nil
+	}
+	if b.object != nil {
+		b.object.Close()
+		b.object = nil
+	}
+}
 
-	logger.Println("Entering branch:", branch.Name)
+//Children moves to the branch at path and returns its sub-branches and leaves.
+func (b *OPCBrowser) Children(ctx context.Context, path string) ([]string, []Leaf, error) {
+	if _, err := oleutil.CallMethod(b.browser, "MoveToRoot"); err != nil {
+		return nil, nil, fmt.Errorf("opc: cannot move to root: %v", err)
+	}
+	for _, name := range strings.Split(path, PathSeparator) {
+		if name == "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if _, err := oleutil.CallMethod(b.browser, "MoveDown", name); err != nil {
+			return nil, nil, fmt.Errorf("opc: cannot browse %s: %v", path, err)
+		}
+	}
 
 	// loop through leafs
-	oleutil.MustCallMethod(browser, "ShowLeafs").ToIDispatch()
-	count = oleutil.MustGetProperty(browser, "Count").Value().(int32)
-
-	logger.Println("\tLeafs count:", count)
-
-	for i := 1; i <= int(count); i++ {
+	names, err := b.items("ShowLeafs")
+	if err != nil {
+		return nil, nil, err
+	}
+	leaves := make([]Leaf, 0, len(names))
+	for _, name := range names {
+		tag, err := oleutil.CallMethod(b.browser, "GetItemID", name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opc: cannot get item ID of %s: %v", name, err)
+		}
+		id, ok := tag.Value().(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("opc: invalid item ID of %s", name)
+		}
+		leaves = append(leaves, Leaf{Name: name, Tag: id})
+	}
 
-		item := oleutil.MustCallMethod(browser, "Item", i).Value()
-		tag := oleutil.MustCallMethod(browser, "GetItemID", item).Value()
+	// loop through branches
+	branches, err := b.items("ShowBranches")
+	if err != nil {
+		return nil, nil, err
+	}
 
-		l := Leaf{Name: item.(string), Tag: tag.(string)}
+	logger.Debug("browsed branch", "leaves", len(leaves), "branches", len(branches))
 
-		logger.Println("\t", i, l)
+	return branches, leaves, nil
+}
 
-		branch.Leaves = append(branch.Leaves, l)
+//items calls show ("ShowLeafs" or "ShowBranches") and returns the names of the items
+func (b *OPCBrowser) items(show string) ([]string, error) {
+	if _, err := oleutil.CallMethod(b.browser, show); err != nil {
+		return nil, fmt.Errorf("opc: %s failed: %v", show, err)
 	}
+	count, err := oleutil.GetProperty(b.browser, "Count")
+	if err != nil {
+		return nil, fmt.Errorf("opc: cannot get count: %v", err)
+	}
+	n, err := toInt64(count.Value())
+	if err != nil {
+		return nil, fmt.Errorf("opc: invalid count: %v", err)
+	}
+	names := make([]string, 0, n)
+	for i := 1; i <= int(n); i++ {
+		item, err := oleutil.CallMethod(b.browser, "Item", i)
+		if err != nil {
+			return nil, fmt.Errorf("opc: cannot get item %d: %v", i, err)
+		}
+		name, ok := item.Value().(string)
+		if !ok {
+			return nil, fmt.Errorf("opc: invalid name of item %d", i)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
 
-	// loop through branches
-	oleutil.MustCallMethod(browser, "ShowBranches").ToIDispatch()
-	count = oleutil.MustGetProperty(browser, "Count").Value().(int32)
-
-	logger.Println("\tBranches count:", count)
-
-	for i := 1; i <= int(count); i++ {
+//Properties reads the properties of an item from the OPC server.
+//The item does not have to be added to a connection.
+func (ao *AutomationObject) Properties(tag string) (TagInfo, error) {
+	if !ao.IsConnected() {
+		return TagInfo{}, errors.New("Cannot read properties because we are not connected.")
+	}
 
-		nextName := oleutil.MustCallMethod(browser, "Item", i).Value()
+	count := ole.NewVariant(ole.VT_I4, 0)
+	ids := ole.NewVariant(ole.VT_EMPTY, 0)
+	descriptions := ole.NewVariant(ole.VT_EMPTY, 0)
+	dataTypes := ole.NewVariant(ole.VT_EMPTY, 0)
+	defer ids.Clear()
+	defer descriptions.Clear()
+	defer dataTypes.Clear()
 
-		logger.Println("\t", i, "next branch:", nextName)
+	_, err := oleutil.CallMethod(ao.object, "QueryAvailableProperties", tag, &count, &ids, &descriptions, &dataTypes)
+	if err != nil {
+		return TagInfo{}, errors.New(tag + ":" + err.Error())
+	}
+	if ids.ToArray() == nil {
+		return TagInfo{Tag: tag}, nil
+	}
 
-		// move down
-		oleutil.MustCallMethod(browser, "MoveDown", nextName)
+	values := ole.NewVariant(ole.VT_EMPTY, 0)
+	propErrors := ole.NewVariant(ole.VT_EMPTY, 0)
+	defer values.Clear()
+	defer propErrors.Clear()
 
-		// recursively populate tree
-		nextBranch := Tree{nextName.(string), branch, []*Tree{}, []Leaf{}}
-		branch.Branches = append(branch.Branches, &nextBranch)
-		buildTree(browser, &nextBranch)
+	_, err = oleutil.CallMethod(ao.object, "GetItemProperties", tag, count.Value(), &ids, &values, &propErrors)
+	if err != nil || values.ToArray() == nil || propErrors.ToArray() == nil {
+		return TagInfo{}, fmt.Errorf("%s: cannot read item properties: %v", tag, err)
+	}
 
-		// move up and set branches again
-		oleutil.MustCallMethod(browser, "MoveUp")
-		oleutil.MustCallMethod(browser, "ShowBranches").ToIDispatch()
+	//the arrays are 1-based
+	idList, err := arrayValues(ids.ToArray())
+	if err != nil {
+		return TagInfo{}, fmt.Errorf("%s: cannot read property IDs: %v", tag, err)
+	}
+	valueList, err := arrayValues(values.ToArray())
+	if err != nil {
+		return TagInfo{}, fmt.Errorf("%s: cannot read property values: %v", tag, err)
+	}
+	errorList, err := arrayValues(propErrors.ToArray())
+	if err != nil {
+		return TagInfo{}, fmt.Errorf("%s: cannot read property errors: %v", tag, err)
 	}
 
-	logger.Println("Exiting branch:", branch.Name)
+	props := make(map[int32]interface{})
+	for i := range idList {
+		if i >= len(valueList) || i >= len(errorList) {
+			break
+		}
+		if code, _ := toInt64(errorList[i]); code != 0 {
+			logger.Warn("cannot read property", "tag", tag, "property", idList[i], "code", code)
+			continue
+		}
+		if id, err := toInt64(idList[i]); err == nil {
+			props[int32(id)] = valueList[i]
+		}
+	}
 
+	return NewTagInfo(tag, props), nil
 }
 
 //Connect establishes a connection to the OPC Server on node.
@@ -117,10 +227,10 @@ func (ao *AutomationObject) Connect(server string, node string) (*AutomationItem
 	ao.disconnect()
 
 	// try to connect to opc server and check for error
-	logger.Printf("Connecting to %s on node %s\n", server, node)
+	logger.Info("connecting", "server", server, "node", node)
 	_, err := oleutil.CallMethod(ao.object, "Connect", server, node)
 	if err != nil {
-		logger.Println("Connection failed.")
+		logger.Error("connection failed", "server", server, "node", node, "error", err)
 		return nil, errors.New("Connection failed")
 	}
 
@@ -132,19 +242,19 @@ func (ao *AutomationObject) Connect(server string, node string) (*AutomationItem
 	}
 	opcGrp, err := oleutil.CallMethod(opcGroups.ToIDispatch(), "Add")
 	if err != nil {
-		// logger.Println(err)
+		//logger.Println(err)
 		return nil, errors.New("cannot add new OPC Group")
 	}
 	addItemObject, err := oleutil.GetProperty(opcGrp.ToIDispatch(), "OPCItems")
 	if err != nil {
-		// logger.Println(err)
+		//logger.Println(err)
 		return nil, errors.New("cannot get OPC Items")
 	}
 
 	opcGroups.ToIDispatch().Release()
 	opcGrp.ToIDispatch().Release()
 
-	logger.Println("Connected.")
+	logger.Info("connected", "server", server, "node", node)
 
 	return NewAutomationItems(addItemObject.ToIDispatch()), nil
 }
@@ -169,7 +279,7 @@ func (ao *AutomationObject) IsConnected() bool {
 	}
 	stateVt, err := oleutil.GetProperty(ao.object, "ServerState")
 	if err != nil {
-		logger.Println("GetProperty call for ServerState failed", err)
+		logger.Warn("GetProperty call for ServerState failed", "error", err)
 		return false
 	}
 	if stateVt.Value().(int32) != OPCRunning {
@@ -182,7 +292,7 @@ func (ao *AutomationObject) IsConnected() bool {
 func (ao *AutomationObject) GetOPCServers(node string) []string {
 	progids, err := oleutil.CallMethod(ao.object, "GetOPCServers", node)
 	if err != nil {
-		logger.Println("GetOPCServers call failed.")
+		logger.Error("GetOPCServers call failed", "node", node, "error", err)
 		return []string{}
 	}
 
@@ -200,7 +310,7 @@ func (ao *AutomationObject) disconnect() {
 	if ao.IsConnected() {
 		_, err := oleutil.CallMethod(ao.object, "Disconnect")
 		if err != nil {
-			logger.Println("Failed to disconnect.")
+			logger.Warn("failed to disconnect", "error", err)
 		}
 	}
 }
@@ -229,10 +339,10 @@ func NewAutomationObject() *AutomationObject {
 	for _, wrapper := range wrappers {
 		unknown, err = oleutil.CreateObject(wrapper)
 		if err == nil {
-			logger.Println("Loaded OPC Automation object with wrapper", wrapper)
+			logger.Debug("loaded OPC Automation object", "wrapper", wrapper)
 			break
 		}
-		logger.Println("Could not load OPC Automation object with wrapper", wrapper)
+		logger.Debug("could not load OPC Automation object", "wrapper", wrapper, "error", err)
 	}
 	if err != nil {
 		return &AutomationObject{}
@@ -296,12 +406,12 @@ func (ai *AutomationItems) Remove(tag string) {
  * FIX:
  * some opc servers sometimes returns an int32 Quality, that produces panic
  */
-func ensureInt16(q interface{}) int16 {
+func ensureInt16(q interface{}) Quality {
 	if v16, ok := q.(int16); ok {
-		return v16
+		return Quality(v16)
 	}
 	if v32, ok := q.(int32); ok && v32 >= -32768 && v32 < 32768 {
-		return int16(v32)
+		return Quality(v32)
 	}
 	return 0
 }
@@ -312,34 +422,34 @@ func (ai *AutomationItems) readFromOpc(opcitem *ole.IDispatch) (Item, error) {
 	q := ole.NewVariant(ole.VT_INT, 0)
 	ts := ole.NewVariant(ole.VT_DATE, 0)
 
-	//read tag from opc server and monitor duration in seconds
-	t := time.Now()
+	//read tag from opc server; the metrics are recorded by the instrumented connection
 	_, err := oleutil.CallMethod(opcitem, "Read", OPCCache, &v, &q, &ts)
-	opcReadsDuration.Observe(time.Since(t).Seconds())
-
 	if err != nil {
-		opcReadsCounter.WithLabelValues("failed").Inc()
 		return Item{}, err
 	}
-	opcReadsCounter.WithLabelValues("success").Inc()
 
-	return Item{
+	item := Item{
 		Value:     v.Value(),
 		Quality:   ensureInt16(q.Value()), // FIX: ensure the quality value is int16
 		Timestamp: ts.Value().(time.Time),
-	}, nil
+	}
+
+	// arrays are not converted by Value(); a value that cannot be converted is
+	// a bad value of this tag, not a connection failure
+	if v.VT&ole.VT_ARRAY != 0 {
+		if item.Value, err = arrayValues(v.ToArray()); err != nil {
+			logger.Warn("cannot convert array value", "vartype", v.VT, "error", err)
+			item.Value = nil
+			item.Quality = OPCQualityConfigError
+		}
+	}
+	return item, nil
 }
 
 //writeToOPC writes value to opc tag and return an error
 func (ai *AutomationItems) writeToOpc(opcitem *ole.IDispatch, value interface{}) error {
 	_, err := oleutil.CallMethod(opcitem, "Write", value)
-	if err != nil {
-		// TODO: Prometheus Monitoring
-		//opcWritesCounter.WithLabelValues("failed").Inc()
-		return err
-	}
-	//opcWritesCounter.WithLabelValues("failed").Inc()
-	return nil
+	return err
 }
 
 //Close closes the OLE objects in AutomationItems.
@@ -365,9 +475,17 @@ func NewAutomationItems(opcitems *ole.IDispatch) *AutomationItems {
 type opcConnectionImpl struct {
 	*AutomationObject
 	*AutomationItems
-	Server string
-	Nodes  []string
-	mu     sync.Mutex
+	Server  string
+	Nodes   []string
+	mu      sync.Mutex
+	metrics Metrics //records the reconnects and the connection state
+}
+
+//setMetrics records the reconnects and the connection state with m
+func (conn *opcConnectionImpl) setMetrics(m Metrics) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.metrics = m
 }
 
 //ReadItem returns an Item for a specific tag.
@@ -380,10 +498,10 @@ func (conn *opcConnectionImpl) ReadItem(tag string) Item {
 		if err == nil {
 			return item
 		}
-		logger.Printf("Cannot read %s: %s. Trying to fix.", tag, err)
+		logger.Warn("cannot read tag, trying to fix the connection", "tag", tag, "error", err)
 		conn.fix()
 	} else {
-		logger.Printf("Tag %s not found. Add it first before reading it.", tag)
+		logger.Warn("tag not found, add it first before reading it", "tag", tag)
 	}
 	return Item{}
 }
@@ -396,10 +514,20 @@ func (conn *opcConnectionImpl) Write(tag string, value interface{}) error {
 	if ok {
 		return conn.AutomationItems.writeToOpc(opcitem, value)
 	}
-	logger.Printf("Tag %s not found. Add it first before writing to it.", tag)
+	logger.Warn("tag not found, add it first before writing to it", "tag", tag)
 	return errors.New("No Write performed")
 }
 
+//Properties returns the properties of an item from the OPC server.
+func (conn *opcConnectionImpl) Properties(tag string) (TagInfo, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.AutomationObject == nil {
+		return TagInfo{}, ErrNoProperties
+	}
+	return conn.AutomationObject.Properties(tag)
+}
+
 //Read returns a map of the values of all added tags.
 func (conn *opcConnectionImpl) Read() map[string]Item {
 	conn.mu.Lock()
@@ -408,7 +536,7 @@ func (conn *opcConnectionImpl) Read() map[string]Item {
 	for tag, opcitem := range conn.AutomationItems.items {
 		item, err := conn.AutomationItems.readFromOpc(opcitem)
 		if err != nil {
-			logger.Printf("Cannot read %s: %s. Trying to fix.", tag, err)
+			logger.Warn("cannot read tag, trying to fix the connection", "tag", tag, "error", err)
 			conn.fix()
 			break
 		}
@@ -434,17 +562,21 @@ func (conn *opcConnectionImpl) Tags() []string {
 func (conn *opcConnectionImpl) fix() {
 	var err error
 	if !conn.IsConnected() {
+		conn.metrics.SetConnected(false)
 		for {
 			tags := conn.Tags()
 			conn.AutomationItems.Close()
+			t := time.Now()
 			conn.AutomationItems, err = conn.TryConnect(conn.Server, conn.Nodes)
+			conn.metrics.ObserveReconnect(time.Since(t), err)
 			if err != nil {
-				logger.Println(err)
+				logger.Error("reconnect failed", "server", conn.Server, "nodes", conn.Nodes, "duration", time.Since(t), "error", err)
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
+			conn.metrics.SetConnected(true)
 			if conn.Add(tags...) == nil {
-				logger.Printf("Added %d tags", len(tags))
+				logger.Info("reconnected", "server", conn.Server, "tags", len(tags), "duration", time.Since(t))
 			}
 			break
 		}
@@ -461,6 +593,7 @@ func (conn *opcConnectionImpl) Close() {
 	if conn.AutomationItems != nil {
 		conn.AutomationItems.Close()
 	}
+	conn.metrics.SetConnected(false)
 }
 
 //NewConnection establishes a connection to the OpcServer object.
@@ -479,9 +612,11 @@ func NewConnection(server string, nodes []string, tags []string) (Connection, er
 		AutomationItems:  items,
 		Server:           server,
 		Nodes:            nodes,
+		metrics:          DefaultMetrics,
 	}
+	conn.metrics.SetConnected(true)
 
-	return &conn, nil
+	return InstrumentConnection(&conn, conn.metrics), nil
 }
 
 //CreateBrowser creates an opc browser representation
@@ -494,3 +629,32 @@ func CreateBrowser(server string, nodes []string) (*Tree, error) {
 	}
 	return object.CreateBrowser()
 }
+
+//OpenBrowser connects to the OPC server and returns a browser that keeps the
+//connection open, e.g. to load branches with ExpandBranch, until it is closed.
+func OpenBrowser(server string, nodes []string) (*OPCBrowser, error) {
+	object := NewAutomationObject()
+	if _, err := object.TryConnect(server, nodes); err != nil {
+		object.Close()
+		return nil, err
+	}
+	browser, err := object.NewBrowser()
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	browser.object = object
+	return browser, nil
+}
+
+//BrowseServer connects to the OPC server and loads the tree with the options.
+//The partial tree is returned if browsing is cancelled or limited. The
+//connection is closed afterwards; use OpenBrowser to expand branches later.
+func BrowseServer(ctx context.Context, server string, nodes []string, opts BrowseOptions) (*Tree, error) {
+	browser, err := OpenBrowser(server, nodes)
+	if err != nil {
+		return nil, err
+	}
+	defer browser.Close()
+	return Browse(ctx, browser, opts)
+}
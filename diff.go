@@ -0,0 +1,244 @@
+package opc
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//TreeDiff lists the differences between two address-space snapshots.
+//Leaves are matched by item ID, branches by path.
+type TreeDiff struct {
+	AddedLeaves     []LeafChange
+	RemovedLeaves   []LeafChange
+	MovedLeaves     []LeafChange
+	ChangedLeaves   []LeafChange
+	AddedBranches   []BranchChange
+	RemovedBranches []BranchChange
+	MovedBranches   []BranchChange
+}
+
+//LeafChange describes a leaf that was added, removed or moved.
+//For leaves whose item ID changed at the same path, OldTag is set.
+type LeafChange struct {
+	Tag     string
+	OldTag  string `json:",omitempty"`
+	OldPath string `json:",omitempty"`
+	NewPath string `json:",omitempty"`
+}
+
+//BranchChange describes a branch that was added, removed or moved.
+type BranchChange struct {
+	OldPath string `json:",omitempty"`
+	NewPath string `json:",omitempty"`
+}
+
+//DiffTrees compares the tree from with the tree to. A leaf is moved if its item ID
+//is found at a different path and changed if the same path has a different item ID.
+//A branch is moved if a removed and an added branch contain the same item IDs.
+func DiffTrees(from, to *Tree) *TreeDiff {
+	d := &TreeDiff{}
+
+	oldLeaves, newLeaves := leafPaths(from), leafPaths(to)
+	oldByPath, newByPath := map[string]string{}, map[string]string{}
+	for tag, paths := range oldLeaves {
+		for _, p := range paths {
+			oldByPath[p] = tag
+		}
+	}
+	for tag, paths := range newLeaves {
+		for _, p := range paths {
+			newByPath[p] = tag
+		}
+	}
+
+	var removed, added []LeafChange
+	for tag, oldPaths := range oldLeaves {
+		newPaths := newLeaves[tag]
+		gone, came := difference(oldPaths, newPaths), difference(newPaths, oldPaths)
+		for i, p := range gone {
+			if i < len(came) {
+				d.MovedLeaves = append(d.MovedLeaves, LeafChange{Tag: tag, OldPath: p, NewPath: came[i]})
+			} else {
+				removed = append(removed, LeafChange{Tag: tag, OldPath: p})
+			}
+		}
+		for i := len(gone); i < len(came); i++ {
+			added = append(added, LeafChange{Tag: tag, NewPath: came[i]})
+		}
+	}
+	for tag, newPaths := range newLeaves {
+		if _, ok := oldLeaves[tag]; !ok {
+			for _, p := range newPaths {
+				added = append(added, LeafChange{Tag: tag, NewPath: p})
+			}
+		}
+	}
+
+	// a different item ID at the same path is a change, not an addition and removal
+	changed := map[string]bool{}
+	for _, a := range added {
+		if oldTag, ok := oldByPath[a.NewPath]; ok && oldTag != a.Tag && newByPath[a.NewPath] == a.Tag {
+			if _, stillThere := newLeaves[oldTag]; stillThere {
+				continue
+			}
+			d.ChangedLeaves = append(d.ChangedLeaves, LeafChange{Tag: a.Tag, OldTag: oldTag, OldPath: a.NewPath, NewPath: a.NewPath})
+			changed[a.NewPath] = true
+		}
+	}
+	for _, r := range removed {
+		if !changed[r.OldPath] {
+			d.RemovedLeaves = append(d.RemovedLeaves, r)
+		}
+	}
+	for _, a := range added {
+		if !changed[a.NewPath] {
+			d.AddedLeaves = append(d.AddedLeaves, a)
+		}
+	}
+
+	// branches
+	oldBranches, newBranches := branchTags(from), branchTags(to)
+	var removedBranches, addedBranches []string
+	for p := range oldBranches {
+		if _, ok := newBranches[p]; !ok {
+			removedBranches = append(removedBranches, p)
+		}
+	}
+	for p := range newBranches {
+		if _, ok := oldBranches[p]; !ok {
+			addedBranches = append(addedBranches, p)
+		}
+	}
+	sort.Strings(removedBranches)
+	sort.Strings(addedBranches)
+
+	moved := map[string]bool{}
+	for _, o := range removedBranches {
+		tags := oldBranches[o]
+		if tags == "" {
+			d.RemovedBranches = append(d.RemovedBranches, BranchChange{OldPath: o})
+			continue
+		}
+		found := false
+		for _, n := range addedBranches {
+			if !moved[n] && newBranches[n] == tags {
+				d.MovedBranches = append(d.MovedBranches, BranchChange{OldPath: o, NewPath: n})
+				moved[n], found = true, true
+				break
+			}
+		}
+		if !found {
+			d.RemovedBranches = append(d.RemovedBranches, BranchChange{OldPath: o})
+		}
+	}
+	for _, n := range addedBranches {
+		if !moved[n] {
+			d.AddedBranches = append(d.AddedBranches, BranchChange{NewPath: n})
+		}
+	}
+
+	for _, changes := range [][]LeafChange{d.AddedLeaves, d.RemovedLeaves, d.MovedLeaves, d.ChangedLeaves} {
+		sortLeafChanges(changes)
+	}
+	return d
+}
+
+//Empty returns true if the trees are equal.
+func (d *TreeDiff) Empty() bool {
+	return !d.Breaking() && len(d.AddedLeaves) == 0 && len(d.AddedBranches) == 0
+}
+
+//Breaking returns true if leaves or branches were removed, moved or changed.
+//Additions are not breaking.
+func (d *TreeDiff) Breaking() bool {
+	return len(d.RemovedLeaves) > 0 || len(d.MovedLeaves) > 0 || len(d.ChangedLeaves) > 0 ||
+		len(d.RemovedBranches) > 0 || len(d.MovedBranches) > 0
+}
+
+//WriteTo writes the differences in a readable format: '+' for added,
+//'-' for removed, '>' for moved and '~' for changed elements.
+func (d *TreeDiff) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, c := range d.RemovedBranches {
+		fmt.Fprintf(&b, "- branch %s\n", c.OldPath)
+	}
+	for _, c := range d.AddedBranches {
+		fmt.Fprintf(&b, "+ branch %s\n", c.NewPath)
+	}
+	for _, c := range d.MovedBranches {
+		fmt.Fprintf(&b, "> branch %s -> %s\n", c.OldPath, c.NewPath)
+	}
+	for _, c := range d.RemovedLeaves {
+		fmt.Fprintf(&b, "- %s (%s)\n", c.Tag, c.OldPath)
+	}
+	for _, c := range d.AddedLeaves {
+		fmt.Fprintf(&b, "+ %s (%s)\n", c.Tag, c.NewPath)
+	}
+	for _, c := range d.MovedLeaves {
+		fmt.Fprintf(&b, "> %s (%s -> %s)\n", c.Tag, c.OldPath, c.NewPath)
+	}
+	for _, c := range d.ChangedLeaves {
+		fmt.Fprintf(&b, "~ %s (%s -> %s)\n", c.NewPath, c.OldTag, c.Tag)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+//leafPaths maps the item IDs to the sorted paths of their leaves
+func leafPaths(tree *Tree) map[string][]string {
+	leaves := make(map[string][]string)
+	tree.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		if leaf != nil {
+			leaves[leaf.Tag] = append(leaves[leaf.Tag], path)
+		}
+		return nil
+	})
+	for _, paths := range leaves {
+		sort.Strings(paths)
+	}
+	return leaves
+}
+
+//branchTags maps the branch paths to a key of the sorted item IDs of their subtrees
+func branchTags(tree *Tree) map[string]string {
+	branches := make(map[string]string)
+	tree.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		if leaf == nil && path != "" {
+			tags := CollectTags(branch)
+			sort.Strings(tags)
+			branches[path] = strings.Join(tags, "\n")
+		}
+		return nil
+	})
+	return branches
+}
+
+//difference returns the elements of a that are not in b
+func difference(a, b []string) []string {
+	var result []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+//sortLeafChanges sorts the changes by tag and path
+func sortLeafChanges(changes []LeafChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Tag != changes[j].Tag {
+			return changes[i].Tag < changes[j].Tag
+		}
+		return changes[i].OldPath+changes[i].NewPath < changes[j].OldPath+changes[j].NewPath
+	})
+}
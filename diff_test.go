@@ -0,0 +1,103 @@
+package opc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiffTreesEqual(t *testing.T) {
+	d := DiffTrees(testingCreateNestedTree(), testingCreateNestedTree())
+	if !d.Empty() || d.Breaking() {
+		t.Fatalf("trees should be equal: %+v", d)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	before := testingCreateNestedTree()
+	after := testingCreateNestedTree()
+
+	// Line1: add leaf, change item ID of Speed
+	setpoints := after.Find("Line1/Pump3/Setpoints")
+	setpoints.Leaves[0].Tag = "Line1.Pump3.Setpoints.SpeedRPM"
+	setpoints.Leaves = append(setpoints.Leaves, Leaf{Name: "Flow", Tag: "Line1.Pump3.Setpoints.Flow"})
+
+	// Line2: move Pressure leaf into after branch Limits
+	pump := after.Find("Line2/Pump3")
+	limits := &Tree{Name: "Limits", Parent: pump, Leaves: []Leaf{pump.Branches[0].Leaves[1]}}
+	pump.Branches[0].Leaves = pump.Branches[0].Leaves[:1]
+	pump.Branches = append(pump.Branches, limits)
+
+	d := DiffTrees(before, after)
+
+	if !reflect.DeepEqual(d.AddedLeaves, []LeafChange{{Tag: "Line1.Pump3.Setpoints.Flow", NewPath: "Line1/Pump3/Setpoints/Flow"}}) {
+		t.Errorf("added leaves: %+v", d.AddedLeaves)
+	}
+	if !reflect.DeepEqual(d.ChangedLeaves, []LeafChange{{
+		Tag:     "Line1.Pump3.Setpoints.SpeedRPM",
+		OldTag:  "Line1.Pump3.Setpoints.Speed",
+		OldPath: "Line1/Pump3/Setpoints/Speed",
+		NewPath: "Line1/Pump3/Setpoints/Speed",
+	}}) {
+		t.Errorf("changed leaves: %+v", d.ChangedLeaves)
+	}
+	if !reflect.DeepEqual(d.MovedLeaves, []LeafChange{{
+		Tag:     "Line2.Pump3.Setpoints.Pressure",
+		OldPath: "Line2/Pump3/Setpoints/Pressure",
+		NewPath: "Line2/Pump3/Limits/Pressure",
+	}}) {
+		t.Errorf("moved leaves: %+v", d.MovedLeaves)
+	}
+	if len(d.RemovedLeaves) != 0 {
+		t.Errorf("removed leaves: %+v", d.RemovedLeaves)
+	}
+	if !reflect.DeepEqual(d.AddedBranches, []BranchChange{{NewPath: "Line2/Pump3/Limits"}}) {
+		t.Errorf("added branches: %+v", d.AddedBranches)
+	}
+	if !d.Breaking() {
+		t.Error("moved and changed leaves are breaking changes")
+	}
+
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	if buf.Len() == 0 {
+		t.Error("no report written")
+	}
+}
+
+func TestDiffTreesMovedBranch(t *testing.T) {
+	before := testingCreateNestedTree()
+	after := testingCreateNestedTree()
+
+	// move Line1/Pump3 to Line2/Pump4
+	pump := after.Branches[0].Branches[0]
+	after.Branches[0].Branches = nil
+	pump.Name, pump.Parent = "Pump4", after.Branches[1]
+	after.Branches[1].Branches = append(after.Branches[1].Branches, pump)
+
+	d := DiffTrees(before, after)
+	want := []BranchChange{
+		{OldPath: "Line1/Pump3", NewPath: "Line2/Pump4"},
+		{OldPath: "Line1/Pump3/Setpoints", NewPath: "Line2/Pump4/Setpoints"},
+	}
+	if !reflect.DeepEqual(d.MovedBranches, want) {
+		t.Errorf("moved branches: %+v", d.MovedBranches)
+	}
+	if len(d.MovedLeaves) != 2 || len(d.AddedBranches) != 0 || len(d.RemovedBranches) != 0 {
+		t.Errorf("unexpected diff: %+v", d)
+	}
+
+	// removing leaves is breaking, adding is not
+	d = DiffTrees(after, before)
+	if !d.Breaking() {
+		t.Error("moving back is breaking")
+	}
+	d = DiffTrees(&Tree{Name: "root"}, before)
+	if d.Breaking() || len(d.AddedLeaves) != 4 || len(d.AddedBranches) != 6 {
+		t.Errorf("additions only: %+v", d)
+	}
+	d = DiffTrees(before, &Tree{Name: "root"})
+	if !d.Breaking() || len(d.RemovedLeaves) != 4 || len(d.RemovedBranches) != 6 {
+		t.Errorf("removals only: %+v", d)
+	}
+}
@@ -0,0 +1,215 @@
+package opc
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+//RegexpPrefix marks a filter pattern as regular expression, e.g. "re:^numeric\.(sin|cos)".
+//Patterns without the prefix are globs.
+const RegexpPrefix = "re:"
+
+//FilterRules lists the patterns to include and exclude branches and leaves
+//of a tree. It can be used directly in the config files of the applications.
+type FilterRules struct {
+	Include []string `yaml:"include" toml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" toml:"exclude" json:"exclude"`
+}
+
+//Filter selects branches and leaves of a tree with glob or regex patterns.
+//A pattern matches a leaf if it matches either the path of the leaf (relative to the
+//filtered tree, e.g. "Line1/Pump3/Temp1") or its item ID (e.g. "Line1.Pump3.Temp1").
+//Globs support '*' and '?' within a path element, '**' across path elements
+//and character classes like '[0-9]'. On item IDs, the DefaultTagSeparators
+//like '.' also separate the elements, so "Line1.*" does not match "Line1.Pump3.Temp1".
+type Filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+//pattern is a filter pattern compiled for paths and for item IDs
+type pattern struct {
+	path *regexp.Regexp
+	tag  *regexp.Regexp
+}
+
+//compilePattern compiles a filter pattern for paths and item IDs
+func compilePattern(p string) (pattern, error) {
+	path, err := CompilePattern(p)
+	if err != nil {
+		return pattern{}, err
+	}
+	tag, err := CompileTagPattern(p)
+	return pattern{path: path, tag: tag}, err
+}
+
+//NewFilter compiles the include and exclude patterns. If there are no include
+//patterns, everything is included that is not excluded.
+func NewFilter(rules FilterRules) (*Filter, error) {
+	f := &Filter{}
+	for _, p := range rules.Include {
+		compiled, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, compiled)
+	}
+	for _, p := range rules.Exclude {
+		compiled, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, compiled)
+	}
+	return f, nil
+}
+
+//Filter returns a pruned copy of the tree with the branches and leaves
+//that match any of the patterns.
+func (t *Tree) Filter(patterns ...string) (*Tree, error) {
+	f, err := NewFilter(FilterRules{Include: patterns})
+	if err != nil {
+		return nil, err
+	}
+	return f.Apply(t), nil
+}
+
+//Apply returns a pruned copy of the tree. A matching branch is included with its
+//complete subtree except for excluded elements. Branches without included
+//leaves or sub-branches are removed. The root of the copy is always returned.
+func (f *Filter) Apply(tree *Tree) *Tree {
+	root, _ := f.apply(tree, "", nil, len(f.include) == 0)
+	return root
+}
+
+//MatchLeaf checks if a leaf with the given path and item ID is selected by the filter.
+func (f *Filter) MatchLeaf(path, tag string) bool {
+	if matchAny(f.exclude, path, tag) {
+		return false
+	}
+	return len(f.include) == 0 || matchAny(f.include, path, tag)
+}
+
+//AddTags appends the tags that are not in the list yet, e.g. the tags selected
+//with Apply and CollectTags to the configured tags.
+func AddTags(tags []string, more ...string) []string {
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		seen[tag] = true
+	}
+	for _, tag := range more {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+//apply is the recursive helper function for Apply. It returns the copy
+//and true if anything was included in the subtree.
+func (f *Filter) apply(tree *Tree, path string, parent *Tree, included bool) (*Tree, bool) {
+	if path != "" {
+		if matchAny(f.exclude, path, "") {
+			return nil, false
+		}
+		included = included || matchAny(f.include, path, "")
+	}
+
+	branch := &Tree{Name: tree.Name, Parent: parent, Branches: []*Tree{}, Leaves: []Leaf{}}
+	for _, l := range tree.Leaves {
+		leafPath := joinPath(path, l.Name)
+		if matchAny(f.exclude, leafPath, l.Tag) {
+			continue
+		}
+		if included || matchAny(f.include, leafPath, l.Tag) {
+			branch.Leaves = append(branch.Leaves, l)
+		}
+	}
+	for _, b := range tree.Branches {
+		if sub, ok := f.apply(b, joinPath(path, b.Name), branch, included); ok {
+			branch.Branches = append(branch.Branches, sub)
+		}
+	}
+
+	ok := included || len(branch.Leaves) > 0 || len(branch.Branches) > 0
+	return branch, ok
+}
+
+//matchAny checks if any of the patterns matches the path or the tag
+func matchAny(patterns []pattern, path, tag string) bool {
+	for _, p := range patterns {
+		if p.path.MatchString(path) || (tag != "" && p.tag.MatchString(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+//CompilePattern compiles a filter pattern for paths, a glob or a regular
+//expression with RegexpPrefix, to a regular expression. Glob elements are
+//separated by PathSeparator.
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, RegexpPrefix) {
+		return regexp.Compile(strings.TrimPrefix(pattern, RegexpPrefix))
+	}
+	return compileGlob(pattern, PathSeparator)
+}
+
+//CompileTagPattern compiles a filter pattern for item IDs like CompilePattern,
+//but glob elements are separated by PathSeparator and DefaultTagSeparators,
+//e.g. "numeric.*" matches "numeric.sin" but not "numeric.sin.float".
+func CompileTagPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, RegexpPrefix) {
+		return regexp.Compile(strings.TrimPrefix(pattern, RegexpPrefix))
+	}
+	return compileGlob(pattern, PathSeparator+DefaultTagSeparators)
+}
+
+//compileGlob translates a glob into an anchored regular expression; '*' and '?'
+//do not match the separators
+func compileGlob(glob, separators string) (*regexp.Regexp, error) {
+	sep := "[" + regexp.QuoteMeta(separators) + "]"
+	notSep := "[^" + regexp.QuoteMeta(separators) + "]"
+	if glob == "" {
+		return nil, errors.New("opc: empty filter pattern")
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// "**/" also matches no path element at all
+				if i+1 < len(glob) && strings.IndexByte(separators, glob[i+1]) >= 0 {
+					i++
+					b.WriteString("(.*" + sep + ")?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString(notSep + "*")
+			}
+		case '?':
+			b.WriteString(notSep)
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, errors.New("opc: missing ']' in filter pattern " + glob)
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
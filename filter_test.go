@@ -0,0 +1,108 @@
+package opc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTreeFilter(t *testing.T) {
+	tree := testingCreateNestedTree()
+
+	var config = []struct {
+		Patterns []string
+		Want     []string
+	}{
+		{
+			Patterns: []string{"**/Speed"},
+			Want:     []string{"Line1.Pump3.Setpoints.Speed", "Line2.Pump3.Setpoints.Speed"},
+		},
+		{
+			Patterns: []string{"Line1"},
+			Want:     []string{"Line1.Pump3.Setpoints.Pressure", "Line1.Pump3.Setpoints.Speed"},
+		},
+		{
+			Patterns: []string{"Line2.*.Setpoints.P*"},
+			Want:     []string{"Line2.Pump3.Setpoints.Pressure"},
+		},
+		{
+			Patterns: []string{`re:^Line[12]\.Pump3\.Setpoints\.Speed$`},
+			Want:     []string{"Line1.Pump3.Setpoints.Speed", "Line2.Pump3.Setpoints.Speed"},
+		},
+		{
+			Patterns: []string{"Line?/Pump[0-9]/*/Pressure"},
+			Want:     []string{"Line1.Pump3.Setpoints.Pressure", "Line2.Pump3.Setpoints.Pressure"},
+		},
+		{
+			Patterns: []string{"**/Temp*"},
+			Want:     []string{},
+		},
+		{
+			// '.' separates the elements of item IDs
+			Patterns: []string{"Line1.*"},
+			Want:     []string{},
+		},
+		{
+			Patterns: []string{"Line1.**"},
+			Want:     []string{"Line1.Pump3.Setpoints.Pressure", "Line1.Pump3.Setpoints.Speed"},
+		},
+		{
+			Patterns: []string{"**.Speed"},
+			Want:     []string{"Line1.Pump3.Setpoints.Speed", "Line2.Pump3.Setpoints.Speed"},
+		},
+	}
+
+	for _, cfg := range config {
+		filtered, err := tree.Filter(cfg.Patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := CollectTags(filtered)
+		sort.Strings(tags)
+		if !reflect.DeepEqual(tags, cfg.Want) {
+			t.Errorf("%v: got %v, want %v", cfg.Patterns, tags, cfg.Want)
+		}
+	}
+
+	if len(CollectTags(tree)) != 4 {
+		t.Fatal("filter should not modify the original tree")
+	}
+}
+
+func TestTreeFilterRules(t *testing.T) {
+	tree := testingCreateNestedTree()
+
+	f, err := NewFilter(FilterRules{
+		Include: []string{"**/Setpoints"},
+		Exclude: []string{"Line2", "**/Pressure"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered := f.Apply(tree)
+
+	if tags := CollectTags(filtered); !reflect.DeepEqual(tags, []string{"Line1.Pump3.Setpoints.Speed"}) {
+		t.Fatalf("wrong tags: %v", tags)
+	}
+	if len(filtered.Branches) != 1 {
+		t.Fatal("excluded and empty branches should be pruned")
+	}
+	branch := filtered.Find("Line1/Pump3/Setpoints")
+	if branch == nil || branch.Path() != "Line1/Pump3/Setpoints" {
+		t.Fatal("parent links of the copy are not correct")
+	}
+
+	if _, err := NewFilter(FilterRules{Include: []string{"re:("}}); err == nil {
+		t.Fatal("invalid regex should return an error")
+	}
+	if _, err := NewFilter(FilterRules{Include: []string{"Line[1"}}); err == nil {
+		t.Fatal("invalid glob should return an error")
+	}
+}
+
+func TestAddTagsOnce(t *testing.T) {
+	tags := AddTags([]string{"a", "b"}, "b", "c", "c")
+	if !reflect.DeepEqual(tags, []string{"a", "b", "c"}) {
+		t.Errorf("wrong tags: %v", tags)
+	}
+}
@@ -0,0 +1,91 @@
+package opc
+
+import (
+	"sort"
+	"strings"
+)
+
+//MergeTrees combines the trees of several servers into one namespace. Each tree
+//is mounted at its prefix, e.g. "ServerA" or "Plant1/ServerA". Trees with the same
+//prefix or overlapping prefixes are merged like Overlay. The item IDs are not changed;
+//the mount path of a leaf tells which server it belongs to. The merged tree
+//describes the namespace only; reading its tags needs a connection per server.
+//api.Namespace serves it browse-only next to the tags of one connection.
+func MergeTrees(trees map[string]*Tree) *Tree {
+	prefixes := make([]string, 0, len(trees))
+	for prefix := range trees {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	root := &Tree{Name: "root", Branches: []*Tree{}, Leaves: []Leaf{}}
+	for _, prefix := range prefixes {
+		if trees[prefix] == nil {
+			continue
+		}
+		mountPoint := mkdirs(root, prefix)
+		overlay(mountPoint, trees[prefix])
+	}
+	return root
+}
+
+//Overlay returns a copy of the tree with the branches and leaves of the overlay
+//added, e.g. virtual folders like "KPIs" with tags computed by the application.
+//Branches with the same name are merged and leaves of the overlay replace
+//leaves with the same name. Neither tree is modified.
+func Overlay(tree, overlayTree *Tree) *Tree {
+	result := copyTree(tree, nil)
+	overlay(result, overlayTree)
+	return result
+}
+
+//overlay merges the content of src into dst
+func overlay(dst, src *Tree) {
+	for _, l := range src.Leaves {
+		replaced := false
+		for i := range dst.Leaves {
+			if dst.Leaves[i].Name == l.Name {
+				dst.Leaves[i] = l
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst.Leaves = append(dst.Leaves, l)
+		}
+	}
+	for _, b := range src.Branches {
+		if existing := dst.child(b.Name); existing != nil {
+			overlay(existing, b)
+		} else {
+			dst.Branches = append(dst.Branches, copyTree(b, dst))
+		}
+	}
+}
+
+//mkdirs returns the branch at path and creates the missing branches
+func mkdirs(tree *Tree, path string) *Tree {
+	branch := tree
+	for _, name := range strings.Split(path, PathSeparator) {
+		if name == "" {
+			continue
+		}
+		next := branch.child(name)
+		if next == nil {
+			next = &Tree{Name: name, Parent: branch, Branches: []*Tree{}, Leaves: []Leaf{}}
+			branch.Branches = append(branch.Branches, next)
+		}
+		branch = next
+	}
+	return branch
+}
+
+//copyTree returns a deep copy of the tree with parent as Parent
+func copyTree(t *Tree, parent *Tree) *Tree {
+	c := &Tree{Name: t.Name, Parent: parent, Branches: []*Tree{}, Leaves: make([]Leaf, len(t.Leaves))}
+	copy(c.Leaves, t.Leaves)
+	for _, b := range t.Branches {
+		c.Branches = append(c.Branches, copyTree(b, c))
+	}
+	return c
+}
@@ -0,0 +1,56 @@
+package opc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeTrees(t *testing.T) {
+	a := testingCreateNewTree()
+	b := testingCreateNestedTree()
+
+	merged := MergeTrees(map[string]*Tree{
+		"ServerB":        b,
+		"Plant1/ServerA": a,
+		"Empty":          nil,
+	})
+
+	if len(merged.Branches) != 2 || merged.Branches[0].Name != "Plant1" || merged.Branches[1].Name != "ServerB" {
+		t.Fatalf("wrong mount points: %v", merged.Branches)
+	}
+	serverA := merged.Find("Plant1/ServerA")
+	if serverA == nil || serverA.Parent.Parent != merged {
+		t.Fatal("prefix path not created")
+	}
+	if !reflect.DeepEqual(CollectTags(serverA), CollectTags(a)) {
+		t.Errorf("tags of server A not preserved: %v", CollectTags(serverA))
+	}
+	if leaf, branch := merged.LeafByTag("numeric.sin"); leaf == nil || branch.Path() != "Plant1/ServerA/numeric" {
+		t.Error("leaf not found at mount path")
+	}
+
+	merged.Find("ServerB").Branches = nil
+	if len(b.Branches) == 0 {
+		t.Error("source tree modified")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	tree := testingCreateNewTree()
+	kpis := TreeFromTags([]string{"KPIs.availability", "KPIs.oee", "numeric.sum"}, ".")
+	kpis.Find("numeric").Leaves = append(kpis.Find("numeric").Leaves, Leaf{Name: "sin", Tag: "numeric.sin.filtered"})
+
+	result := Overlay(tree, kpis)
+
+	if branch := result.Find("KPIs"); branch == nil || len(branch.Leaves) != 2 || branch.Parent != result {
+		t.Fatal("virtual folder not added")
+	}
+	numeric := result.Find("numeric")
+	expected := []string{"numeric.sin.filtered", "numeric.cos", "numeric.tan", "numeric.sum"}
+	if !reflect.DeepEqual(CollectTags(numeric), expected) {
+		t.Errorf("branches not merged: %v", CollectTags(numeric))
+	}
+	if tree.Find("KPIs") != nil || tree.Find("numeric").Leaves[0].Tag != "numeric.sin" {
+		t.Error("original tree modified")
+	}
+}
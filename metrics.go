@@ -0,0 +1,259 @@
+package opc
+
+import (
+	"strconv"
+	"time"
+)
+
+//Metrics records the operational metrics of connections, browsers and the applications.
+//It is called by NewConnection, Browse and the API; other Connection implementations
+//are instrumented with InstrumentConnection.
+type Metrics interface {
+	//ObserveRead records a read of the items; failed lists the tags that could not be read.
+	ObserveRead(items map[string]Item, failed []string, d time.Duration)
+	//ObserveWrite records a write to a tag.
+	ObserveWrite(tag string, d time.Duration, err error)
+	//ObserveReconnect records an attempt to reconnect to the OPC server.
+	ObserveReconnect(d time.Duration, err error)
+	//SetConnected sets the state of the connection to the OPC server.
+	SetConnected(connected bool)
+	//SetActiveItems sets the number of items added to the connection.
+	SetActiveItems(n int)
+	//ObserveBrowse records browsing the address space.
+	ObserveBrowse(d time.Duration, err error)
+	//ObserveRequest records a request of an application, e.g. component "api" and operation
+	//"GET /tags" with the HTTP status or component "mqtt" and operation "publish".
+	ObserveRequest(component, operation, status string, d time.Duration)
+	//SetBacklog sets the number of records and bytes buffered by a bridge, e.g. component
+	//"mqtt" or "influx", while the broker or database is unreachable.
+	SetBacklog(component string, records int, bytes int64)
+}
+
+//DefaultMetrics is used by the package and the applications. It records the metrics with
+//the Prometheus collectors registered by RegisterMetrics. Replace it before connecting.
+var DefaultMetrics Metrics = PrometheusMetrics{}
+
+//NopMetrics discards all metrics.
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveRead(map[string]Item, []string, time.Duration) {}
+func (NopMetrics) ObserveWrite(string, time.Duration, error)            {}
+func (NopMetrics) ObserveReconnect(time.Duration, error)                {}
+func (NopMetrics) SetConnected(bool)                                    {}
+func (NopMetrics) SetActiveItems(int)                                   {}
+func (NopMetrics) ObserveBrowse(time.Duration, error)                   {}
+func (NopMetrics) ObserveRequest(string, string, string, time.Duration) {}
+func (NopMetrics) SetBacklog(string, int, int64)                        {}
+
+//multiMetrics forwards the metrics to several backends
+type multiMetrics []Metrics
+
+//MultiMetrics returns Metrics that records to all m, e.g. Prometheus and OpenTelemetry.
+func MultiMetrics(m ...Metrics) Metrics {
+	return multiMetrics(m)
+}
+
+func (mm multiMetrics) ObserveRead(items map[string]Item, failed []string, d time.Duration) {
+	for _, m := range mm {
+		m.ObserveRead(items, failed, d)
+	}
+}
+
+func (mm multiMetrics) ObserveWrite(tag string, d time.Duration, err error) {
+	for _, m := range mm {
+		m.ObserveWrite(tag, d, err)
+	}
+}
+
+func (mm multiMetrics) ObserveReconnect(d time.Duration, err error) {
+	for _, m := range mm {
+		m.ObserveReconnect(d, err)
+	}
+}
+
+func (mm multiMetrics) SetConnected(connected bool) {
+	for _, m := range mm {
+		m.SetConnected(connected)
+	}
+}
+
+func (mm multiMetrics) SetActiveItems(n int) {
+	for _, m := range mm {
+		m.SetActiveItems(n)
+	}
+}
+
+func (mm multiMetrics) ObserveBrowse(d time.Duration, err error) {
+	for _, m := range mm {
+		m.ObserveBrowse(d, err)
+	}
+}
+
+func (mm multiMetrics) ObserveRequest(component, operation, status string, d time.Duration) {
+	for _, m := range mm {
+		m.ObserveRequest(component, operation, status, d)
+	}
+}
+
+func (mm multiMetrics) SetBacklog(component string, records int, bytes int64) {
+	for _, m := range mm {
+		m.SetBacklog(component, records, bytes)
+	}
+}
+
+//PrometheusMetrics records the metrics with the collectors of the package.
+type PrometheusMetrics struct{}
+
+//ObserveRead counts the reads by status and the bad quality reads by tag.
+func (PrometheusMetrics) ObserveRead(items map[string]Item, failed []string, d time.Duration) {
+	opcReadsDuration.Observe(d.Seconds())
+	if len(items) > 0 {
+		opcReadsCounter.WithLabelValues("success").Add(float64(len(items)))
+	}
+	if len(failed) > 0 {
+		opcReadsCounter.WithLabelValues("failed").Add(float64(len(failed)))
+	}
+	for tag, item := range items {
+		if item.Quality.Bad() {
+			opcBadQualityCounter.WithLabelValues(tag).Inc()
+		}
+	}
+}
+
+//ObserveWrite counts the writes by status.
+func (PrometheusMetrics) ObserveWrite(tag string, d time.Duration, err error) {
+	opcWritesDuration.Observe(d.Seconds())
+	opcWritesCounter.WithLabelValues(status(err)).Inc()
+}
+
+//ObserveReconnect counts the reconnects by status.
+func (PrometheusMetrics) ObserveReconnect(d time.Duration, err error) {
+	opcReconnectsDuration.Observe(d.Seconds())
+	opcReconnectsCounter.WithLabelValues(status(err)).Inc()
+}
+
+//SetConnected sets the connection gauge to 1 or 0.
+func (PrometheusMetrics) SetConnected(connected bool) {
+	if connected {
+		opcConnectedGauge.Set(1)
+	} else {
+		opcConnectedGauge.Set(0)
+	}
+}
+
+//SetActiveItems sets the active items gauge.
+func (PrometheusMetrics) SetActiveItems(n int) {
+	opcActiveItemsGauge.Set(float64(n))
+}
+
+//ObserveBrowse records the browse duration by status.
+func (PrometheusMetrics) ObserveBrowse(d time.Duration, err error) {
+	opcBrowseDuration.WithLabelValues(status(err)).Observe(d.Seconds())
+}
+
+//ObserveRequest counts the requests and their durations.
+func (PrometheusMetrics) ObserveRequest(component, operation, status string, d time.Duration) {
+	opcRequestsCounter.WithLabelValues(component, operation, status).Inc()
+	opcRequestsDuration.WithLabelValues(component, operation).Observe(d.Seconds())
+}
+
+//SetBacklog sets the backlog gauges of the component.
+func (PrometheusMetrics) SetBacklog(component string, records int, bytes int64) {
+	opcBacklogRecordsGauge.WithLabelValues(component).Set(float64(records))
+	opcBacklogBytesGauge.WithLabelValues(component).Set(float64(bytes))
+}
+
+//status returns "success" or "failed"
+func status(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "success"
+}
+
+//HTTPStatus returns the status code as label for ObserveRequest.
+func HTTPStatus(code int) string {
+	return strconv.Itoa(code)
+}
+
+//instrumentedConnection records the metrics of the embedded Connection
+type instrumentedConnection struct {
+	Connection
+	metrics Metrics
+}
+
+//metricsSetter is implemented by connections that record their own events,
+//e.g. the reconnects and the connection state of NewConnection
+type metricsSetter interface {
+	setMetrics(m Metrics)
+}
+
+//InstrumentConnection returns a Connection that records the reads, writes and
+//active items of conn with m. Connections of NewConnection also record their
+//reconnects and connection state with m. DefaultMetrics is used if m is nil.
+func InstrumentConnection(conn Connection, m Metrics) Connection {
+	if m == nil {
+		m = DefaultMetrics
+	}
+	if s, ok := conn.(metricsSetter); ok {
+		s.setMetrics(m)
+	}
+	m.SetActiveItems(len(conn.Tags()))
+	return &instrumentedConnection{Connection: conn, metrics: m}
+}
+
+//setMetrics passes m to the instrumented connection
+func (c *instrumentedConnection) setMetrics(m Metrics) {
+	if s, ok := c.Connection.(metricsSetter); ok {
+		s.setMetrics(m)
+	}
+}
+
+//Add adds the tags and updates the number of active items.
+func (c *instrumentedConnection) Add(tags ...string) error {
+	err := c.Connection.Add(tags...)
+	c.metrics.SetActiveItems(len(c.Connection.Tags()))
+	return err
+}
+
+//Remove removes the tag and updates the number of active items.
+func (c *instrumentedConnection) Remove(tag string) {
+	c.Connection.Remove(tag)
+	c.metrics.SetActiveItems(len(c.Connection.Tags()))
+}
+
+//Read reads all tags; tags without a result are counted as failed.
+func (c *instrumentedConnection) Read() map[string]Item {
+	t := time.Now()
+	items := c.Connection.Read()
+	d := time.Since(t)
+	var failed []string
+	for _, tag := range c.Connection.Tags() {
+		if _, ok := items[tag]; !ok {
+			failed = append(failed, tag)
+		}
+	}
+	c.metrics.ObserveRead(items, failed, d)
+	return items
+}
+
+//ReadItem reads one tag; an empty item is counted as failed.
+func (c *instrumentedConnection) ReadItem(tag string) Item {
+	t := time.Now()
+	item := c.Connection.ReadItem(tag)
+	d := time.Since(t)
+	if item.Value == nil && item.Timestamp.IsZero() {
+		c.metrics.ObserveRead(nil, []string{tag}, d)
+	} else {
+		c.metrics.ObserveRead(map[string]Item{tag: item}, nil, d)
+	}
+	return item
+}
+
+//Write writes the value and records the duration.
+func (c *instrumentedConnection) Write(tag string, value interface{}) error {
+	t := time.Now()
+	err := c.Connection.Write(tag, value)
+	c.metrics.ObserveWrite(tag, time.Since(t), err)
+	return err
+}
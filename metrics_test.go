@@ -0,0 +1,146 @@
+package opc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+//recordingMetrics stores the recorded metrics for the tests
+type recordingMetrics struct {
+	NopMetrics
+	reads, failed, writes, browses int
+	active                         int
+	bad                            []string
+}
+
+func (m *recordingMetrics) ObserveRead(items map[string]Item, failed []string, d time.Duration) {
+	m.reads += len(items)
+	m.failed += len(failed)
+	for tag, item := range items {
+		if item.Quality.Bad() {
+			m.bad = append(m.bad, tag)
+		}
+	}
+}
+
+func (m *recordingMetrics) ObserveWrite(tag string, d time.Duration, err error) { m.writes++ }
+func (m *recordingMetrics) SetActiveItems(n int)                                { m.active = n }
+func (m *recordingMetrics) ObserveBrowse(d time.Duration, err error)            { m.browses++ }
+
+//mockServerTags keeps the added tags and fails to read tags starting with "x"
+type mockServerTags struct {
+	*emptyServer
+	tags []string
+}
+
+func (m *mockServerTags) Add(tags ...string) error { m.tags = append(m.tags, tags...); return nil }
+func (m *mockServerTags) Tags() []string           { return m.tags }
+func (m *mockServerTags) ReadItem(tag string) Item { return m.Read()[tag] }
+
+func (m *mockServerTags) Read() map[string]Item {
+	items := make(map[string]Item)
+	for _, tag := range m.tags {
+		switch tag[0] {
+		case 'x':
+		case 'b':
+			items[tag] = Item{Value: 0.0, Quality: OPCQualityBad, Timestamp: time.Now()}
+		default:
+			items[tag] = Item{Value: 1.0, Quality: OPCQualityGood, Timestamp: time.Now()}
+		}
+	}
+	return items
+}
+
+func TestInstrumentConnection(t *testing.T) {
+	m := &recordingMetrics{}
+	conn := InstrumentConnection(&mockServerTags{tags: []string{"a"}}, m)
+	if m.active != 1 {
+		t.Errorf("expected 1 active item, got %d", m.active)
+	}
+
+	conn.Add("bad", "xfail")
+	if m.active != 3 {
+		t.Errorf("expected 3 active items, got %d", m.active)
+	}
+
+	conn.Read()
+	if m.reads != 2 || m.failed != 1 || len(m.bad) != 1 || m.bad[0] != "bad" {
+		t.Errorf("wrong reads: %+v", m)
+	}
+
+	conn.ReadItem("xfail")
+	conn.ReadItem("a")
+	if m.reads != 3 || m.failed != 2 {
+		t.Errorf("wrong item reads: %+v", m)
+	}
+
+	conn.Write("a", 1.0)
+	if m.writes != 1 {
+		t.Errorf("expected 1 write, got %d", m.writes)
+	}
+}
+
+//selfRecording records its own events like the connections of NewConnection
+type selfRecording struct {
+	mockServerTags
+	metrics Metrics
+}
+
+func (c *selfRecording) setMetrics(m Metrics) {
+	c.metrics = m
+}
+
+func TestInstrumentConnectionEvents(t *testing.T) {
+	conn := &selfRecording{metrics: NopMetrics{}}
+	m := &recordingMetrics{}
+	InstrumentConnection(InstrumentConnection(conn, nil), m)
+	if conn.metrics != m {
+		t.Error("the connection events are not recorded with the metrics of InstrumentConnection")
+	}
+}
+
+func TestBrowseMetrics(t *testing.T) {
+	m := &recordingMetrics{}
+	defer func(old Metrics) { DefaultMetrics = old }(DefaultMetrics)
+	DefaultMetrics = m
+
+	Browse(context.Background(), NewTreeBrowser(testingCreateNewTree()), BrowseOptions{})
+	if m.browses != 1 {
+		t.Errorf("expected 1 browse, got %d", m.browses)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	var m PrometheusMetrics
+
+	before := testutil.ToFloat64(opcWritesCounter.WithLabelValues("failed"))
+	m.ObserveWrite("a", time.Millisecond, errors.New("failed"))
+	if testutil.ToFloat64(opcWritesCounter.WithLabelValues("failed")) != before+1 {
+		t.Error("failed write not counted")
+	}
+
+	m.ObserveRead(map[string]Item{"bad.tag": {Quality: OPCQualityBad}}, nil, time.Millisecond)
+	if testutil.ToFloat64(opcBadQualityCounter.WithLabelValues("bad.tag")) != 1 {
+		t.Error("bad quality read not counted")
+	}
+
+	m.SetConnected(true)
+	m.SetActiveItems(5)
+	if testutil.ToFloat64(opcConnectedGauge) != 1 || testutil.ToFloat64(opcActiveItemsGauge) != 5 {
+		t.Error("gauges not set")
+	}
+
+	m.ObserveRequest("api", "GET /tags", HTTPStatus(200), time.Millisecond)
+	if testutil.ToFloat64(opcRequestsCounter.WithLabelValues("api", "GET /tags", "200")) != 1 {
+		t.Error("request not counted")
+	}
+
+	m.SetBacklog("mqtt", 3, 120)
+	if testutil.ToFloat64(opcBacklogRecordsGauge.WithLabelValues("mqtt")) != 3 || testutil.ToFloat64(opcBacklogBytesGauge.WithLabelValues("mqtt")) != 120 {
+		t.Error("backlog not set")
+	}
+}
@@ -2,7 +2,8 @@ package opc
 
 import (
 	// "log"
-	// "fmt"
+	"errors"
+	"fmt"
 	"math/rand"
 	"sync"
 	"time"
@@ -20,6 +21,9 @@ func (es *emptyServer) Remove(string)                   {}
 func (es *emptyServer) Write(string, interface{}) error { return nil }
 func (es *emptyServer) Close()                          {}
 func (es *emptyServer) Tags() []string                  { return []string{} }
+func (es *emptyServer) Properties(string) (TagInfo, error) {
+	return TagInfo{}, ErrNoProperties
+}
 
 //OpcMockServerStatic implements an OPC Server that returns the index value plus 1 for each tag.
 type OpcMockServerStatic struct {
@@ -32,6 +36,23 @@ func (oms *OpcMockServerStatic) ReadItem(tag string) Item {
 	return items[tag]
 }
 
+func (oms *OpcMockServerStatic) Properties(tag string) (TagInfo, error) {
+	for i, t := range oms.TagList {
+		if t == tag {
+			return TagInfo{
+				Tag:          tag,
+				DataType:     VTFloat64,
+				AccessRights: OPCReadable,
+				EUType:       OPCAnalogEU,
+				EULow:        0,
+				EUHigh:       float64(len(oms.TagList)),
+				Description:  fmt.Sprintf("static value %d", i+1),
+			}, nil
+		}
+	}
+	return TagInfo{}, errors.New("unknown tag " + tag)
+}
+
 func (oms *OpcMockServerStatic) Read() map[string]Item {
 	answer := make(map[string]Item)
 	for i, tag := range oms.TagList {
@@ -1,8 +1,9 @@
 package opc
 
 import (
-	"log"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -20,29 +21,255 @@ var (
 	opcReadsDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "opc_reads_duration_seconds",
-			Help:    "Read duration in seconds from OPC server.",
-			Buckets: prometheus.ExponentialBuckets(0.000001, 10, 6), // start with 500 ns, add 500 ns for 5 buckets.
+			Help:    "Duration in seconds of a read of all tags from OPC server.",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 10, 8), // from 1 us to 10 s
 		},
 	)
+
+	opcBadQualityCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opc_bad_quality_reads_total",
+			Help: "Counts the reads with bad quality per OPC tag.",
+		},
+		[]string{"tag"},
+	)
+
+	opcWritesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opc_writes_total",
+			Help: "Counts the total number of OPC tags written.",
+		},
+		[]string{"status"},
+	)
+
+	opcWritesDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "opc_writes_duration_seconds",
+			Help:    "Write duration in seconds to OPC server.",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 10, 8),
+		},
+	)
+
+	opcReconnectsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opc_reconnects_total",
+			Help: "Counts the attempts to reconnect to the OPC server.",
+		},
+		[]string{"status"},
+	)
+
+	opcReconnectsDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "opc_reconnects_duration_seconds",
+			Help:    "Duration in seconds of the attempts to reconnect to the OPC server.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 10, 6), // from 1 ms to 100 s
+		},
+	)
+
+	opcConnectedGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "opc_connected",
+			Help: "State of the connection to the OPC server (1 = connected).",
+		},
+	)
+
+	opcActiveItemsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "opc_active_items",
+			Help: "Number of OPC items added to the connection.",
+		},
+	)
+
+	opcBrowseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "opc_browse_duration_seconds",
+			Help:    "Duration in seconds of browsing the OPC server.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8), // from 10 ms to 2.7 min
+		},
+		[]string{"status"},
+	)
+
+	opcRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opc_requests_total",
+			Help: "Counts the requests of the API and the bridges.",
+		},
+		[]string{"component", "operation", "status"},
+	)
+
+	opcRequestsDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "opc_requests_duration_seconds",
+			Help:    "Duration in seconds of the requests of the API and the bridges.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"component", "operation"},
+	)
+
+	opcBacklogRecordsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "opc_backlog_records",
+			Help: "Number of records buffered by a bridge while the sink is unreachable.",
+		},
+		[]string{"component"},
+	)
+
+	opcBacklogBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "opc_backlog_bytes",
+			Help: "Bytes buffered by a bridge while the sink is unreachable.",
+		},
+		[]string{"component"},
+	)
 )
 
-func init() {
-	// Metrics have to be registered to be exposed:
-	prometheus.MustRegister(opcReadsCounter)
-	prometheus.MustRegister(opcReadsDuration)
+//collectors lists the metrics of the package
+var collectors = []prometheus.Collector{
+	opcReadsCounter, opcReadsDuration, opcBadQualityCounter,
+	opcWritesCounter, opcWritesDuration,
+	opcReconnectsCounter, opcReconnectsDuration,
+	opcConnectedGauge, opcActiveItemsGauge,
+	opcBrowseDuration, opcRequestsCounter, opcRequestsDuration,
+	opcBacklogRecordsGauge, opcBacklogBytesGauge,
+}
+
+//RegisterMetrics registers the metrics of the package with reg, e.g. the registry
+//of an application with its own metrics. Metrics registered before are ignored.
+//The default registry only has them after StartMonitoring, NewMetricsServer with
+//prometheus.DefaultGatherer or RegisterMetrics(prometheus.DefaultRegisterer).
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-//StartMonitoring exposes /metrics to Prometheus
-func StartMonitoring(port string) {
+//NewMetricsServer returns a server that exposes the metrics of the gatherer at /metrics.
+//Start it with ListenAndServe and stop it with Shutdown. The metrics of the package
+//are registered with the default registry if gatherer is prometheus.DefaultGatherer;
+//register them with RegisterMetrics for other registries.
+func NewMetricsServer(addr string, gatherer prometheus.Gatherer) *http.Server {
+	if gatherer == prometheus.DefaultGatherer {
+		if err := RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+			logger.Error("cannot register metrics", "error", err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+//StartMonitoring exposes /metrics of the default registry to Prometheus and
+//returns the server to shut it down.
+func StartMonitoring(port string) *http.Server {
 	var p string
 	if port == "" {
 		p = ":8080"
 	} else {
 		p = port
 	}
+	server := NewMetricsServer(p, prometheus.DefaultGatherer)
 	go func() {
-		log.Println("Listening on", p)
-		http.Handle("/metrics", promhttp.Handler())
-		log.Fatal(http.ListenAndServe(p, nil))
+		logger.Info("metrics server listening", "addr", p)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("metrics server failed", "addr", p, "error", err)
+		}
 	}()
+	return server
+}
+
+//TagCollectorOptions configure the tag values exported by NewTagCollector.
+type TagCollectorOptions struct {
+	Namespace    string   // prefix of the metric names, defaults to "opc"
+	Tags         []string // tags to export, defaults to all tags of the connection
+	QualityLabel bool     // add the quality as label instead of the separate quality metric
+}
+
+//tagCollector exports the values of OPC tags as Prometheus gauges
+type tagCollector struct {
+	conn    Connection
+	opts    TagCollectorOptions
+	value   *prometheus.Desc
+	quality *prometheus.Desc
+}
+
+//NewTagCollector returns a collector that reads the tags from the connection on every
+//scrape and exports the numeric and boolean values as gauge <namespace>_tag_value{tag="..."}.
+//The quality is exported as <namespace>_tag_quality with the OPC quality code or as
+//label quality="good", "uncertain" or "bad" of the value with QualityLabel.
+func NewTagCollector(conn Connection, opts TagCollectorOptions) prometheus.Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "opc"
+	}
+	c := &tagCollector{conn: conn, opts: opts}
+	labels := []string{"tag"}
+	if opts.QualityLabel {
+		labels = append(labels, "quality")
+	}
+	c.value = prometheus.NewDesc(
+		prometheus.BuildFQName(opts.Namespace, "tag", "value"),
+		"Value of the OPC tag.",
+		labels, nil,
+	)
+	c.quality = prometheus.NewDesc(
+		prometheus.BuildFQName(opts.Namespace, "tag", "quality"),
+		"OPC quality code of the tag.",
+		[]string{"tag"}, nil,
+	)
+	return c
+}
+
+//Describe implements prometheus.Collector.
+func (c *tagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.value
+	if !c.opts.QualityLabel {
+		ch <- c.quality
+	}
+}
+
+//Collect implements prometheus.Collector.
+func (c *tagCollector) Collect(ch chan<- prometheus.Metric) {
+	items := c.conn.Read()
+	tags := c.opts.Tags
+	if tags == nil {
+		tags = c.conn.Tags()
+	}
+	for _, tag := range tags {
+		item, ok := items[tag]
+		if !ok {
+			continue
+		}
+		if !c.opts.QualityLabel {
+			ch <- prometheus.MustNewConstMetric(c.quality, prometheus.GaugeValue, float64(item.Quality), tag)
+		}
+		value, err := item.Float64()
+		if err != nil {
+			continue
+		}
+		if c.opts.QualityLabel {
+			ch <- prometheus.MustNewConstMetric(c.value, prometheus.GaugeValue, value, tag, qualityLabel(item.Quality))
+		} else {
+			ch <- prometheus.MustNewConstMetric(c.value, prometheus.GaugeValue, value, tag)
+		}
+	}
+}
+
+//qualityLabel returns the major quality as "good", "uncertain" or "bad"
+func qualityLabel(q Quality) string {
+	switch {
+	case q.Good():
+		return "good"
+	case q.Uncertain():
+		return "uncertain"
+	}
+	return "bad"
 }
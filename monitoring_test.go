@@ -0,0 +1,78 @@
+package opc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+//mockServerQuality returns items with different types and qualities
+type mockServerQuality struct {
+	*emptyServer
+}
+
+func (m *mockServerQuality) Tags() []string { return []string{"a", "b", "c"} }
+
+func (m *mockServerQuality) ReadItem(tag string) Item { return m.Read()[tag] }
+
+func (m *mockServerQuality) Read() map[string]Item {
+	return map[string]Item{
+		"a": {Value: float32(1.5), Quality: OPCQualityGood, Timestamp: time.Now()},
+		"b": {Value: true, Quality: OPCQualityUncertain, Timestamp: time.Now()},
+		"c": {Value: "text", Quality: OPCQualityBad, Timestamp: time.Now()},
+	}
+}
+
+func TestTagCollector(t *testing.T) {
+	collector := NewTagCollector(&mockServerQuality{}, TagCollectorOptions{})
+	expected := `
+# HELP opc_tag_quality OPC quality code of the tag.
+# TYPE opc_tag_quality gauge
+opc_tag_quality{tag="a"} 192
+opc_tag_quality{tag="b"} 64
+opc_tag_quality{tag="c"} 0
+# HELP opc_tag_value Value of the OPC tag.
+# TYPE opc_tag_value gauge
+opc_tag_value{tag="a"} 1.5
+opc_tag_value{tag="b"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTagCollectorQualityLabel(t *testing.T) {
+	collector := NewTagCollector(&mockServerQuality{}, TagCollectorOptions{
+		Namespace:    "plant",
+		Tags:         []string{"b", "unknown"},
+		QualityLabel: true,
+	})
+	expected := `
+# HELP plant_tag_value Value of the OPC tag.
+# TYPE plant_tag_value gauge
+plant_tag_value{quality="uncertain",tag="b"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	if prometheus.DefaultRegisterer.Unregister(opcReadsCounter) {
+		t.Error("metrics registered with the default registry on import")
+	}
+	reg := prometheus.NewRegistry()
+	if err := RegisterMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMetrics(reg); err != nil {
+		t.Errorf("registering twice should be ignored: %v", err)
+	}
+	opcReadsCounter.WithLabelValues("success").Inc()
+	if n, err := testutil.GatherAndCount(reg, "opc_reads_total"); err != nil || n == 0 {
+		t.Errorf("expected opc_reads_total in registry: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,202 @@
+package opc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	//OPCProperty defines the IDs of the OPC item properties:
+	OPCPropDataType     int32 = 1
+	OPCPropValue        int32 = 2
+	OPCPropQuality      int32 = 3
+	OPCPropTimestamp    int32 = 4
+	OPCPropAccessRights int32 = 5
+	OPCPropScanRate     int32 = 6
+	OPCPropEUType       int32 = 7
+	OPCPropEUInfo       int32 = 8
+	OPCPropEUUnits      int32 = 100
+	OPCPropDescription  int32 = 101
+	OPCPropHighEU       int32 = 102
+	OPCPropLowEU        int32 = 103
+)
+
+//ErrNoProperties is returned if a connection cannot provide item properties.
+var ErrNoProperties = errors.New("opc: item properties not available")
+
+//PropertyReader reads the properties of OPC items.
+type PropertyReader interface {
+	Properties(string) (TagInfo, error)
+}
+
+//TagInfo stores the properties of an OPC item.
+type TagInfo struct {
+	Tag          string
+	DataType     VarType
+	AccessRights AccessRights
+	ScanRate     float32 // ms
+	EUType       EUType
+	EULow        float64
+	EUHigh       float64
+	EUUnits      string
+	Description  string
+}
+
+//VarType is the canonical VARIANT type of an OPC item.
+type VarType int16
+
+//VarType constants for the types returned by the automation wrapper
+const (
+	VTEmpty   VarType = 0
+	VTInt16   VarType = 2
+	VTInt32   VarType = 3
+	VTFloat32 VarType = 4
+	VTFloat64 VarType = 5
+	VTDate    VarType = 7
+	VTString  VarType = 8
+	VTBool    VarType = 11
+	VTVariant VarType = 12
+	VTInt8    VarType = 16
+	VTUint8   VarType = 17
+	VTUint16  VarType = 18
+	VTUint32  VarType = 19
+	VTInt64   VarType = 20
+	VTUint64  VarType = 21
+	VTArray   VarType = 0x2000
+)
+
+var varTypeNames = map[VarType]string{
+	VTEmpty:   "empty",
+	VTInt16:   "int16",
+	VTInt32:   "int32",
+	VTFloat32: "float32",
+	VTFloat64: "float64",
+	VTDate:    "time",
+	VTString:  "string",
+	VTBool:    "bool",
+	VTVariant: "variant",
+	VTInt8:    "int8",
+	VTUint8:   "uint8",
+	VTUint16:  "uint16",
+	VTUint32:  "uint32",
+	VTInt64:   "int64",
+	VTUint64:  "uint64",
+}
+
+//String returns the name of the type as used by the wire schema, e.g. "float32" or "array of int16".
+func (vt VarType) String() string {
+	name, ok := varTypeNames[vt&^VTArray]
+	if !ok {
+		name = fmt.Sprintf("VT(%d)", int16(vt&^VTArray))
+	}
+	if vt&VTArray != 0 {
+		return "array of " + name
+	}
+	return name
+}
+
+//AccessRights of an OPC item
+type AccessRights int32
+
+//AccessRights constants
+const (
+	OPCReadable AccessRights = 1
+	OPCWritable AccessRights = 2
+)
+
+//Readable returns true if the item can be read.
+func (a AccessRights) Readable() bool { return a&OPCReadable != 0 }
+
+//Writable returns true if the item can be written.
+func (a AccessRights) Writable() bool { return a&OPCWritable != 0 }
+
+//String returns "read", "write", "read/write" or "none".
+func (a AccessRights) String() string {
+	var rights []string
+	if a.Readable() {
+		rights = append(rights, "read")
+	}
+	if a.Writable() {
+		rights = append(rights, "write")
+	}
+	if len(rights) == 0 {
+		return "none"
+	}
+	return strings.Join(rights, "/")
+}
+
+//EUType describes the engineering units information of an OPC item.
+type EUType int32
+
+//EUType constants
+const (
+	OPCNoEU         EUType = 0
+	OPCAnalogEU     EUType = 1
+	OPCEnumeratedEU EUType = 2
+)
+
+//String returns "none", "analog" or "enumerated".
+func (e EUType) String() string {
+	switch e {
+	case OPCNoEU:
+		return "none"
+	case OPCAnalogEU:
+		return "analog"
+	case OPCEnumeratedEU:
+		return "enumerated"
+	}
+	return fmt.Sprintf("EUType(%d)", int32(e))
+}
+
+//NewTagInfo creates the TagInfo from a map of property IDs to property values
+//as returned by the OPC server.
+func NewTagInfo(tag string, props map[int32]interface{}) TagInfo {
+	info := TagInfo{Tag: tag}
+	if v, err := toInt64(props[OPCPropDataType]); err == nil {
+		info.DataType = VarType(v)
+	}
+	if v, err := toInt64(props[OPCPropAccessRights]); err == nil {
+		info.AccessRights = AccessRights(v)
+	}
+	if v, err := toFloat64(props[OPCPropScanRate]); err == nil {
+		info.ScanRate = float32(v)
+	}
+	if v, err := toInt64(props[OPCPropEUType]); err == nil {
+		info.EUType = EUType(v)
+	}
+	if info.EUType == OPCAnalogEU {
+		if r, err := toFloat64Slice(props[OPCPropEUInfo]); err == nil && len(r) == 2 {
+			info.EULow, info.EUHigh = r[0], r[1]
+		}
+	}
+	if v, err := toFloat64(props[OPCPropLowEU]); err == nil {
+		info.EULow = v
+	}
+	if v, err := toFloat64(props[OPCPropHighEU]); err == nil {
+		info.EUHigh = v
+	}
+	if v, ok := props[OPCPropEUUnits].(string); ok {
+		info.EUUnits = v
+	}
+	if v, ok := props[OPCPropDescription].(string); ok {
+		info.Description = v
+	}
+	return info
+}
+
+//String prints the properties in a readable format.
+func (info TagInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", info.Tag)
+	fmt.Fprintf(&b, "  data type:     %s\n", info.DataType)
+	fmt.Fprintf(&b, "  access rights: %s\n", info.AccessRights)
+	fmt.Fprintf(&b, "  scan rate:     %g ms\n", info.ScanRate)
+	if info.EUType == OPCAnalogEU {
+		fmt.Fprintf(&b, "  EU range:      %g .. %g %s\n", info.EULow, info.EUHigh, info.EUUnits)
+	}
+	if info.Description != "" {
+		fmt.Fprintf(&b, "  description:   %s\n", info.Description)
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package opc
+
+import (
+	"testing"
+)
+
+func TestNewTagInfo(t *testing.T) {
+	info := NewTagInfo("numeric.sin.float", map[int32]interface{}{
+		OPCPropDataType:     int16(VTFloat32),
+		OPCPropAccessRights: int32(3),
+		OPCPropScanRate:     float32(100),
+		OPCPropEUType:       int32(1),
+		OPCPropEUInfo:       []interface{}{-100.0, 100.0},
+		OPCPropEUUnits:      "degC",
+		OPCPropDescription:  "sine wave",
+	})
+
+	want := TagInfo{
+		Tag:          "numeric.sin.float",
+		DataType:     VTFloat32,
+		AccessRights: OPCReadable | OPCWritable,
+		ScanRate:     100,
+		EUType:       OPCAnalogEU,
+		EULow:        -100,
+		EUHigh:       100,
+		EUUnits:      "degC",
+		Description:  "sine wave",
+	}
+	if info != want {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+	if info.DataType.String() != "float32" || info.AccessRights.String() != "read/write" {
+		t.Fatalf("names not correct: %s, %s", info.DataType, info.AccessRights)
+	}
+	if (VTArray | VTInt16).String() != "array of int16" {
+		t.Fatalf("array type name not correct: %s", VTArray|VTInt16)
+	}
+}
+
+func TestLoadProperties(t *testing.T) {
+	tree := testingCreateNewTree()
+	server := &OpcMockServerStatic{TagList: CollectTags(tree)}
+
+	if err := LoadProperties(tree, server); err != nil {
+		t.Fatal(err)
+	}
+	leaf := tree.Branches[1].Leaves[0]
+	if leaf.Info == nil || leaf.Info.Tag != "numeric.sin" || leaf.Info.Description != "static value 4" {
+		t.Fatalf("properties not loaded correctly: %+v", leaf.Info)
+	}
+	if info, _ := leaf.Properties(&OpcMockServerStatic{}); info != *leaf.Info {
+		t.Fatal("loaded properties should be returned by leaf")
+	}
+
+	if err := LoadProperties(tree, &OpcMockServerStatic{emptyServer: &emptyServer{}}); err == nil {
+		t.Fatal("unknown tags should return an error")
+	}
+}
@@ -0,0 +1,130 @@
+package opc
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Quality is the OPC DA quality word of an item. The low byte is laid out as
+//QQSSSSLL: two bits for the major quality, four bits for the substatus and two
+//bits for the limit status. The high byte is reserved for vendor specific use.
+type Quality int16
+
+const (
+	//OPCStatusMask selects the major quality and the substatus bits.
+	OPCStatusMask Quality = 0xFC
+	//OPCLimitMask selects the limit bits.
+	OPCLimitMask Quality = 0x03
+
+	//Substatus for bad quality
+	OPCQualityConfigError     Quality = 0x04
+	OPCQualityNotConnected    Quality = 0x08
+	OPCQualityDeviceFailure   Quality = 0x0C
+	OPCQualitySensorFailure   Quality = 0x10
+	OPCQualityLastKnown       Quality = 0x14
+	OPCQualityCommFailure     Quality = 0x18
+	OPCQualityOutOfService    Quality = 0x1C
+	OPCQualityWaitingForInput Quality = 0x20
+
+	//Substatus for uncertain quality
+	OPCQualityLastUsable Quality = 0x44
+	OPCQualitySensorCal  Quality = 0x50
+	OPCQualityEUExceeded Quality = 0x54
+	OPCQualitySubNormal  Quality = 0x58
+
+	//Substatus for good quality
+	OPCQualityLocalOverride Quality = 0xD8
+
+	//Limit status
+	OPCLimitOK    Quality = 0x00
+	OPCLimitLow   Quality = 0x01
+	OPCLimitHigh  Quality = 0x02
+	OPCLimitConst Quality = 0x03
+)
+
+//qualityNames maps the major quality to its name.
+var qualityNames = map[Quality]string{
+	OPCQualityBad:       "bad",
+	OPCQualityUncertain: "uncertain",
+	0x80:                "invalid",
+	OPCQualityGood:      "good",
+}
+
+//substatusNames maps the quality and substatus bits to a description.
+//The non-specific substatus of each major quality is left out on purpose.
+var substatusNames = map[Quality]string{
+	OPCQualityConfigError:     "config error",
+	OPCQualityNotConnected:    "not connected",
+	OPCQualityDeviceFailure:   "device failure",
+	OPCQualitySensorFailure:   "sensor failure",
+	OPCQualityLastKnown:       "last known value",
+	OPCQualityCommFailure:     "comm failure",
+	OPCQualityOutOfService:    "out of service",
+	OPCQualityWaitingForInput: "waiting for initial data",
+	OPCQualityLastUsable:      "last usable value",
+	OPCQualitySensorCal:       "sensor not accurate",
+	OPCQualityEUExceeded:      "EU units exceeded",
+	OPCQualitySubNormal:       "sub-normal",
+	OPCQualityLocalOverride:   "local override",
+}
+
+//limitNames maps the limit bits to a description.
+var limitNames = map[Quality]string{
+	OPCLimitLow:   "low limited",
+	OPCLimitHigh:  "high limited",
+	OPCLimitConst: "constant",
+}
+
+//Major returns the major quality, i.e. OPCQualityBad, OPCQualityUncertain or OPCQualityGood.
+func (q Quality) Major() Quality {
+	return q & OPCQualityMask
+}
+
+//Substatus returns the major quality together with the substatus bits.
+//The result can be compared against constants like OPCQualityCommFailure.
+func (q Quality) Substatus() Quality {
+	return q & OPCStatusMask
+}
+
+//Limit returns the limit bits of the quality.
+func (q Quality) Limit() Quality {
+	return q & OPCLimitMask
+}
+
+//Vendor returns the vendor specific high byte of the quality.
+func (q Quality) Vendor() uint8 {
+	return uint8(uint16(q) >> 8)
+}
+
+//Good returns true if the major quality is good.
+func (q Quality) Good() bool {
+	return q.Major() == OPCQualityGood
+}
+
+//Uncertain returns true if the major quality is uncertain.
+func (q Quality) Uncertain() bool {
+	return q.Major() == OPCQualityUncertain
+}
+
+//Bad returns true if the major quality is bad.
+func (q Quality) Bad() bool {
+	return q.Major() == OPCQualityBad
+}
+
+//String returns a readable description of the quality, e.g. "good, low limited"
+//for 0xC1 or "bad, comm failure" for 0x18.
+func (q Quality) String() string {
+	parts := []string{qualityNames[q.Major()]}
+	if name, ok := substatusNames[q.Substatus()]; ok {
+		parts = append(parts, name)
+	} else if sub := (q.Substatus() - q.Major()) >> 2; sub != 0 {
+		parts = append(parts, fmt.Sprintf("substatus %d", sub))
+	}
+	if name, ok := limitNames[q.Limit()]; ok {
+		parts = append(parts, name)
+	}
+	if vendor := q.Vendor(); vendor != 0 {
+		parts = append(parts, fmt.Sprintf("vendor 0x%02X", vendor))
+	}
+	return strings.Join(parts, ", ")
+}
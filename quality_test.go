@@ -0,0 +1,65 @@
+package opc
+
+import (
+	"testing"
+)
+
+func TestQualityString(t *testing.T) {
+	var config = []struct {
+		Quality Quality
+		Want    string
+	}{
+		{OPCQualityGood, "good"},
+		{OPCQualityGoodButForced, "good, local override"},
+		{0xC1, "good, low limited"},
+		{0x18, "bad, comm failure"},
+		{0x04, "bad, config error"},
+		{0x57, "uncertain, EU units exceeded, constant"},
+		{0x40, "uncertain"},
+		{0xC4, "good, substatus 1"},
+		{0x01C0, "good, vendor 0x01"},
+	}
+
+	for _, cfg := range config {
+		if got := cfg.Quality.String(); got != cfg.Want {
+			t.Errorf("quality 0x%02X: got %q, want %q", int16(cfg.Quality), got, cfg.Want)
+		}
+	}
+}
+
+func TestQualityBits(t *testing.T) {
+	q := Quality(0xDA)
+	if !q.Good() || q.Bad() || q.Uncertain() {
+		t.Fatal("major quality not decoded correctly")
+	}
+	if q.Substatus() != OPCQualityLocalOverride {
+		t.Fatalf("substatus not decoded correctly: 0x%02X", int16(q.Substatus()))
+	}
+	if q.Limit() != OPCLimitHigh {
+		t.Fatalf("limit not decoded correctly: 0x%02X", int16(q.Limit()))
+	}
+
+	if !Quality(0x18).Bad() || Quality(0x18).Substatus() != OPCQualityCommFailure {
+		t.Fatal("comm failure not decoded correctly")
+	}
+}
+
+func TestItemGood(t *testing.T) {
+	var config = []struct {
+		Quality Quality
+		Want    bool
+	}{
+		{OPCQualityGood, true},
+		{OPCQualityGoodButForced, true},
+		{0xC1, true},
+		{OPCQualityUncertain, false},
+		{OPCQualityCommFailure, false},
+	}
+
+	for _, cfg := range config {
+		item := Item{Quality: cfg.Quality}
+		if item.Good() != cfg.Want {
+			t.Errorf("Good() for %s: got %v, want %v", cfg.Quality, item.Good(), cfg.Want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package opc
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+//RenderFormat selects the output of Tree.Render
+type RenderFormat string
+
+//RenderFormat constants
+const (
+	RenderText     RenderFormat = "text"
+	RenderJSON     RenderFormat = "json"
+	RenderMarkdown RenderFormat = "markdown"
+	RenderDOT      RenderFormat = "dot"
+	RenderHTML     RenderFormat = "html"
+)
+
+//RenderFormats lists the supported formats, e.g. for command line flags.
+var RenderFormats = []RenderFormat{RenderText, RenderJSON, RenderMarkdown, RenderDOT, RenderHTML}
+
+//RenderOptions configure Tree.Render. The zero value renders the complete tree
+//as indented text with the names of the leaves.
+type RenderOptions struct {
+	Format     RenderFormat // defaults to RenderText
+	MaxDepth   int          // levels below the root; 0 renders all levels
+	ItemIDs    bool         // show the item IDs of the leaves instead of their names
+	LeafCounts bool         // show the number of leaves in the subtree of each branch
+	Unicode    bool         // use box-drawing characters for RenderText
+}
+
+//Render writes the tree to w in the format given by the options.
+//Branches below MaxDepth are shown without their subtree.
+func (t *Tree) Render(w io.Writer, opts RenderOptions) error {
+	r := &renderer{opts: opts}
+	switch opts.Format {
+	case "", RenderText:
+		r.text(t)
+	case RenderJSON:
+		return truncate(t, nil, opts.MaxDepth).WriteJSON(w)
+	case RenderMarkdown:
+		r.markdown(t, 0)
+	case RenderDOT:
+		r.dot(t)
+	case RenderHTML:
+		r.html(t)
+	default:
+		return errors.New("opc: unknown render format " + string(opts.Format))
+	}
+	_, err := io.WriteString(w, r.b.String())
+	return err
+}
+
+//renderer collects the output of a tree
+type renderer struct {
+	opts  RenderOptions
+	b     strings.Builder
+	nodes int
+}
+
+//expand checks if the subtree of a branch at the given depth is rendered
+func (r *renderer) expand(depth int) bool {
+	return r.opts.MaxDepth <= 0 || depth < r.opts.MaxDepth
+}
+
+//leafLabel returns the name or the item ID of the leaf
+func (r *renderer) leafLabel(l Leaf) string {
+	if r.opts.ItemIDs {
+		return l.Tag
+	}
+	return l.Name
+}
+
+//branchLabel returns the name of the branch with the optional leaf count
+func (r *renderer) branchLabel(t *Tree) string {
+	if r.opts.LeafCounts {
+		return fmt.Sprintf("%s (%d)", t.Name, countLeaves(t))
+	}
+	return t.Name
+}
+
+//text writes the tree as indented list like PrettyPrint or with box-drawing characters
+func (r *renderer) text(t *Tree) {
+	r.b.WriteString(r.branchLabel(t) + "\n")
+	if r.opts.Unicode {
+		r.unicode(t, "", 0)
+	} else {
+		r.ascii(t, 0)
+	}
+}
+
+//ascii is the recursive helper function for the plain text output
+func (r *renderer) ascii(t *Tree, depth int) {
+	space := strings.Repeat("  ", depth+1)
+	for _, l := range t.Leaves {
+		fmt.Fprintln(&r.b, space, "-", r.leafLabel(l))
+	}
+	for _, b := range t.Branches {
+		fmt.Fprintln(&r.b, space, "+", r.branchLabel(b))
+		if r.expand(depth + 1) {
+			r.ascii(b, depth+1)
+		}
+	}
+}
+
+//unicode is the recursive helper function for the box-drawing output
+func (r *renderer) unicode(t *Tree, prefix string, depth int) {
+	n := len(t.Leaves) + len(t.Branches)
+	for i, l := range t.Leaves {
+		r.b.WriteString(prefix + connector(i == n-1) + r.leafLabel(l) + "\n")
+	}
+	for i, b := range t.Branches {
+		last := len(t.Leaves)+i == n-1
+		r.b.WriteString(prefix + connector(last) + r.branchLabel(b) + "\n")
+		if r.expand(depth + 1) {
+			if last {
+				r.unicode(b, prefix+"    ", depth+1)
+			} else {
+				r.unicode(b, prefix+"│   ", depth+1)
+			}
+		}
+	}
+}
+
+//connector returns the box-drawing characters in front of an element
+func connector(last bool) string {
+	if last {
+		return "└── "
+	}
+	return "├── "
+}
+
+//markdown writes the tree as nested list with the branches in bold
+//and the leaves as code
+func (r *renderer) markdown(t *Tree, depth int) {
+	space := strings.Repeat("  ", depth)
+	fmt.Fprintf(&r.b, "%s- **%s**\n", space, markdownEscape(r.branchLabel(t)))
+	if !r.expand(depth) && depth > 0 {
+		return
+	}
+	for _, l := range t.Leaves {
+		fmt.Fprintf(&r.b, "%s  - `%s`\n", space, r.leafLabel(l))
+	}
+	for _, b := range t.Branches {
+		r.markdown(b, depth+1)
+	}
+}
+
+//markdownEscape escapes the characters with a meaning in Markdown
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+//dot writes the tree as Graphviz digraph with boxes for branches and ellipses for leaves
+func (r *renderer) dot(t *Tree) {
+	r.b.WriteString("digraph opc {\n")
+	r.b.WriteString("  rankdir=LR;\n")
+	r.b.WriteString("  node [shape=box];\n")
+	r.dotBranch(t, 0)
+	r.b.WriteString("}\n")
+}
+
+//dotBranch writes the nodes and edges of a branch and returns its node ID
+func (r *renderer) dotBranch(t *Tree, depth int) string {
+	id := r.nodeID()
+	fmt.Fprintf(&r.b, "  %s [label=%s];\n", id, dotQuote(r.branchLabel(t)))
+	if depth > 0 && !r.expand(depth) {
+		return id
+	}
+	for _, l := range t.Leaves {
+		leaf := r.nodeID()
+		fmt.Fprintf(&r.b, "  %s [label=%s, shape=ellipse];\n", leaf, dotQuote(r.leafLabel(l)))
+		fmt.Fprintf(&r.b, "  %s -> %s;\n", id, leaf)
+	}
+	for _, b := range t.Branches {
+		sub := r.dotBranch(b, depth+1)
+		fmt.Fprintf(&r.b, "  %s -> %s;\n", id, sub)
+	}
+	return id
+}
+
+//nodeID returns a new unique node ID for the DOT output
+func (r *renderer) nodeID() string {
+	r.nodes++
+	return fmt.Sprintf("n%d", r.nodes-1)
+}
+
+//dotQuote returns s as quoted DOT string
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+//html writes the tree as nested lists with collapsible branches. The output
+//is a fragment that can be embedded in a page.
+func (r *renderer) html(t *Tree) {
+	r.b.WriteString("<ul class=\"opc-tree\">\n")
+	r.htmlBranch(t, 0)
+	r.b.WriteString("</ul>\n")
+}
+
+//htmlBranch is the recursive helper function for the HTML output
+func (r *renderer) htmlBranch(t *Tree, depth int) {
+	space := strings.Repeat("  ", depth+1)
+	if depth > 0 && !r.expand(depth) {
+		fmt.Fprintf(&r.b, "%s<li class=\"branch\">%s</li>\n", space, html.EscapeString(r.branchLabel(t)))
+		return
+	}
+	fmt.Fprintf(&r.b, "%s<li class=\"branch\"><details open><summary>%s</summary>\n", space, html.EscapeString(r.branchLabel(t)))
+	fmt.Fprintf(&r.b, "%s<ul>\n", space)
+	for _, l := range t.Leaves {
+		fmt.Fprintf(&r.b, "%s  <li class=\"leaf\" title=\"%s\">%s</li>\n", space, html.EscapeString(l.Tag), html.EscapeString(r.leafLabel(l)))
+	}
+	for _, b := range t.Branches {
+		r.htmlBranch(b, depth+1)
+	}
+	fmt.Fprintf(&r.b, "%s</ul>\n", space)
+	fmt.Fprintf(&r.b, "%s</details></li>\n", space)
+}
+
+//truncate returns a copy of the tree without the levels below maxDepth
+func truncate(t *Tree, parent *Tree, maxDepth int) *Tree {
+	if maxDepth <= 0 {
+		return t
+	}
+	branch := &Tree{Name: t.Name, Parent: parent, Branches: []*Tree{}, Leaves: t.Leaves}
+	if maxDepth > 1 {
+		for _, b := range t.Branches {
+			branch.Branches = append(branch.Branches, truncate(b, branch, maxDepth-1))
+		}
+	} else {
+		for _, b := range t.Branches {
+			branch.Branches = append(branch.Branches, &Tree{Name: b.Name, Parent: branch, Branches: []*Tree{}, Leaves: []Leaf{}})
+		}
+	}
+	return branch
+}
+
+//countLeaves returns the number of leaves in the subtree
+func countLeaves(t *Tree) int {
+	n := len(t.Leaves)
+	for _, b := range t.Branches {
+		n += countLeaves(b)
+	}
+	return n
+}
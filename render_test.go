@@ -0,0 +1,128 @@
+package opc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRenderText(t *testing.T) {
+	tree := testingCreateNewTree()
+
+	var buf bytes.Buffer
+	if err := tree.Render(&buf, RenderOptions{ItemIDs: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `root
+   - bandwidth
+   + options
+     - options.frequency
+     - options.amplitude
+   + numeric
+     - numeric.sin
+     - numeric.cos
+     - numeric.tan
+`
+	if buf.String() != expected {
+		t.Errorf("wrong text output:\n%s", buf.String())
+	}
+}
+
+func TestRenderUnicode(t *testing.T) {
+	tree := testingCreateNewTree()
+
+	var buf bytes.Buffer
+	err := tree.Render(&buf, RenderOptions{Unicode: true, LeafCounts: true, MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `root (6)
+├── bandwidth
+├── options (2)
+└── numeric (3)
+`
+	if buf.String() != expected {
+		t.Errorf("wrong unicode output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	tree.Render(&buf, RenderOptions{Unicode: true})
+	if !strings.Contains(buf.String(), "│   ├── frequency\n") || !strings.Contains(buf.String(), "    └── tan\n") {
+		t.Errorf("wrong unicode output:\n%s", buf.String())
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tree := testingCreateNewTree()
+
+	var buf bytes.Buffer
+	if err := tree.Render(&buf, RenderOptions{Format: RenderMarkdown, MaxDepth: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "- **root**\n  - `bandwidth`\n  - **options**\n  - **numeric**\n"
+	if buf.String() != expected {
+		t.Errorf("wrong markdown output:\n%s", buf.String())
+	}
+}
+
+func TestRenderDOT(t *testing.T) {
+	tree := testingCreateNewTree()
+	tree.Branches[0].Name = `opt"ions`
+
+	var buf bytes.Buffer
+	if err := tree.Render(&buf, RenderOptions{Format: RenderDOT}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"digraph opc {", `n0 [label="root"];`, `n2 [label="opt\"ions"];`, "n0 -> n2;", "n2 -> n3;"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("missing %q in:\n%s", s, out)
+		}
+	}
+	if strings.Count(out, "shape=ellipse") != 6 {
+		t.Errorf("expected 6 leaves in:\n%s", out)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	tree := testingCreateNewTree()
+	tree.Leaves[0].Name = "<b>"
+
+	var buf bytes.Buffer
+	if err := tree.Render(&buf, RenderOptions{Format: RenderHTML}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `<li class="leaf" title="bandwidth">&lt;b&gt;</li>`) {
+		t.Errorf("leaf not escaped:\n%s", out)
+	}
+	if strings.Count(out, "<details open>") != 3 {
+		t.Errorf("expected 3 branches:\n%s", out)
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	tree := testingCreateNewTree()
+
+	var buf bytes.Buffer
+	if err := tree.Render(&buf, RenderOptions{Format: RenderJSON, MaxDepth: 1}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ReadTreeJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Branches) != 2 || len(restored.Branches[0].Leaves) != 0 || len(restored.Leaves) != 1 {
+		t.Error("tree not truncated")
+	}
+	if len(tree.Branches[0].Leaves) != 2 {
+		t.Error("original tree modified")
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testingCreateNewTree().Render(&buf, RenderOptions{Format: "pdf"}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,131 @@
+package opc
+
+import (
+	"fmt"
+	"math"
+	"syscall"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+)
+
+var (
+	oleaut32                = syscall.NewLazyDLL("oleaut32.dll")
+	procSafeArrayGetLBound  = oleaut32.NewProc("SafeArrayGetLBound")
+	procSafeArrayGetUBound  = oleaut32.NewProc("SafeArrayGetUBound")
+	procSafeArrayGetVartype = oleaut32.NewProc("SafeArrayGetVartype")
+	procSafeArrayGetElement = oleaut32.NewProc("SafeArrayGetElement")
+)
+
+//arrayValues returns the elements of a one-dimensional SAFEARRAY from its lower
+//to its upper bound. The arrays of the OPC Automation interface are 1-based,
+//but ToValueArray of go-ole reads them from index 0.
+func arrayValues(array *ole.SafeArrayConversion) ([]interface{}, error) {
+	if array == nil || array.Array == nil {
+		return nil, nil
+	}
+	sa := uintptr(unsafe.Pointer(array.Array))
+
+	var lower, upper int32
+	if err := safeArrayCall(procSafeArrayGetLBound, sa, 1, uintptr(unsafe.Pointer(&lower))); err != nil {
+		return nil, err
+	}
+	if err := safeArrayCall(procSafeArrayGetUBound, sa, 1, uintptr(unsafe.Pointer(&upper))); err != nil {
+		return nil, err
+	}
+	var vt uint16
+	if err := safeArrayCall(procSafeArrayGetVartype, sa, uintptr(unsafe.Pointer(&vt))); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, upper-lower+1)
+	for i := lower; i <= upper; i++ {
+		index := i
+		if ole.VT(vt) == ole.VT_VARIANT {
+			var v ole.VARIANT
+			if err := safeArrayCall(procSafeArrayGetElement, sa, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(&v))); err != nil {
+				return nil, err
+			}
+			values = append(values, v.Value())
+			v.Clear()
+			continue
+		}
+		if ole.VT(vt) == ole.VT_BSTR {
+			var bstr *uint16
+			if err := safeArrayCall(procSafeArrayGetElement, sa, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(&bstr))); err != nil {
+				return nil, err
+			}
+			values = append(values, ole.BstrToString(bstr))
+			ole.SysFreeString((*int16)(unsafe.Pointer(bstr)))
+			continue
+		}
+
+		//scalar elements have at most 16 bytes (DECIMAL)
+		var buf [16]byte
+		if err := safeArrayCall(procSafeArrayGetElement, sa, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(&buf))); err != nil {
+			return nil, err
+		}
+		p := unsafe.Pointer(&buf)
+		switch ole.VT(vt) {
+		case ole.VT_BOOL:
+			values = append(values, *(*int16)(p) != 0)
+		case ole.VT_I1:
+			values = append(values, *(*int8)(p))
+		case ole.VT_I2:
+			values = append(values, *(*int16)(p))
+		case ole.VT_I4, ole.VT_INT:
+			values = append(values, *(*int32)(p))
+		case ole.VT_I8:
+			values = append(values, *(*int64)(p))
+		case ole.VT_UI1:
+			values = append(values, *(*uint8)(p))
+		case ole.VT_UI2:
+			values = append(values, *(*uint16)(p))
+		case ole.VT_UI4, ole.VT_UINT:
+			values = append(values, *(*uint32)(p))
+		case ole.VT_UI8:
+			values = append(values, *(*uint64)(p))
+		case ole.VT_R4:
+			values = append(values, *(*float32)(p))
+		case ole.VT_R8:
+			values = append(values, *(*float64)(p))
+		case ole.VT_DATE:
+			date, err := ole.GetVariantDate(*(*uint64)(p))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, date)
+		case ole.VT_CY:
+			//currency is a fixed-point number with four decimal places
+			values = append(values, float64(*(*int64)(p))/10000)
+		case ole.VT_DECIMAL:
+			values = append(values, decimalValue(buf))
+		case ole.VT_ERROR:
+			values = append(values, *(*int32)(p))
+		default:
+			return nil, fmt.Errorf("unsupported array element type %d", vt)
+		}
+	}
+	return values, nil
+}
+
+//decimalValue converts a DECIMAL (reserved, scale, sign, 96-bit mantissa) to float64
+func decimalValue(buf [16]byte) float64 {
+	scale, sign := buf[2], buf[3]
+	hi := *(*uint32)(unsafe.Pointer(&buf[4]))
+	lo := *(*uint64)(unsafe.Pointer(&buf[8]))
+	value := (float64(hi)*math.Pow(2, 64) + float64(lo)) / math.Pow10(int(scale))
+	if sign&0x80 != 0 {
+		return -value
+	}
+	return value
+}
+
+//safeArrayCall calls a SafeArray function of oleaut32 and converts the HRESULT
+func safeArrayCall(proc *syscall.LazyProc, args ...uintptr) error {
+	hr, _, _ := proc.Call(args...)
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
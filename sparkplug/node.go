@@ -0,0 +1,329 @@
+package sparkplug
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/bridge"
+	"github.com/konimarti/opc/wire"
+)
+
+// Namespace is the first element of all Sparkplug B topics
+const Namespace = "spBv1.0"
+
+// Message types of the topic namespace
+const (
+	NBIRTH = "NBIRTH"
+	NDEATH = "NDEATH"
+	NDATA  = "NDATA"
+	NCMD   = "NCMD"
+	DBIRTH = "DBIRTH"
+	DDEATH = "DDEATH"
+	DDATA  = "DDATA"
+	DCMD   = "DCMD"
+)
+
+// Metrics of the edge node session
+const (
+	BdSeqMetric   = "bdSeq"
+	RebirthMetric = "Node Control/Rebirth"
+)
+
+// ErrRebirth is returned by Data if the data type of a tag changed since the
+// last birth. The births have to be published again before the data.
+var ErrRebirth = errors.New("sparkplug: data type changed, rebirth required")
+
+// Config identifies the edge node. With a device ID, the tags are published as
+// metrics of this device with DBIRTH and DDATA instead of NBIRTH and NDATA.
+type Config struct {
+	GroupID    string `yaml:"group_id" toml:"group_id" json:"group_id"`
+	EdgeNodeID string `yaml:"edge_node_id" toml:"edge_node_id" json:"edge_node_id"`
+	DeviceID   string `yaml:"device_id" toml:"device_id" json:"device_id"`
+	BdSeqFile  string `yaml:"bdseq_file" toml:"bdseq_file" json:"bdseq_file"` // persists bdSeq across restarts
+}
+
+// Command is a decoded NCMD or DCMD message. Writes holds the new values by tag.
+type Command struct {
+	Rebirth bool
+	Writes  map[string]interface{}
+}
+
+// EdgeNode creates the Sparkplug B messages of an edge node whose metrics are
+// OPC tags. The metric name is the item ID with '/' as separator and the alias
+// is the position of the tag in the sorted list of tags. It is safe for
+// concurrent use.
+type EdgeNode struct {
+	cfg     Config
+	mu      sync.Mutex
+	seq     int
+	bdSeq   uint64
+	tags    map[string]string   // tag by metric name
+	aliases map[string]uint64   // alias by tag
+	types   map[string]DataType // data type of the last birth by tag
+}
+
+// NewEdgeNode creates an edge node for the tags. The birth/death sequence number
+// continues after the one stored in the BdSeqFile of the config, or is derived
+// from the current time without a file, so that a death certificate of an
+// earlier process is not mistaken for the current session.
+func NewEdgeNode(cfg Config, tags []string) (*EdgeNode, error) {
+	if cfg.GroupID == "" || cfg.EdgeNodeID == "" {
+		return nil, errors.New("sparkplug: group_id and edge_node_id are required")
+	}
+	for _, id := range []string{cfg.GroupID, cfg.EdgeNodeID, cfg.DeviceID} {
+		if strings.ContainsAny(id, "/+#") {
+			return nil, errors.New("sparkplug: invalid character in ID " + id)
+		}
+	}
+	n := &EdgeNode{
+		cfg:     cfg,
+		tags:    make(map[string]string),
+		aliases: make(map[string]uint64),
+		types:   make(map[string]DataType),
+	}
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	for _, tag := range sorted {
+		if _, ok := n.aliases[tag]; ok {
+			continue
+		}
+		n.aliases[tag] = uint64(len(n.aliases) + 1)
+		n.tags[MetricName(tag)] = tag
+	}
+	if cfg.BdSeqFile == "" {
+		n.bdSeq = uint64(time.Now().Unix() % 256)
+		return n, nil
+	}
+	b, err := os.ReadFile(cfg.BdSeqFile)
+	switch {
+	case err == nil:
+		last, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("sparkplug: invalid bdSeq in %s: %v", cfg.BdSeqFile, err)
+		}
+		n.bdSeq = (last + 1) % 256
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("sparkplug: %v", err)
+	}
+	if err := n.storeBdSeq(n.bdSeq); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Reconnect starts a new session before the client connects again: it increments
+// the birth/death sequence number and returns the NDEATH message to register as
+// last will of the new connection
+func (n *EdgeNode) Reconnect() (bridge.Message, error) {
+	n.mu.Lock()
+	bdSeq := (n.bdSeq + 1) % 256
+	err := n.storeBdSeq(bdSeq)
+	if err == nil {
+		n.bdSeq = bdSeq
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return bridge.Message{}, err
+	}
+	return n.Death()
+}
+
+// storeBdSeq writes the birth/death sequence number to the BdSeqFile
+func (n *EdgeNode) storeBdSeq(bdSeq uint64) error {
+	if n.cfg.BdSeqFile == "" {
+		return nil
+	}
+	if err := os.WriteFile(n.cfg.BdSeqFile, []byte(strconv.FormatUint(bdSeq, 10)), 0o644); err != nil {
+		return fmt.Errorf("sparkplug: %v", err)
+	}
+	return nil
+}
+
+// MetricName returns the metric name of a tag, the item ID with '/' as separator
+func MetricName(tag string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(opc.DefaultTagSeparators, r) {
+			return '/'
+		}
+		return r
+	}, tag)
+}
+
+// Topic returns the topic of a message type, e.g. "spBv1.0/group/NDATA/node"
+// or "spBv1.0/group/DDATA/node/device"
+func (n *EdgeNode) Topic(messageType string) string {
+	topic := Namespace + "/" + n.cfg.GroupID + "/" + messageType + "/" + n.cfg.EdgeNodeID
+	if strings.HasPrefix(messageType, "D") {
+		topic += "/" + n.cfg.DeviceID
+	}
+	return topic
+}
+
+// CommandTopics returns the topics to subscribe to for commands
+func (n *EdgeNode) CommandTopics() []string {
+	topics := []string{n.Topic(NCMD)}
+	if n.cfg.DeviceID != "" {
+		topics = append(topics, n.Topic(DCMD))
+	}
+	return topics
+}
+
+// Death returns the NDEATH message, which is registered as MQTT last will
+func (n *EdgeNode) Death() (bridge.Message, error) {
+	n.mu.Lock()
+	bdSeq := n.bdSeq
+	n.mu.Unlock()
+	payload, err := Payload{
+		Timestamp: time.Now(),
+		Seq:       -1,
+		Metrics:   []Metric{{Name: BdSeqMetric, DataType: TypeUInt64, Value: bdSeq}},
+	}.Marshal()
+	return bridge.Message{Topic: n.Topic(NDEATH), Payload: payload, QoS: 1}, err
+}
+
+// Birth returns the NBIRTH and, with a device, the DBIRTH message with the current
+// values of the tags and restarts the sequence numbers. Tags that were never read
+// are announced as null strings with bad quality until their first value is read.
+func (n *EdgeNode) Birth(items map[string]opc.Item, now time.Time) ([]bridge.Message, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var metrics []Metric
+	for _, tag := range n.sortedTags() {
+		item, ok := items[tag]
+		if ok && item.Value != nil {
+			n.types[tag] = TypeOf(wire.NewSample(tag, item).Value)
+		} else if _, known := n.types[tag]; !known {
+			n.types[tag] = TypeString
+		}
+		m := n.metric(tag, item)
+		m.Name = MetricName(tag)
+		metrics = append(metrics, m)
+	}
+
+	nodeMetrics := []Metric{
+		{Name: BdSeqMetric, DataType: TypeUInt64, Value: n.bdSeq, Timestamp: now},
+		{Name: RebirthMetric, DataType: TypeBoolean, Value: false, Timestamp: now},
+	}
+	n.seq = 0
+	if n.cfg.DeviceID == "" {
+		msg, err := n.message(NBIRTH, now, append(nodeMetrics, metrics...))
+		return []bridge.Message{msg}, err
+	}
+	nbirth, err := n.message(NBIRTH, now, nodeMetrics)
+	if err != nil {
+		return nil, err
+	}
+	dbirth, err := n.message(DBIRTH, now, metrics)
+	return []bridge.Message{nbirth, dbirth}, err
+}
+
+// Data returns the NDATA or DDATA message of the items with aliases instead of
+// names. Tags of other nodes are ignored. It returns ErrRebirth if the data type
+// of a tag changed since the last birth.
+func (n *EdgeNode) Data(items map[string]opc.Item, now time.Time) (bridge.Message, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var metrics []Metric
+	for _, tag := range n.sortedTags() {
+		item, ok := items[tag]
+		if !ok {
+			continue
+		}
+		if item.Value != nil && TypeOf(wire.NewSample(tag, item).Value) != n.types[tag] {
+			return bridge.Message{}, ErrRebirth
+		}
+		metrics = append(metrics, n.metric(tag, item))
+	}
+	messageType := NDATA
+	if n.cfg.DeviceID != "" {
+		messageType = DDATA
+	}
+	return n.message(messageType, now, metrics)
+}
+
+// Command decodes an NCMD or DCMD message. Writes to unknown metrics are
+// returned as error together with the other values.
+func (n *EdgeNode) Command(topic string, data []byte) (Command, error) {
+	if topic != n.Topic(NCMD) && (n.cfg.DeviceID == "" || topic != n.Topic(DCMD)) {
+		return Command{}, errors.New("sparkplug: not a command topic of this node: " + topic)
+	}
+	payload, err := Unmarshal(data)
+	if err != nil {
+		return Command{}, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	cmd := Command{Writes: make(map[string]interface{})}
+	var errs []error
+	for _, m := range payload.Metrics {
+		if m.Name == RebirthMetric {
+			cmd.Rebirth, _ = m.Value.(bool)
+			continue
+		}
+		tag, ok := n.tags[m.Name]
+		if !ok && m.Alias != 0 {
+			for t, alias := range n.aliases {
+				if alias == m.Alias {
+					tag, ok = t, true
+				}
+			}
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("sparkplug: unknown metric %q (alias %d)", m.Name, m.Alias))
+			continue
+		}
+		cmd.Writes[tag] = m.Value
+	}
+	return cmd, errors.Join(errs...)
+}
+
+// metric returns the metric of an item with alias, value and quality
+func (n *EdgeNode) metric(tag string, item opc.Item) Metric {
+	timestamp := item.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return Metric{
+		Alias:      n.aliases[tag],
+		Timestamp:  timestamp,
+		DataType:   n.types[tag],
+		Value:      wire.NewSample(tag, item).Value,
+		Properties: map[string]interface{}{QualityProperty: Quality(item.Quality)},
+	}
+}
+
+// message encodes the metrics with the next sequence number
+func (n *EdgeNode) message(messageType string, now time.Time, metrics []Metric) (bridge.Message, error) {
+	payload, err := Payload{Timestamp: now, Seq: n.seq, Metrics: metrics}.Marshal()
+	n.seq = (n.seq + 1) % 256
+	return bridge.Message{Topic: n.Topic(messageType), Payload: payload}, err
+}
+
+// sortedTags returns the tags in alias order
+func (n *EdgeNode) sortedTags() []string {
+	tags := make([]string, len(n.aliases))
+	for tag, alias := range n.aliases {
+		tags[alias-1] = tag
+	}
+	return tags
+}
+
+// sortedKeys returns the sorted keys of a map
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,519 @@
+// Package sparkplug implements a Sparkplug B edge node for the MQTT bridge: the
+// topic namespace, the protobuf payload of sparkplug_b.proto and the session with
+// birth and death certificates, sequence numbers, aliases and rebirth requests.
+package sparkplug
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/konimarti/opc"
+	"github.com/konimarti/opc/wire"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DataType is the data type of a metric or property in Sparkplug B
+type DataType uint32
+
+// Data types of Sparkplug B
+const (
+	TypeUnknown  DataType = 0
+	TypeInt8     DataType = 1
+	TypeInt16    DataType = 2
+	TypeInt32    DataType = 3
+	TypeInt64    DataType = 4
+	TypeUInt8    DataType = 5
+	TypeUInt16   DataType = 6
+	TypeUInt32   DataType = 7
+	TypeUInt64   DataType = 8
+	TypeFloat    DataType = 9
+	TypeDouble   DataType = 10
+	TypeBoolean  DataType = 11
+	TypeString   DataType = 12
+	TypeDateTime DataType = 13
+	TypeText     DataType = 14
+)
+
+// Quality codes of the "Quality" property of a metric
+const (
+	QualityBad   int32 = 0
+	QualityGood  int32 = 192
+	QualityStale int32 = 500
+)
+
+// QualityProperty is the name of the metric property with the quality code
+const QualityProperty = "Quality"
+
+// field numbers of sparkplug_b.proto
+const (
+	payloadTimestamp protowire.Number = 1
+	payloadMetrics   protowire.Number = 2
+	payloadSeq       protowire.Number = 3
+
+	metricName        protowire.Number = 1
+	metricAlias       protowire.Number = 2
+	metricTimestamp   protowire.Number = 3
+	metricDataType    protowire.Number = 4
+	metricIsNull      protowire.Number = 7
+	metricProperties  protowire.Number = 9
+	metricIntValue    protowire.Number = 10
+	metricLongValue   protowire.Number = 11
+	metricFloatValue  protowire.Number = 12
+	metricDoubleValue protowire.Number = 13
+	metricBoolValue   protowire.Number = 14
+	metricStringValue protowire.Number = 15
+
+	propertySetKeys   protowire.Number = 1
+	propertySetValues protowire.Number = 2
+
+	propertyType        protowire.Number = 1
+	propertyIsNull      protowire.Number = 2
+	propertyIntValue    protowire.Number = 3
+	propertyLongValue   protowire.Number = 4
+	propertyDoubleValue protowire.Number = 6
+	propertyBoolValue   protowire.Number = 7
+	propertyStringValue protowire.Number = 8
+)
+
+// Payload is the Sparkplug B payload of all messages
+type Payload struct {
+	Timestamp time.Time
+	Seq       int // sequence number 0-255, -1 for messages without sequence number like NDEATH
+	Metrics   []Metric
+}
+
+// Metric is a single value of a payload. Value is nil for null values.
+// Properties support int32, int64, float64, bool and string values.
+type Metric struct {
+	Name       string
+	Alias      uint64
+	Timestamp  time.Time
+	DataType   DataType
+	Value      interface{}
+	Properties map[string]interface{}
+}
+
+// Quality returns the Sparkplug quality code of an OPC quality
+func Quality(q opc.Quality) int32 {
+	switch {
+	case q.Good():
+		return QualityGood
+	case q.Uncertain():
+		return QualityStale
+	}
+	return QualityBad
+}
+
+// TypeOf returns the Sparkplug data type of an item value. Arrays are sent as
+// JSON text.
+func TypeOf(v interface{}) DataType {
+	switch wire.DataType(v) {
+	case wire.TypeBool:
+		return TypeBoolean
+	case wire.TypeInt8:
+		return TypeInt8
+	case wire.TypeInt16:
+		return TypeInt16
+	case wire.TypeInt32:
+		return TypeInt32
+	case wire.TypeInt64:
+		return TypeInt64
+	case wire.TypeUint8:
+		return TypeUInt8
+	case wire.TypeUint16:
+		return TypeUInt16
+	case wire.TypeUint32:
+		return TypeUInt32
+	case wire.TypeUint64:
+		return TypeUInt64
+	case wire.TypeFloat32:
+		return TypeFloat
+	case wire.TypeFloat64:
+		return TypeDouble
+	case wire.TypeString:
+		return TypeString
+	case wire.TypeTime:
+		return TypeDateTime
+	case wire.TypeArray:
+		return TypeText
+	}
+	return TypeUnknown
+}
+
+// Marshal encodes the payload
+func (p Payload) Marshal() ([]byte, error) {
+	var b []byte
+	if !p.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, payloadTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.Timestamp.UnixMilli()))
+	}
+	for _, m := range p.Metrics {
+		msg, err := m.marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, payloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	if p.Seq >= 0 {
+		b = protowire.AppendTag(b, payloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.Seq))
+	}
+	return b, nil
+}
+
+// marshal encodes the metric with the value converted to its data type
+func (m Metric) marshal() ([]byte, error) {
+	var b []byte
+	if m.Name != "" {
+		b = protowire.AppendTag(b, metricName, protowire.BytesType)
+		b = protowire.AppendString(b, m.Name)
+	}
+	if m.Alias != 0 {
+		b = protowire.AppendTag(b, metricAlias, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.Alias)
+	}
+	if !m.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, metricTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.Timestamp.UnixMilli()))
+	}
+	b = protowire.AppendTag(b, metricDataType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.DataType))
+	if len(m.Properties) > 0 {
+		props, err := marshalProperties(m.Properties)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, metricProperties, protowire.BytesType)
+		b = protowire.AppendBytes(b, props)
+	}
+	if m.Value == nil {
+		b = protowire.AppendTag(b, metricIsNull, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(true)), nil
+	}
+
+	item := opc.Item{Value: m.Value}
+	var err error
+	switch m.DataType {
+	case TypeInt8, TypeInt16, TypeInt32, TypeUInt8, TypeUInt16, TypeUInt32:
+		var n int64
+		if n, err = item.Int64(); err == nil {
+			b = protowire.AppendTag(b, metricIntValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(uint32(n)))
+		}
+	case TypeInt64, TypeUInt64:
+		var n uint64
+		if n, err = toUint64(m.Value); err == nil {
+			b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, n)
+		}
+	case TypeDateTime:
+		var t time.Time
+		if t, err = item.Time(); err == nil {
+			b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(t.UnixMilli()))
+		}
+	case TypeFloat:
+		var f float64
+		if f, err = item.Float64(); err == nil {
+			b = protowire.AppendTag(b, metricFloatValue, protowire.Fixed32Type)
+			b = protowire.AppendFixed32(b, math.Float32bits(float32(f)))
+		}
+	case TypeDouble:
+		var f float64
+		if f, err = item.Float64(); err == nil {
+			b = protowire.AppendTag(b, metricDoubleValue, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(f))
+		}
+	case TypeBoolean:
+		var v bool
+		if v, err = item.Bool(); err == nil {
+			b = protowire.AppendTag(b, metricBoolValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+		}
+	case TypeString, TypeText:
+		var s string
+		if _, ok := m.Value.([]interface{}); ok {
+			var data []byte
+			data, err = json.Marshal(m.Value)
+			s = string(data)
+		} else {
+			s, err = item.Text()
+		}
+		if err == nil {
+			b = protowire.AppendTag(b, metricStringValue, protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	default:
+		err = fmt.Errorf("sparkplug: unsupported data type %d of %s", m.DataType, m.Name)
+	}
+	return b, err
+}
+
+// toUint64 returns the bits of an integer value for the long_value field
+func toUint64(v interface{}) (uint64, error) {
+	switch x := v.(type) {
+	case uint64:
+		return x, nil
+	case uint32:
+		return uint64(x), nil
+	case uint16:
+		return uint64(x), nil
+	case uint8:
+		return uint64(x), nil
+	}
+	n, err := opc.Item{Value: v}.Int64()
+	return uint64(n), err
+}
+
+// marshalProperties encodes the PropertySet with sorted keys
+func marshalProperties(props map[string]interface{}) ([]byte, error) {
+	var b []byte
+	for _, key := range sortedKeys(props) {
+		var value []byte
+		switch x := props[key].(type) {
+		case int32:
+			value = protowire.AppendTag(value, propertyType, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(TypeInt32))
+			value = protowire.AppendTag(value, propertyIntValue, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(uint32(x)))
+		case int64:
+			value = protowire.AppendTag(value, propertyType, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(TypeInt64))
+			value = protowire.AppendTag(value, propertyLongValue, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(x))
+		case float64:
+			value = protowire.AppendTag(value, propertyType, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(TypeDouble))
+			value = protowire.AppendTag(value, propertyDoubleValue, protowire.Fixed64Type)
+			value = protowire.AppendFixed64(value, math.Float64bits(x))
+		case bool:
+			value = protowire.AppendTag(value, propertyType, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(TypeBoolean))
+			value = protowire.AppendTag(value, propertyBoolValue, protowire.VarintType)
+			value = protowire.AppendVarint(value, protowire.EncodeBool(x))
+		case string:
+			value = protowire.AppendTag(value, propertyType, protowire.VarintType)
+			value = protowire.AppendVarint(value, uint64(TypeString))
+			value = protowire.AppendTag(value, propertyStringValue, protowire.BytesType)
+			value = protowire.AppendString(value, x)
+		default:
+			return nil, fmt.Errorf("sparkplug: cannot encode property %s of type %T", key, x)
+		}
+		b = protowire.AppendTag(b, propertySetKeys, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+		b = protowire.AppendTag(b, propertySetValues, protowire.BytesType)
+		b = protowire.AppendBytes(b, value)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes a payload, e.g. of an NCMD or DCMD message
+func Unmarshal(data []byte) (Payload, error) {
+	p := Payload{Seq: -1}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == payloadTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			p.Timestamp = time.UnixMilli(int64(v))
+			return n, nil
+		case num == payloadSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			p.Seq = int(v)
+			return n, nil
+		case num == payloadMetrics && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			m, err := unmarshalMetric(msg)
+			p.Metrics = append(p.Metrics, m)
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return p, err
+}
+
+// unmarshalMetric decodes a metric and converts the value to the Go type of its data type
+func unmarshalMetric(data []byte) (Metric, error) {
+	var m Metric
+	var raw interface{}
+	isNull := false
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == metricName && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			m.Name = v
+			return n, nil
+		case num == metricAlias && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Alias = v
+			return n, nil
+		case num == metricTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Timestamp = time.UnixMilli(int64(v))
+			return n, nil
+		case num == metricDataType && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.DataType = DataType(v)
+			return n, nil
+		case num == metricIsNull && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			isNull = protowire.DecodeBool(v)
+			return n, nil
+		case num == metricProperties && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			props, err := unmarshalProperties(msg)
+			m.Properties = props
+			return n, err
+		case (num == metricIntValue || num == metricLongValue || num == metricBoolValue) && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			raw = v
+			return n, nil
+		case num == metricFloatValue && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			raw = math.Float32frombits(v)
+			return n, nil
+		case num == metricDoubleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			raw = math.Float64frombits(v)
+			return n, nil
+		case num == metricStringValue && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			raw = v
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil || isNull || raw == nil {
+		return m, err
+	}
+	m.Value, err = convert(m.DataType, raw)
+	return m, err
+}
+
+// convert returns the decoded raw value as Go type of the data type
+func convert(t DataType, raw interface{}) (interface{}, error) {
+	if n, ok := raw.(uint64); ok {
+		switch t {
+		case TypeInt8:
+			return int8(n), nil
+		case TypeInt16:
+			return int16(n), nil
+		case TypeInt32:
+			return int32(n), nil
+		case TypeInt64:
+			return int64(n), nil
+		case TypeUInt8:
+			return uint8(n), nil
+		case TypeUInt16:
+			return uint16(n), nil
+		case TypeUInt32:
+			return uint32(n), nil
+		case TypeUInt64:
+			return n, nil
+		case TypeBoolean:
+			return n != 0, nil
+		case TypeDateTime:
+			return time.UnixMilli(int64(n)).UTC(), nil
+		}
+		return nil, fmt.Errorf("sparkplug: integer value for data type %d", t)
+	}
+	switch t {
+	case TypeFloat, TypeDouble, TypeString, TypeText:
+		return raw, nil
+	}
+	return nil, fmt.Errorf("sparkplug: %T value for data type %d", raw, t)
+}
+
+// unmarshalProperties decodes a PropertySet
+func unmarshalProperties(data []byte) (map[string]interface{}, error) {
+	var keys []string
+	var values []interface{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == propertySetKeys && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			keys = append(keys, v)
+			return n, nil
+		case num == propertySetValues && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			v, err := unmarshalProperty(msg)
+			values = append(values, v)
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	props := make(map[string]interface{})
+	for i := 0; i < len(keys) && i < len(values); i++ {
+		props[keys[i]] = values[i]
+	}
+	return props, err
+}
+
+// unmarshalProperty decodes a PropertyValue
+func unmarshalProperty(data []byte) (interface{}, error) {
+	var t DataType
+	var value interface{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == propertyType && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			t = DataType(v)
+			return n, nil
+		case (num == propertyIntValue || num == propertyLongValue || num == propertyBoolValue) && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			value = v
+			return n, nil
+		case num == propertyDoubleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			value = math.Float64frombits(v)
+			return n, nil
+		case num == propertyStringValue && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			value = v
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if n, ok := value.(uint64); ok {
+		switch t {
+		case TypeInt32:
+			value = int32(n)
+		case TypeInt64:
+			value = int64(n)
+		case TypeBoolean:
+			value = n != 0
+		}
+	}
+	return value, err
+}
+
+// consumeFields calls field for every field of a message
+func consumeFields(data []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package sparkplug
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+func TestPayloadRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	payload := Payload{
+		Timestamp: ts,
+		Seq:       7,
+		Metrics: []Metric{
+			{Name: "a/int8", Alias: 1, Timestamp: ts, DataType: TypeInt8, Value: int8(-5)},
+			{Name: "a/int32", Alias: 2, DataType: TypeInt32, Value: int32(-70000)},
+			{Name: "a/int64", DataType: TypeInt64, Value: int64(-1 << 40)},
+			{Name: "a/uint64", DataType: TypeUInt64, Value: uint64(1 << 63)},
+			{Name: "a/float", DataType: TypeFloat, Value: float32(1.5)},
+			{Name: "a/double", DataType: TypeDouble, Value: 2.25},
+			{Name: "a/bool", DataType: TypeBoolean, Value: true},
+			{Name: "a/string", DataType: TypeString, Value: "on"},
+			{Name: "a/time", DataType: TypeDateTime, Value: ts},
+			{Name: "a/null", DataType: TypeDouble, Properties: map[string]interface{}{QualityProperty: QualityBad}},
+			{Name: "a/props", DataType: TypeBoolean, Value: false, Properties: map[string]interface{}{
+				"i": int32(-1), "l": int64(3), "d": 0.5, "b": true, "s": "x",
+			}},
+		},
+	}
+	data, err := payload.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Timestamp.Equal(ts) || decoded.Seq != 7 || len(decoded.Metrics) != len(payload.Metrics) {
+		t.Fatalf("wrong payload: %+v", decoded)
+	}
+	for i, m := range decoded.Metrics {
+		expected := payload.Metrics[i]
+		if m.Name != expected.Name || m.Alias != expected.Alias || m.DataType != expected.DataType {
+			t.Errorf("wrong metric %+v", m)
+		}
+		if v, ok := expected.Value.(time.Time); ok {
+			if !v.Equal(m.Value.(time.Time)) {
+				t.Errorf("%s: expected %v, got %v", m.Name, v, m.Value)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(m.Value, expected.Value) {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", m.Name, expected.Value, expected.Value, m.Value, m.Value)
+		}
+		if expected.Properties != nil && !reflect.DeepEqual(m.Properties, expected.Properties) {
+			t.Errorf("%s: expected properties %v, got %v", m.Name, expected.Properties, m.Properties)
+		}
+	}
+
+	if _, err := (Payload{Metrics: []Metric{{DataType: TypeBoolean, Value: "maybe"}}}).Marshal(); err == nil {
+		t.Error("expected conversion error")
+	}
+}
+
+func TestEdgeNode(t *testing.T) {
+	node, err := NewEdgeNode(Config{GroupID: "plant", EdgeNodeID: "opc"}, []string{"numeric.sin", "numeric.cos", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	items := map[string]opc.Item{
+		"numeric.sin": {Value: 0.5, Quality: opc.OPCQualityGood, Timestamp: now},
+		"numeric.cos": {Value: int32(1), Quality: opc.OPCQualityUncertain, Timestamp: now},
+	}
+
+	death, err := node.Death()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deathPayload, _ := Unmarshal(death.Payload)
+	if death.Topic != "spBv1.0/plant/NDEATH/opc" || death.QoS != 1 || deathPayload.Seq != -1 || deathPayload.Metrics[0].Name != BdSeqMetric {
+		t.Errorf("wrong death certificate: %s %+v", death.Topic, deathPayload)
+	}
+
+	births, err := node.Birth(items, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	birth, _ := Unmarshal(births[0].Payload)
+	if len(births) != 1 || births[0].Topic != "spBv1.0/plant/NBIRTH/opc" || birth.Seq != 0 || len(birth.Metrics) != 5 {
+		t.Fatalf("wrong birth: %+v", birth)
+	}
+	if birth.Metrics[0].Value != deathPayload.Metrics[0].Value {
+		t.Error("bdSeq of birth and death differ")
+	}
+
+	// every connect starts a new session
+	reconnect, err := node.Reconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconnectPayload, _ := Unmarshal(reconnect.Payload)
+	if reconnectPayload.Metrics[0].Value != (deathPayload.Metrics[0].Value.(uint64)+1)%256 {
+		t.Errorf("bdSeq not incremented: %v", reconnectPayload.Metrics[0].Value)
+	}
+	births, _ = node.Birth(items, now)
+	if birth, _ := Unmarshal(births[0].Payload); birth.Metrics[0].Value != reconnectPayload.Metrics[0].Value {
+		t.Error("bdSeq of birth and reconnect differ")
+	}
+
+	metrics := map[string]Metric{}
+	for _, m := range birth.Metrics {
+		metrics[m.Name] = m
+	}
+	if m := metrics["numeric/cos"]; m.Alias != 2 || m.DataType != TypeInt32 || m.Properties[QualityProperty] != QualityStale {
+		t.Errorf("wrong birth metric: %+v", m)
+	}
+	if m := metrics["missing"]; m.Value != nil || m.DataType != TypeString || m.Properties[QualityProperty] != QualityBad {
+		t.Errorf("wrong metric without value: %+v", m)
+	}
+
+	msg, err := node.Data(map[string]opc.Item{"numeric.sin": {Value: 0.7, Quality: opc.OPCQualityGood}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := Unmarshal(msg.Payload)
+	if msg.Topic != "spBv1.0/plant/NDATA/opc" || data.Seq != 1 || len(data.Metrics) != 1 {
+		t.Fatalf("wrong data: %+v", data)
+	}
+	if m := data.Metrics[0]; m.Name != "" || m.Alias != 3 || m.Value != 0.7 {
+		t.Errorf("wrong data metric: %+v", m)
+	}
+
+	if _, err := node.Data(map[string]opc.Item{"numeric.sin": {Value: "text"}}, now); !errors.Is(err, ErrRebirth) {
+		t.Errorf("expected rebirth, got %v", err)
+	}
+}
+
+func TestEdgeNodeDevice(t *testing.T) {
+	node, err := NewEdgeNode(Config{GroupID: "plant", EdgeNodeID: "opc", DeviceID: "sim"}, []string{"numeric.sin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	births, err := node.Birth(map[string]opc.Item{"numeric.sin": {Value: 0.5}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(births) != 2 || births[1].Topic != "spBv1.0/plant/DBIRTH/opc/sim" {
+		t.Fatalf("wrong births: %+v", births)
+	}
+	dbirth, _ := Unmarshal(births[1].Payload)
+	if dbirth.Seq != 1 || len(dbirth.Metrics) != 1 {
+		t.Errorf("wrong device birth: %+v", dbirth)
+	}
+	msg, _ := node.Data(map[string]opc.Item{"numeric.sin": {Value: 0.6}}, time.Now())
+	if msg.Topic != "spBv1.0/plant/DDATA/opc/sim" {
+		t.Errorf("wrong device data topic %s", msg.Topic)
+	}
+	if topics := node.CommandTopics(); len(topics) != 2 || topics[1] != "spBv1.0/plant/DCMD/opc/sim" {
+		t.Errorf("wrong command topics %v", topics)
+	}
+
+	for _, cfg := range []Config{{GroupID: "plant"}, {GroupID: "a/b", EdgeNodeID: "opc"}} {
+		if _, err := NewEdgeNode(cfg, nil); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	node, _ := NewEdgeNode(Config{GroupID: "plant", EdgeNodeID: "opc"}, []string{"a.setpoint", "b.mode"})
+	data, _ := Payload{Seq: -1, Metrics: []Metric{
+		{Name: RebirthMetric, DataType: TypeBoolean, Value: true},
+		{Name: "a/setpoint", DataType: TypeDouble, Value: 42.0},
+		{Alias: 2, DataType: TypeInt32, Value: int32(3)},
+		{Name: "unknown", DataType: TypeBoolean, Value: true},
+	}}.Marshal()
+
+	cmd, err := node.Command("spBv1.0/plant/NCMD/opc", data)
+	if err == nil {
+		t.Error("expected error for unknown metric")
+	}
+	if !cmd.Rebirth || cmd.Writes["a.setpoint"] != 42.0 || cmd.Writes["b.mode"] != int32(3) || len(cmd.Writes) != 2 {
+		t.Errorf("wrong command: %+v", cmd)
+	}
+	if _, err := node.Command("spBv1.0/plant/NCMD/other", data); err == nil {
+		t.Error("expected error for command of other node")
+	}
+}
+
+func TestQuality(t *testing.T) {
+	if Quality(opc.OPCQualityGood) != QualityGood || Quality(opc.OPCQualityUncertain) != QualityStale || Quality(opc.OPCQualityBad) != QualityBad {
+		t.Error("wrong quality mapping")
+	}
+}
+
+func TestBdSeqFile(t *testing.T) {
+	cfg := Config{GroupID: "plant", EdgeNodeID: "opc", BdSeqFile: filepath.Join(t.TempDir(), "bdseq")}
+	bdSeq := func(n *EdgeNode) interface{} {
+		m, _ := n.Death()
+		p, _ := Unmarshal(m.Payload)
+		return p.Metrics[0].Value
+	}
+
+	// the first session starts at 0 and a restart continues after the last session
+	node, err := NewEdgeNode(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bdSeq(node) != uint64(0) {
+		t.Errorf("wrong first bdSeq %v", bdSeq(node))
+	}
+	node.Reconnect()
+	if node, err = NewEdgeNode(cfg, nil); err != nil || bdSeq(node) != uint64(2) {
+		t.Errorf("wrong bdSeq after restart %v: %v", bdSeq(node), err)
+	}
+
+	os.WriteFile(cfg.BdSeqFile, []byte("300"), 0o644)
+	if _, err := NewEdgeNode(cfg, nil); err == nil {
+		t.Error("expected error for invalid bdSeq")
+	}
+}
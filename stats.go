@@ -0,0 +1,164 @@
+package opc
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//TreeStats summarizes the address space of a tree.
+type TreeStats struct {
+	Branches         int
+	Leaves           int
+	MaxDepth         int
+	Depths           []int               // number of leaves per depth, the leaves of the root are at depth 1
+	LeavesPerBranch  map[string]int      // number of leaves per branch path with leaves
+	Duplicates       map[string][]string // item IDs with the paths of all their leaves
+	NamingViolations []string            // paths of branches and leaves violating the naming convention
+	DataTypes        map[string]int      // number of leaves per data type, "unknown" without properties; empty if no leaf has properties
+}
+
+//Stats collects the statistics of the tree. If naming is not nil, the names
+//of all branches and leaves are checked against it.
+func (t *Tree) Stats(naming *regexp.Regexp) *TreeStats {
+	s := &TreeStats{
+		LeavesPerBranch: map[string]int{},
+		Duplicates:      map[string][]string{},
+		DataTypes:       map[string]int{},
+	}
+	paths := map[string][]string{}
+	unknown := 0
+	t.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		if path == "" {
+			if len(branch.Leaves) > 0 {
+				s.LeavesPerBranch[path] = len(branch.Leaves)
+			}
+			return nil
+		}
+		depth := strings.Count(path, PathSeparator) + 1
+		if depth > s.MaxDepth {
+			s.MaxDepth = depth
+		}
+		if naming != nil {
+			name := branch.Name
+			if leaf != nil {
+				name = leaf.Name
+			}
+			if !naming.MatchString(name) {
+				s.NamingViolations = append(s.NamingViolations, path)
+			}
+		}
+		if leaf == nil {
+			s.Branches++
+			if len(branch.Leaves) > 0 {
+				s.LeavesPerBranch[path] = len(branch.Leaves)
+			}
+			return nil
+		}
+
+		s.Leaves++
+		for len(s.Depths) <= depth {
+			s.Depths = append(s.Depths, 0)
+		}
+		s.Depths[depth]++
+		paths[leaf.Tag] = append(paths[leaf.Tag], path)
+		if leaf.Info != nil {
+			s.DataTypes[leaf.Info.DataType.String()]++
+		} else {
+			unknown++
+		}
+		return nil
+	})
+	//without loaded properties, the data types are not known at all
+	if len(s.DataTypes) > 0 && unknown > 0 {
+		s.DataTypes["unknown"] = unknown
+	}
+	for tag, p := range paths {
+		if len(p) > 1 {
+			s.Duplicates[tag] = p
+		}
+	}
+	return s
+}
+
+//WriteTo writes a readable report of the statistics. Only the branches
+//with the most leaves are listed.
+func (s *TreeStats) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Branches:  %d\n", s.Branches)
+	fmt.Fprintf(&b, "Tags:      %d\n", s.Leaves)
+	fmt.Fprintf(&b, "Max depth: %d\n", s.MaxDepth)
+
+	b.WriteString("\nTags per depth:\n")
+	for depth, n := range s.Depths {
+		if depth > 0 {
+			fmt.Fprintf(&b, "  %3d: %d\n", depth, n)
+		}
+	}
+
+	if len(s.LeavesPerBranch) > 0 {
+		branches := make([]string, 0, len(s.LeavesPerBranch))
+		for path := range s.LeavesPerBranch {
+			branches = append(branches, path)
+		}
+		sort.Slice(branches, func(i, j int) bool {
+			if s.LeavesPerBranch[branches[i]] != s.LeavesPerBranch[branches[j]] {
+				return s.LeavesPerBranch[branches[i]] > s.LeavesPerBranch[branches[j]]
+			}
+			return branches[i] < branches[j]
+		})
+		fmt.Fprintf(&b, "\nTags per branch: %.1f on average in %d branches\n", float64(s.Leaves)/float64(len(branches)), len(branches))
+		for i, path := range branches {
+			if i == 10 {
+				fmt.Fprintf(&b, "  ... %d more\n", len(branches)-i)
+				break
+			}
+			name := path
+			if name == "" {
+				name = "(root)"
+			}
+			fmt.Fprintf(&b, "  %6d  %s\n", s.LeavesPerBranch[path], name)
+		}
+	}
+
+	if len(s.DataTypes) > 0 {
+		b.WriteString("\nData types:\n")
+		for _, name := range sortedKeys(s.DataTypes) {
+			fmt.Fprintf(&b, "  %6d  %s\n", s.DataTypes[name], name)
+		}
+	}
+
+	if len(s.Duplicates) > 0 {
+		fmt.Fprintf(&b, "\nDuplicate item IDs: %d\n", len(s.Duplicates))
+		tags := make([]string, 0, len(s.Duplicates))
+		for tag := range s.Duplicates {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			fmt.Fprintf(&b, "  %s: %s\n", tag, strings.Join(s.Duplicates[tag], ", "))
+		}
+	}
+
+	if len(s.NamingViolations) > 0 {
+		fmt.Fprintf(&b, "\nNaming violations: %d\n", len(s.NamingViolations))
+		for _, path := range s.NamingViolations {
+			fmt.Fprintf(&b, "  %s\n", path)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+//sortedKeys returns the keys of the map in alphabetical order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,56 @@
+package opc
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTreeStats(t *testing.T) {
+	tree := testingCreateNewTree()
+	tree.Branches[1].Leaves = append(tree.Branches[1].Leaves, Leaf{Name: "Sin", Tag: "numeric.sin"})
+	tree.Branches[1].Leaves[0].Info = &TagInfo{Tag: "numeric.sin", DataType: VTFloat64}
+
+	s := tree.Stats(regexp.MustCompile(`^[a-z]+$`))
+	if s.Branches != 2 || s.Leaves != 7 || s.MaxDepth != 2 {
+		t.Errorf("wrong counts: %d branches, %d leaves, depth %d", s.Branches, s.Leaves, s.MaxDepth)
+	}
+	if !reflect.DeepEqual(s.Depths, []int{0, 1, 6}) {
+		t.Errorf("wrong depth histogram: %v", s.Depths)
+	}
+	if !reflect.DeepEqual(s.LeavesPerBranch, map[string]int{"": 1, "options": 2, "numeric": 4}) {
+		t.Errorf("wrong leaves per branch: %v", s.LeavesPerBranch)
+	}
+	if !reflect.DeepEqual(s.Duplicates, map[string][]string{"numeric.sin": {"numeric/sin", "numeric/Sin"}}) {
+		t.Errorf("wrong duplicates: %v", s.Duplicates)
+	}
+	if !reflect.DeepEqual(s.NamingViolations, []string{"numeric/Sin"}) {
+		t.Errorf("wrong naming violations: %v", s.NamingViolations)
+	}
+	if !reflect.DeepEqual(s.DataTypes, map[string]int{"float64": 1, "unknown": 6}) {
+		t.Errorf("wrong data types: %v", s.DataTypes)
+	}
+
+	var buf bytes.Buffer
+	s.WriteTo(&buf)
+	for _, line := range []string{"Tags:      7\n", "       4  numeric\n", "numeric.sin: numeric/sin, numeric/Sin\n", "Naming violations: 1\n"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("missing %q in report:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestTreeStatsWithoutNaming(t *testing.T) {
+	s := testingCreateNestedTree().Stats(nil)
+	if s.NamingViolations != nil || len(s.Duplicates) != 0 {
+		t.Error("expected no violations and duplicates")
+	}
+	if s.MaxDepth != len(s.Depths)-1 {
+		t.Errorf("depth histogram does not match max depth %d: %v", s.MaxDepth, s.Depths)
+	}
+	if len(s.DataTypes) != 0 {
+		t.Errorf("data types without properties: %v", s.DataTypes)
+	}
+}
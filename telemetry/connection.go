@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+
+	"github.com/konimarti/opc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attribute keys of the spans
+const (
+	TagKey         = attribute.Key("opc.tag")
+	TagCountKey    = attribute.Key("opc.tag.count")
+	FailedCountKey = attribute.Key("opc.tag.failed")
+)
+
+// errNoItem marks a span of a tag that could not be read
+var errNoItem = errors.New("telemetry: no item returned")
+
+// tracedConnection creates spans for the operations of the embedded Connection
+type tracedConnection struct {
+	opc.Connection
+	tracer trace.Tracer
+	ctx    context.Context // parent of the spans
+}
+
+// TraceConnection returns a Connection that creates a span for every Read, ReadItem,
+// Write, Add and Remove of conn with the number of tags and the errors.
+// The global tracer provider is used if tp is nil. The spans are roots unless the
+// connection is bound to the context of the caller with opc.WithContext.
+func TraceConnection(conn opc.Connection, tp trace.TracerProvider) opc.Connection {
+	return &tracedConnection{Connection: conn, tracer: tracer(tp), ctx: context.Background()}
+}
+
+// WithContext returns a copy of the connection that creates the spans as children of ctx
+func (c *tracedConnection) WithContext(ctx context.Context) opc.Connection {
+	return &tracedConnection{Connection: c.Connection, tracer: c.tracer, ctx: ctx}
+}
+
+func (c *tracedConnection) Read() map[string]opc.Item {
+	_, span := c.tracer.Start(c.ctx, "opc.Read")
+	defer span.End()
+	items := c.Connection.Read()
+	tags := c.Connection.Tags()
+	failed := 0
+	for _, tag := range tags {
+		if _, ok := items[tag]; !ok {
+			failed++
+		}
+	}
+	span.SetAttributes(TagCountKey.Int(len(items)), FailedCountKey.Int(failed))
+	if failed > 0 {
+		setError(span, errNoItem)
+	}
+	return items
+}
+
+func (c *tracedConnection) ReadItem(tag string) opc.Item {
+	_, span := c.tracer.Start(c.ctx, "opc.ReadItem", trace.WithAttributes(TagKey.String(tag)))
+	defer span.End()
+	item := c.Connection.ReadItem(tag)
+	if item.Value == nil && item.Timestamp.IsZero() {
+		setError(span, errNoItem)
+	}
+	return item
+}
+
+func (c *tracedConnection) Write(tag string, value interface{}) error {
+	_, span := c.tracer.Start(c.ctx, "opc.Write", trace.WithAttributes(TagKey.String(tag)))
+	defer span.End()
+	err := c.Connection.Write(tag, value)
+	setError(span, err)
+	return err
+}
+
+func (c *tracedConnection) Add(tags ...string) error {
+	_, span := c.tracer.Start(c.ctx, "opc.Add", trace.WithAttributes(TagCountKey.Int(len(tags))))
+	defer span.End()
+	err := c.Connection.Add(tags...)
+	setError(span, err)
+	return err
+}
+
+func (c *tracedConnection) Remove(tag string) {
+	_, span := c.tracer.Start(c.ctx, "opc.Remove", trace.WithAttributes(TagKey.String(tag)))
+	defer span.End()
+	c.Connection.Remove(tag)
+}
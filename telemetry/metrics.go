@@ -0,0 +1,169 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/konimarti/opc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attribute keys of the metrics
+const (
+	StatusKey    = attribute.Key("status")
+	ComponentKey = attribute.Key("component")
+	OperationKey = attribute.Key("operation")
+)
+
+// otelMetrics implements opc.Metrics with OpenTelemetry instruments
+type otelMetrics struct {
+	tracer            trace.Tracer
+	reads             metric.Int64Counter
+	readDuration      metric.Float64Histogram
+	badQualityReads   metric.Int64Counter
+	writes            metric.Int64Counter
+	writeDuration     metric.Float64Histogram
+	reconnects        metric.Int64Counter
+	reconnectDuration metric.Float64Histogram
+	connected         metric.Int64Gauge
+	activeItems       metric.Int64Gauge
+	browseDuration    metric.Float64Histogram
+	requests          metric.Int64Counter
+	requestDuration   metric.Float64Histogram
+	backlogRecords    metric.Int64Gauge
+	backlogBytes      metric.Int64Gauge
+}
+
+// NewMetrics returns opc.Metrics that records the metrics of the package with the
+// meter provider and creates spans for reconnects and browsing. The global providers
+// are used if mp or tp are nil. Combine it with opc.PrometheusMetrics by
+// opc.MultiMetrics to keep the Prometheus metrics.
+func NewMetrics(mp metric.MeterProvider, tp trace.TracerProvider) (opc.Metrics, error) {
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	m := &otelMetrics{tracer: tracer(tp)}
+
+	var err error
+	counters := []struct {
+		c           *metric.Int64Counter
+		name, descr string
+	}{
+		{&m.reads, "opc.reads", "Number of OPC tags read."},
+		{&m.badQualityReads, "opc.reads.bad_quality", "Number of reads with bad quality."},
+		{&m.writes, "opc.writes", "Number of OPC tags written."},
+		{&m.reconnects, "opc.reconnects", "Number of attempts to reconnect to the OPC server."},
+		{&m.requests, "opc.requests", "Number of requests of the API and the bridges."},
+	}
+	for _, c := range counters {
+		if *c.c, err = meter.Int64Counter(c.name, metric.WithDescription(c.descr)); err != nil {
+			return nil, err
+		}
+	}
+	histograms := []struct {
+		h           *metric.Float64Histogram
+		name, descr string
+	}{
+		{&m.readDuration, "opc.read.duration", "Read duration from the OPC server."},
+		{&m.writeDuration, "opc.write.duration", "Write duration to the OPC server."},
+		{&m.reconnectDuration, "opc.reconnect.duration", "Duration of the attempts to reconnect to the OPC server."},
+		{&m.browseDuration, "opc.browse.duration", "Duration of browsing the OPC server."},
+		{&m.requestDuration, "opc.request.duration", "Duration of the requests of the API and the bridges."},
+	}
+	for _, h := range histograms {
+		if *h.h, err = meter.Float64Histogram(h.name, metric.WithDescription(h.descr), metric.WithUnit("s")); err != nil {
+			return nil, err
+		}
+	}
+	if m.connected, err = meter.Int64Gauge("opc.connected", metric.WithDescription("State of the connection to the OPC server (1 = connected).")); err != nil {
+		return nil, err
+	}
+	if m.activeItems, err = meter.Int64Gauge("opc.active_items", metric.WithDescription("Number of OPC items added to the connection.")); err != nil {
+		return nil, err
+	}
+	if m.backlogRecords, err = meter.Int64Gauge("opc.backlog.records", metric.WithDescription("Number of records buffered by a bridge while the sink is unreachable.")); err != nil {
+		return nil, err
+	}
+	if m.backlogBytes, err = meter.Int64Gauge("opc.backlog.size", metric.WithDescription("Bytes buffered by a bridge while the sink is unreachable."), metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *otelMetrics) ObserveRead(items map[string]opc.Item, failed []string, d time.Duration) {
+	ctx := context.Background()
+	m.readDuration.Record(ctx, d.Seconds())
+	if len(items) > 0 {
+		m.reads.Add(ctx, int64(len(items)), metric.WithAttributes(StatusKey.String("success")))
+	}
+	if len(failed) > 0 {
+		m.reads.Add(ctx, int64(len(failed)), metric.WithAttributes(StatusKey.String("failed")))
+	}
+	for tag, item := range items {
+		if item.Quality.Bad() {
+			m.badQualityReads.Add(ctx, 1, metric.WithAttributes(TagKey.String(tag)))
+		}
+	}
+}
+
+func (m *otelMetrics) ObserveWrite(tag string, d time.Duration, err error) {
+	ctx := context.Background()
+	m.writeDuration.Record(ctx, d.Seconds())
+	m.writes.Add(ctx, 1, metric.WithAttributes(StatusKey.String(status(err))))
+}
+
+func (m *otelMetrics) ObserveReconnect(d time.Duration, err error) {
+	ctx := context.Background()
+	m.reconnectDuration.Record(ctx, d.Seconds())
+	m.reconnects.Add(ctx, 1, metric.WithAttributes(StatusKey.String(status(err))))
+	m.span("opc.Reconnect", d, err)
+}
+
+func (m *otelMetrics) SetConnected(connected bool) {
+	var v int64
+	if connected {
+		v = 1
+	}
+	m.connected.Record(context.Background(), v)
+}
+
+func (m *otelMetrics) SetActiveItems(n int) {
+	m.activeItems.Record(context.Background(), int64(n))
+}
+
+func (m *otelMetrics) ObserveBrowse(d time.Duration, err error) {
+	m.browseDuration.Record(context.Background(), d.Seconds(), metric.WithAttributes(StatusKey.String(status(err))))
+	m.span("opc.Browse", d, err)
+}
+
+func (m *otelMetrics) ObserveRequest(component, operation, status string, d time.Duration) {
+	ctx := context.Background()
+	m.requests.Add(ctx, 1, metric.WithAttributes(ComponentKey.String(component), OperationKey.String(operation), StatusKey.String(status)))
+	m.requestDuration.Record(ctx, d.Seconds(), metric.WithAttributes(ComponentKey.String(component), OperationKey.String(operation)))
+}
+
+func (m *otelMetrics) SetBacklog(component string, records int, bytes int64) {
+	ctx := context.Background()
+	m.backlogRecords.Record(ctx, int64(records), metric.WithAttributes(ComponentKey.String(component)))
+	m.backlogBytes.Record(ctx, bytes, metric.WithAttributes(ComponentKey.String(component)))
+}
+
+// span records a span that ended now and took d
+func (m *otelMetrics) span(name string, d time.Duration, err error) {
+	end := time.Now()
+	_, span := m.tracer.Start(context.Background(), name, trace.WithTimestamp(end.Add(-d)))
+	setError(span, err)
+	span.End(trace.WithTimestamp(end))
+}
+
+// status returns "success" or "failed" like the Prometheus metrics
+func status(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "success"
+}
@@ -0,0 +1,147 @@
+// Package telemetry adds OpenTelemetry tracing and metrics to OPC connections,
+// the REST API and the bridges. The data is exported with OTLP over HTTP to a
+// collector or written to stdout for testing.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/konimarti/opc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracers and meters of this package
+const instrumentationName = "github.com/konimarti/opc"
+
+// Exporter names for Config
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Config selects the exporter for traces and metrics. It can be used directly
+// in the config files of the applications.
+type Config struct {
+	Exporter    string `yaml:"exporter" toml:"exporter" json:"exporter"`             // "otlp", "stdout" or "" to disable telemetry
+	Endpoint    string `yaml:"endpoint" toml:"endpoint" json:"endpoint"`             // OTLP/HTTP endpoint, default "localhost:4318"
+	Insecure    bool   `yaml:"insecure" toml:"insecure" json:"insecure"`             // use HTTP instead of HTTPS for OTLP
+	ServiceName string `yaml:"service_name" toml:"service_name" json:"service_name"` // service.name of the resource
+}
+
+// Enabled returns true if an exporter is configured.
+func (c Config) Enabled() bool {
+	return c.Exporter != ExporterNone
+}
+
+// stdout is the writer of the stdout exporters
+var stdout io.Writer = os.Stdout
+
+// Setup installs the global tracer and meter providers and the W3C trace context
+// propagator with the exporter of the config and adds the OpenTelemetry metrics to
+// opc.DefaultMetrics. The returned function restores the previous opc.DefaultMetrics
+// and flushes and stops the exporters; it must be called before the application exits.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var spanExporter sdktrace.SpanExporter
+	var metricExporter sdkmetric.Exporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		traceOpts := []otlptracehttp.Option{}
+		metricOpts := []otlpmetrichttp.Option{}
+		if cfg.Endpoint != "" {
+			traceOpts = append(traceOpts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+			metricOpts = append(metricOpts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+			metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+		}
+		if spanExporter, err = otlptracehttp.New(ctx, traceOpts...); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = otlpmetrichttp.New(ctx, metricOpts...); err != nil {
+			return nil, err
+		}
+	case ExporterStdout:
+		if spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout)); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = stdoutmetric.New(stdoutmetric.WithWriter(stdout)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("telemetry: unknown exporter " + cfg.Exporter)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "opc"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	metrics, err := NewMetrics(mp, tp)
+	if err != nil {
+		return nil, err
+	}
+	previous := opc.DefaultMetrics
+	opc.DefaultMetrics = opc.MultiMetrics(previous, metrics)
+
+	return func(ctx context.Context) error {
+		opc.DefaultMetrics = previous
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}, nil
+}
+
+// tracer returns the tracer of the package from tp or the global provider if tp is nil
+func tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+// WithSpan runs fn in a new span of the global tracer provider, e.g. to trace
+// the publish path of a bridge. An error returned by fn is recorded in the span.
+func WithSpan(ctx context.Context, name string, fn func(context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := tracer(nil).Start(ctx, name, trace.WithAttributes(attrs...))
+	defer span.End()
+	err := fn(ctx)
+	setError(span, err)
+	return err
+}
+
+// setError records err in the span
+func setError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,186 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/konimarti/opc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// mockConnection returns a good item for every tag except "missing"
+type mockConnection struct {
+	tags []string
+}
+
+func (m *mockConnection) Add(tags ...string) error {
+	m.tags = append(m.tags, tags...)
+	return nil
+}
+func (m *mockConnection) Remove(string) {}
+func (m *mockConnection) Read() map[string]opc.Item {
+	items := make(map[string]opc.Item)
+	for _, tag := range m.tags {
+		if tag != "missing" {
+			items[tag] = opc.Item{Value: 1.0, Quality: opc.OPCQualityGood, Timestamp: time.Now()}
+		}
+	}
+	return items
+}
+func (m *mockConnection) ReadItem(tag string) opc.Item { return m.Read()[tag] }
+func (m *mockConnection) Tags() []string               { return m.tags }
+func (m *mockConnection) Write(tag string, value interface{}) error {
+	return errors.New("read only")
+}
+func (m *mockConnection) Properties(string) (opc.TagInfo, error) {
+	return opc.TagInfo{}, opc.ErrNoProperties
+}
+func (m *mockConnection) Close() {}
+
+func TestTraceConnection(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	conn := TraceConnection(&mockConnection{}, tp)
+	conn.Add("a", "b", "missing")
+	conn.Read()
+	conn.ReadItem("a")
+	conn.Write("a", 2.0)
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(spans))
+	}
+	names := []string{"opc.Add", "opc.Read", "opc.ReadItem", "opc.Write"}
+	for i, span := range spans {
+		if span.Name() != names[i] {
+			t.Errorf("expected span %s, got %s", names[i], span.Name())
+		}
+	}
+
+	attrs := map[string]int64{}
+	for _, kv := range spans[1].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInt64()
+	}
+	if attrs["opc.tag.count"] != 2 || attrs["opc.tag.failed"] != 1 || spans[1].Status().Code != codes.Error {
+		t.Errorf("wrong read span: %v, %v", attrs, spans[1].Status())
+	}
+	if spans[2].Status().Code == codes.Error {
+		t.Error("read of a good item marked as error")
+	}
+	if spans[3].Status().Code != codes.Error || len(spans[3].Events()) != 1 {
+		t.Error("write error not recorded")
+	}
+
+	// bound to the context of a request, the spans are children of its span
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "GET /tag/{id}")
+	opc.WithContext(ctx, conn).ReadItem("a")
+	parent.End()
+	spans = recorder.Ended()
+	if child := spans[len(spans)-2]; child.Name() != "opc.ReadItem" || child.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("span not a child of the request: %v", child.Parent())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	m, err := NewMetrics(mp, tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := opc.InstrumentConnection(&mockConnection{tags: []string{"a", "missing"}}, m)
+	conn.Read()
+	m.ObserveReconnect(time.Second, errors.New("server not available"))
+	m.SetConnected(true)
+
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, scope := range data.ScopeMetrics {
+		for _, metric := range scope.Metrics {
+			found[metric.Name] = true
+			if metric.Name == "opc.reads" {
+				sum := metric.Data.(metricdata.Sum[int64])
+				if len(sum.DataPoints) != 2 {
+					t.Errorf("expected success and failed reads, got %v", sum.DataPoints)
+				}
+			}
+		}
+	}
+	for _, name := range []string{"opc.reads", "opc.read.duration", "opc.active_items", "opc.reconnects", "opc.connected"} {
+		if !found[name] {
+			t.Errorf("metric %s not found", name)
+		}
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "opc.Reconnect" || spans[0].EndTime().Sub(spans[0].StartTime()) != time.Second {
+		t.Errorf("wrong reconnect span: %v", spans)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/tag/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	router.Use(Middleware(tp))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tag/numeric.sin", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "GET /tag/{id}" || spans[0].Status().Code != codes.Error {
+		t.Errorf("wrong server span: %v", spans)
+	}
+}
+
+func TestSetupStdout(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer, m opc.Metrics) { stdout, opc.DefaultMetrics = w, m }(stdout, opc.DefaultMetrics)
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	stdout = &buf
+
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterStdout, ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WithSpan(context.Background(), "mqtt.publish", func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "mqtt.publish") || !strings.Contains(buf.String(), "test") {
+		t.Errorf("span not exported: %s", buf.String())
+	}
+	if _, ok := opc.DefaultMetrics.(opc.PrometheusMetrics); !ok {
+		t.Errorf("default metrics not restored after shutdown: %T", opc.DefaultMetrics)
+	}
+
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Error("expected error for unknown exporter")
+	}
+}
@@ -1,11 +1,15 @@
 package opc
 
-import "fmt"
+import (
+	"errors"
+	"os"
+	"strings"
+)
 
 //Tree creates an OPC browser representation
 type Tree struct {
 	Name     string
-	Parent   *Tree
+	Parent   *Tree `json:"-" yaml:"-"`
 	Branches []*Tree
 	Leaves   []Leaf
 }
@@ -14,6 +18,38 @@ type Tree struct {
 type Leaf struct {
 	Name string
 	Tag  string
+	Info *TagInfo `json:",omitempty" yaml:",omitempty"`
+}
+
+//Properties returns the properties of the leaf. They are read with reader
+//unless they have already been loaded with LoadProperties.
+func (l *Leaf) Properties(reader PropertyReader) (TagInfo, error) {
+	if l.Info != nil {
+		return *l.Info, nil
+	}
+	return reader.Properties(l.Tag)
+}
+
+//LoadProperties reads the properties of all leaves in the tree and stores them in Leaf.Info
+func LoadProperties(tree *Tree, reader PropertyReader) error {
+	var errResult string
+	for i := range tree.Leaves {
+		info, err := reader.Properties(tree.Leaves[i].Tag)
+		if err != nil {
+			errResult = errResult + err.Error() + "\n"
+			continue
+		}
+		tree.Leaves[i].Info = &info
+	}
+	for _, b := range tree.Branches {
+		if err := LoadProperties(b, reader); err != nil {
+			errResult = errResult + err.Error() + "\n"
+		}
+	}
+	if errResult == "" {
+		return nil
+	}
+	return errors.New(strings.TrimSuffix(errResult, "\n"))
 }
 
 //ExtractBranchByName return substree with name
@@ -30,6 +66,143 @@ func ExtractBranchByName(tree *Tree, name string) *Tree {
 	return nil
 }
 
+//PathSeparator separates the branch names in a tree path
+const PathSeparator = "/"
+
+//SkipBranch is used as a return value from WalkFunc to indicate that
+//the branch in the call is to be skipped. If returned for a leaf, the
+//remaining leaves and sub-branches of the parent branch are skipped.
+var SkipBranch = errors.New("skip this branch")
+
+//WalkFunc is called by Walk for every branch and leaf of the tree.
+//For branches leaf is nil; for leaves branch is the parent branch.
+//The path is relative to the tree on which Walk is called.
+type WalkFunc func(path string, branch *Tree, leaf *Leaf) error
+
+//Path returns the names of the branches from the root to this branch
+//separated by PathSeparator. The path of the root is empty.
+func (t *Tree) Path() string {
+	if t.Parent == nil {
+		return ""
+	}
+	return joinPath(t.Parent.Path(), t.Name)
+}
+
+//Find returns the branch at the given path relative to t, e.g. "Line1/Pump3/Setpoints",
+//or nil if there is no such branch.
+func (t *Tree) Find(path string) *Tree {
+	branch := t
+	for _, name := range strings.Split(strings.Trim(path, PathSeparator), PathSeparator) {
+		if name == "" {
+			continue
+		}
+		branch = branch.child(name)
+		if branch == nil {
+			return nil
+		}
+	}
+	return branch
+}
+
+//child returns the direct sub-branch with the given name or nil
+func (t *Tree) child(name string) *Tree {
+	for _, b := range t.Branches {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+//LeafByTag returns the leaf with the given item ID and its parent branch.
+//It returns nil if the item ID is not in the tree.
+func (t *Tree) LeafByTag(tag string) (*Leaf, *Tree) {
+	for i := range t.Leaves {
+		if t.Leaves[i].Tag == tag {
+			return &t.Leaves[i], t
+		}
+	}
+	for _, b := range t.Branches {
+		if leaf, branch := b.LeafByTag(tag); leaf != nil {
+			return leaf, branch
+		}
+	}
+	return nil, nil
+}
+
+//Walk visits the tree depth-first: first the branch itself, then its leaves and then its
+//sub-branches. Walk stops at the first error returned by fn except for SkipBranch.
+func (t *Tree) Walk(fn WalkFunc) error {
+	err := t.walk("", fn)
+	if err == SkipBranch {
+		return nil
+	}
+	return err
+}
+
+//walk is the recursive helper function for Walk
+func (t *Tree) walk(path string, fn WalkFunc) error {
+	if err := fn(path, t, nil); err != nil {
+		return err
+	}
+	for i := range t.Leaves {
+		err := fn(joinPath(path, t.Leaves[i].Name), t, &t.Leaves[i])
+		if err == SkipBranch {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range t.Branches {
+		err := b.walk(joinPath(path, b.Name), fn)
+		if err != nil && err != SkipBranch {
+			return err
+		}
+	}
+	return nil
+}
+
+//joinPath appends name to path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + PathSeparator + name
+}
+
+//DefaultTagSeparators are the separators used by TreeFromTags if none are given
+const DefaultTagSeparators = "./:"
+
+//TreeFromTags builds a tree from a flat list of item IDs. Every character in
+//sep separates the branch names, e.g. "." or "./:". The last element of an item ID
+//is the name of the leaf; the leaf keeps the full item ID as tag.
+func TreeFromTags(tags []string, sep string) *Tree {
+	if sep == "" {
+		sep = DefaultTagSeparators
+	}
+	root := &Tree{Name: "root", Branches: []*Tree{}, Leaves: []Leaf{}}
+	for _, tag := range tags {
+		names := strings.FieldsFunc(tag, func(r rune) bool {
+			return strings.ContainsRune(sep, r)
+		})
+		if len(names) == 0 {
+			continue
+		}
+		branch := root
+		for _, name := range names[:len(names)-1] {
+			next := branch.child(name)
+			if next == nil {
+				next = &Tree{Name: name, Parent: branch, Branches: []*Tree{}, Leaves: []Leaf{}}
+				branch.Branches = append(branch.Branches, next)
+			}
+			branch = next
+		}
+		branch.Leaves = append(branch.Leaves, Leaf{Name: names[len(names)-1], Tag: tag})
+	}
+	return root
+}
+
 //CollectTags traverses tree and collects all tags in string slice
 func CollectTags(tree *Tree) []string {
 	collection := []string{}
@@ -43,23 +216,8 @@ func CollectTags(tree *Tree) []string {
 	return collection
 }
 
-//PrettyPrint prints tree in a nice format
+//PrettyPrint prints tree in a nice format to stdout. Use Tree.Render
+//to write to another writer or in other formats.
 func PrettyPrint(tree *Tree) {
-	fmt.Println(tree.Name)
-	printSubtree(tree, 1)
-}
-
-// printSubtree is a recursive helper function to traverse the tree
-func printSubtree(tree *Tree, level int) {
-	space := ""
-	for i := 0; i < level; i++ {
-		space += "  "
-	}
-	for _, l := range tree.Leaves {
-		fmt.Println(space, "-", l.Tag)
-	}
-	for _, b := range tree.Branches {
-		fmt.Println(space, "+", b.Name)
-		printSubtree(b, level+1)
-	}
+	tree.Render(os.Stdout, RenderOptions{ItemIDs: true})
 }
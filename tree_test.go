@@ -1,6 +1,8 @@
 package opc
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -74,3 +76,120 @@ func TestTreeCollectTags(t *testing.T) {
 		t.Fatal("not enough tags collected")
 	}
 }
+
+func testingCreateNestedTree() *Tree {
+	root := &Tree{Name: "root"}
+	for _, line := range []string{"Line1", "Line2"} {
+		l := &Tree{Name: line, Parent: root}
+		pump := &Tree{Name: "Pump3", Parent: l}
+		setpoints := &Tree{Name: "Setpoints", Parent: pump}
+		setpoints.Leaves = []Leaf{
+			{Name: "Speed", Tag: line + ".Pump3.Setpoints.Speed"},
+			{Name: "Pressure", Tag: line + ".Pump3.Setpoints.Pressure"},
+		}
+		pump.Branches = []*Tree{setpoints}
+		l.Branches = []*Tree{pump}
+		root.Branches = append(root.Branches, l)
+	}
+	return root
+}
+
+func TestTreeFindAndPath(t *testing.T) {
+	tree := testingCreateNestedTree()
+
+	branch := tree.Find("Line2/Pump3/Setpoints")
+	if branch == nil {
+		t.Fatal("branch not found")
+	}
+	if branch.Path() != "Line2/Pump3/Setpoints" {
+		t.Fatalf("wrong path: %s", branch.Path())
+	}
+	if branch.Leaves[0].Tag != "Line2.Pump3.Setpoints.Speed" {
+		t.Fatal("found the wrong branch")
+	}
+	if tree.Find("") != tree || tree.Find("/Line1/") != tree.Branches[0] {
+		t.Fatal("empty path or separators not handled")
+	}
+	if tree.Find("Line1/Pump4") != nil {
+		t.Fatal("non-existing branch should not be found")
+	}
+	if tree.Path() != "" {
+		t.Fatal("root should have an empty path")
+	}
+}
+
+func TestTreeLeafByTag(t *testing.T) {
+	tree := testingCreateNestedTree()
+
+	leaf, branch := tree.LeafByTag("Line1.Pump3.Setpoints.Pressure")
+	if leaf == nil || leaf.Name != "Pressure" || branch.Path() != "Line1/Pump3/Setpoints" {
+		t.Fatal("leaf not found by tag")
+	}
+	if leaf, _ = tree.LeafByTag("Line3.Pump3.Setpoints.Pressure"); leaf != nil {
+		t.Fatal("non-existing tag should not be found")
+	}
+}
+
+func TestTreeWalk(t *testing.T) {
+	tree := testingCreateNestedTree()
+
+	var paths []string
+	err := tree.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		if leaf == nil && branch.Name == "Line2" {
+			return SkipBranch
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "Line1", "Line1/Pump3", "Line1/Pump3/Setpoints",
+		"Line1/Pump3/Setpoints/Speed", "Line1/Pump3/Setpoints/Pressure"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got %v, want %v", paths, want)
+	}
+
+	errStop := errors.New("stop")
+	count := 0
+	err = tree.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		count++
+		if leaf != nil {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || count != 5 {
+		t.Fatalf("walk should stop at the first leaf: %v after %d calls", err, count)
+	}
+}
+
+func TestTreeFromTags(t *testing.T) {
+	tags := []string{
+		"numeric.sin.float",
+		"numeric.sin.int32",
+		"numeric.saw.float",
+		"Line1/Pump3:Speed",
+		"bandwidth",
+	}
+	tree := TreeFromTags(tags, "")
+
+	if !reflect.DeepEqual(CollectTags(tree), []string{"bandwidth", "numeric.sin.float", "numeric.sin.int32", "numeric.saw.float", "Line1/Pump3:Speed"}) {
+		t.Fatalf("tags not preserved: %v", CollectTags(tree))
+	}
+	branch := tree.Find("numeric/sin")
+	if branch == nil || len(branch.Leaves) != 2 || branch.Leaves[1].Name != "int32" {
+		t.Fatal("branch numeric/sin not built correctly")
+	}
+	if branch.Parent.Parent != tree || branch.Path() != "numeric/sin" {
+		t.Fatal("parent links not set")
+	}
+	if leaf, b := tree.LeafByTag("Line1/Pump3:Speed"); leaf == nil || leaf.Name != "Speed" || b.Path() != "Line1/Pump3" {
+		t.Fatal("mixed separators not handled")
+	}
+
+	tree = TreeFromTags(tags, ".")
+	if tree.Find("numeric").child("sin") == nil || len(tree.Leaves) != 2 || tree.Leaves[0].Name != "Line1/Pump3:Speed" {
+		t.Fatal("custom separator not handled")
+	}
+}
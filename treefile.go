@@ -0,0 +1,152 @@
+package opc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//UnmarshalJSON decodes the tree and restores the Parent links.
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	type plain Tree
+	if err := json.Unmarshal(data, (*plain)(t)); err != nil {
+		return err
+	}
+	t.linkBranches()
+	return nil
+}
+
+//UnmarshalYAML decodes the tree and restores the Parent links.
+func (t *Tree) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Tree
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+	t.linkBranches()
+	return nil
+}
+
+//linkBranches sets the Parent of the direct sub-branches to t
+func (t *Tree) linkBranches() {
+	if t.Branches == nil {
+		t.Branches = []*Tree{}
+	}
+	if t.Leaves == nil {
+		t.Leaves = []Leaf{}
+	}
+	for _, b := range t.Branches {
+		b.Parent = t
+	}
+}
+
+//WriteJSON writes the tree as indented JSON.
+func (t *Tree) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(t)
+}
+
+//WriteYAML writes the tree as YAML.
+func (t *Tree) WriteYAML(w io.Writer) error {
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+//WriteCSV writes a flat list of all leaves with the columns path, name and item_id.
+//The path contains the branches and the name of the leaf.
+func (t *Tree) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"path", "name", "item_id"}); err != nil {
+		return err
+	}
+	err := t.Walk(func(path string, branch *Tree, leaf *Leaf) error {
+		if leaf == nil {
+			return nil
+		}
+		return writer.Write([]string{path, leaf.Name, leaf.Tag})
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//ReadTreeJSON reads a tree written by WriteJSON.
+func ReadTreeJSON(r io.Reader) (*Tree, error) {
+	var tree Tree
+	if err := json.NewDecoder(r).Decode(&tree); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+//ReadTreeYAML reads a tree written by WriteYAML.
+func ReadTreeYAML(r io.Reader) (*Tree, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var tree Tree
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+//SaveTree writes a snapshot of the tree to a file. The format is chosen
+//by the extension: .json, .yml/.yaml or .csv (export only).
+func SaveTree(filename string, tree *Tree) error {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		write = tree.WriteJSON
+	case ".yml", ".yaml":
+		write = tree.WriteYAML
+	case ".csv":
+		write = tree.WriteCSV
+	default:
+		return errors.New("opc: unknown tree file format " + filename)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//LoadTree reads a snapshot of a tree saved by SaveTree in JSON or YAML format.
+func LoadTree(filename string) (*Tree, error) {
+	var read func(io.Reader) (*Tree, error)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		read = ReadTreeJSON
+	case ".yml", ".yaml":
+		read = ReadTreeYAML
+	default:
+		return nil, errors.New("opc: cannot load tree from " + filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(f)
+}
@@ -0,0 +1,69 @@
+package opc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTreeRoundTrip(t *testing.T) {
+	tree := testingCreateNestedTree()
+	tree.Branches[0].Leaves = append(tree.Branches[0].Leaves, Leaf{
+		Name: "Mode",
+		Tag:  "Line1.Mode",
+		Info: &TagInfo{Tag: "Line1.Mode", DataType: VTString, AccessRights: OPCReadable},
+	})
+
+	dir := t.TempDir()
+	for _, name := range []string{"tree.json", "tree.yml"} {
+		filename := filepath.Join(dir, name)
+		if err := SaveTree(filename, tree); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadTree(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(CollectTags(loaded), CollectTags(tree)) {
+			t.Fatalf("%s: tags not restored", name)
+		}
+		branch := loaded.Find("Line2/Pump3/Setpoints")
+		if branch == nil || branch.Path() != "Line2/Pump3/Setpoints" || branch.Parent.Parent.Parent != loaded {
+			t.Fatalf("%s: parent links not restored", name)
+		}
+		leaf, _ := loaded.LeafByTag("Line1.Mode")
+		if leaf == nil || leaf.Info == nil || *leaf.Info != *tree.Branches[0].Leaves[0].Info {
+			t.Fatalf("%s: leaf properties not restored", name)
+		}
+	}
+}
+
+func TestTreeWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testingCreateNewTree().WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("expected header and 6 leaves, got %d lines", len(lines))
+	}
+	if lines[0] != "path,name,item_id" || lines[2] != "options/frequency,frequency,options.frequency" {
+		t.Fatalf("unexpected CSV output:\n%s", buf.String())
+	}
+}
+
+func TestLoadTreeErrors(t *testing.T) {
+	if _, err := LoadTree("tree.csv"); err == nil {
+		t.Fatal("CSV cannot be loaded")
+	}
+	if err := SaveTree(filepath.Join(t.TempDir(), "tree.txt"), &Tree{}); err == nil {
+		t.Fatal("unknown format should return an error")
+	}
+	if _, err := LoadTree(filepath.Join(os.TempDir(), "does-not-exist.json")); err == nil {
+		t.Fatal("missing file should return an error")
+	}
+}
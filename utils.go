@@ -1,30 +1,87 @@
 package opc
 
 import (
+	"errors"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 )
 
-var logger *log.Logger
+//Log formats for NewLogger
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+var logger *slog.Logger
 
 // Default is no logger
 func init() {
-	logger = newLogger(ioutil.Discard)
+	logger = newLogger(io.Discard, slog.LevelError+1)
 }
 
-//Debug will set the logger to print to stderr
+//Debug will set the logger to print debug messages to stderr
 func Debug() {
-	logger = newLogger(os.Stderr)
+	logger = newLogger(os.Stderr, slog.LevelDebug)
 }
 
 //SetLogWriter sets a user-defined writer for logger
 func SetLogWriter(w io.Writer) {
-	logger = newLogger(w)
+	logger = newLogger(w, slog.LevelDebug)
+}
+
+//SetLogger sets the structured logger of the package. A nil logger disables logging.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = newLogger(io.Discard, slog.LevelError+1)
+	}
+	logger = l
+}
+
+//Logger returns the logger of the package, e.g. to log with the same handler in applications.
+func Logger() *slog.Logger {
+	return logger
+}
+
+//NewLogger creates a slog.Logger writing to w with the minimum level and
+//the format LogText or LogJSON.
+func NewLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case LogText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, errors.New("unknown log format " + format)
+}
+
+//ParseLogLevel parses debug, info, warn or error.
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+//ConfigureLogging sets the logger of the package and the default slog logger to
+//write to stderr with a level and format given as strings, e.g. by command line flags.
+func ConfigureLogging(level, format string) error {
+	lvl, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	l, err := NewLogger(os.Stderr, lvl, format)
+	if err != nil {
+		return err
+	}
+	SetLogger(l)
+	slog.SetDefault(l)
+	return nil
 }
 
-//newLogger creats a log.Logger with standard settings
-func newLogger(w io.Writer) *log.Logger {
-	return log.New(w, "OPC ", log.LstdFlags)
+//newLogger creats a slog.Logger with standard settings
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	l, _ := NewLogger(w, level, LogText)
+	return l
 }
@@ -0,0 +1,57 @@
+package opc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	defer SetLogger(nil)
+
+	var buf bytes.Buffer
+	l, err := NewLogger(&buf, slog.LevelDebug, LogJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLogger(l)
+	if _, err := Browse(context.Background(), NewTreeBrowser(testingCreateNestedTree()), BrowseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	line := strings.SplitN(buf.String(), "\n", 2)[0]
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("no json log record: %s", line)
+	}
+	if record["level"] != "DEBUG" || record["msg"] != "entering branch" || record["path"] != "" {
+		t.Errorf("wrong log record: %v", record)
+	}
+
+	if _, err := NewLogger(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("expected error for unknown log format")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for s, expected := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		level, err := ParseLogLevel(s)
+		if err != nil || level != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", s, expected, level, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+
+	buf := new(bytes.Buffer)
+	l, _ := NewLogger(buf, slog.LevelWarn, LogText)
+	l.Info("hidden")
+	l.Warn("shown", "tag", "numeric.sin")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "tag=numeric.sin") {
+		t.Errorf("wrong log output: %s", buf.String())
+	}
+}
@@ -0,0 +1,284 @@
+package opc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrNoValue is returned by the conversions if the item has no value (VT_EMPTY or VT_NULL).
+	ErrNoValue = errors.New("opc: item has no value")
+	//ErrConversion is returned if the value of an item cannot be converted to the requested type.
+	ErrConversion = errors.New("opc: cannot convert value")
+)
+
+//conversionError wraps ErrConversion with the source value and the target type.
+func conversionError(v interface{}, target string) error {
+	return fmt.Errorf("%w: %T(%v) to %s", ErrConversion, v, v, target)
+}
+
+//Float64 converts the value of the item to a float64.
+//All integer and floating point types are converted directly, booleans become
+//1 or 0 and strings are parsed with strconv.ParseFloat.
+func (i Item) Float64() (float64, error) {
+	return toFloat64(i.Value)
+}
+
+//Int64 converts the value of the item to an int64.
+//Floating point values are truncated towards zero, booleans become 1 or 0 and
+//strings are parsed as decimal integers or as floating point numbers.
+//Values outside of the int64 range, NaN and Inf return an error.
+func (i Item) Int64() (int64, error) {
+	return toInt64(i.Value)
+}
+
+//Bool converts the value of the item to a bool.
+//Numbers are true if they are not zero and strings are parsed with strconv.ParseBool.
+func (i Item) Bool() (bool, error) {
+	return toBool(i.Value)
+}
+
+//Text converts the value of the item to a string.
+//Timestamps are formatted with time.RFC3339Nano, floating point values with the
+//shortest representation and everything else with fmt.Sprint.
+func (i Item) Text() (string, error) {
+	return toString(i.Value)
+}
+
+//Time converts the value of the item to a time.Time.
+//Only VT_DATE values and strings in time.RFC3339Nano format can be converted.
+func (i Item) Time() (time.Time, error) {
+	return toTime(i.Value)
+}
+
+//Float64Slice converts the value of the item to a slice of float64.
+//Arrays (VT_ARRAY) are converted element by element with the rules of Float64.
+//Scalar values return a slice with one element.
+func (i Item) Float64Slice() ([]float64, error) {
+	return toFloat64Slice(i.Value)
+}
+
+//toFloat64 implements the conversion rules of Item.Float64.
+func toFloat64(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, ErrNoValue
+	case float64:
+		return x, nil
+	case float32:
+		return float64(x), nil
+	case int8:
+		return float64(x), nil
+	case int16:
+		return float64(x), nil
+	case int32:
+		return float64(x), nil
+	case int64:
+		return float64(x), nil
+	case int:
+		return float64(x), nil
+	case uint8:
+		return float64(x), nil
+	case uint16:
+		return float64(x), nil
+	case uint32:
+		return float64(x), nil
+	case uint64:
+		return float64(x), nil
+	case uint:
+		return float64(x), nil
+	case uintptr:
+		return float64(x), nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, conversionError(v, "float64")
+		}
+		return f, nil
+	}
+	return 0, conversionError(v, "float64")
+}
+
+//toInt64 implements the conversion rules of Item.Int64.
+func toInt64(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, ErrNoValue
+	case int8:
+		return int64(x), nil
+	case int16:
+		return int64(x), nil
+	case int32:
+		return int64(x), nil
+	case int64:
+		return x, nil
+	case int:
+		return int64(x), nil
+	case uint8:
+		return int64(x), nil
+	case uint16:
+		return int64(x), nil
+	case uint32:
+		return int64(x), nil
+	case uint64:
+		if x > math.MaxInt64 {
+			return 0, conversionError(v, "int64")
+		}
+		return int64(x), nil
+	case uint:
+		if uint64(x) > math.MaxInt64 {
+			return 0, conversionError(v, "int64")
+		}
+		return int64(x), nil
+	case uintptr:
+		if uint64(x) > math.MaxInt64 {
+			return 0, conversionError(v, "int64")
+		}
+		return int64(x), nil
+	case float32:
+		return floatToInt64(float64(x), v)
+	case float64:
+		return floatToInt64(x, v)
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		s := strings.TrimSpace(x)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, conversionError(v, "int64")
+		}
+		return floatToInt64(f, v)
+	}
+	return 0, conversionError(v, "int64")
+}
+
+//floatToInt64 truncates f towards zero and checks the int64 range.
+func floatToInt64(f float64, v interface{}) (int64, error) {
+	if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, conversionError(v, "int64")
+	}
+	return int64(f), nil
+}
+
+//toBool implements the conversion rules of Item.Bool.
+func toBool(v interface{}) (bool, error) {
+	switch x := v.(type) {
+	case nil:
+		return false, ErrNoValue
+	case bool:
+		return x, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(x))
+		if err != nil {
+			return false, conversionError(v, "bool")
+		}
+		return b, nil
+	}
+	f, err := toFloat64(v)
+	if err != nil {
+		return false, conversionError(v, "bool")
+	}
+	return f != 0, nil
+}
+
+//toString implements the conversion rules of Item.Text.
+func toString(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", ErrNoValue
+	case string:
+		return x, nil
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+//toTime implements the conversion rules of Item.Time.
+func toTime(v interface{}) (time.Time, error) {
+	switch x := v.(type) {
+	case nil:
+		return time.Time{}, ErrNoValue
+	case time.Time:
+		return x, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(x))
+		if err != nil {
+			return time.Time{}, conversionError(v, "time.Time")
+		}
+		return t, nil
+	}
+	return time.Time{}, conversionError(v, "time.Time")
+}
+
+//toFloat64Slice implements the conversion rules of Item.Float64Slice.
+func toFloat64Slice(v interface{}) ([]float64, error) {
+	var elements []interface{}
+	switch x := v.(type) {
+	case nil:
+		return nil, ErrNoValue
+	case []float64:
+		return append([]float64{}, x...), nil
+	case []interface{}:
+		elements = x
+	case []float32:
+		for _, e := range x {
+			elements = append(elements, e)
+		}
+	case []int16:
+		for _, e := range x {
+			elements = append(elements, e)
+		}
+	case []int32:
+		for _, e := range x {
+			elements = append(elements, e)
+		}
+	case []int64:
+		for _, e := range x {
+			elements = append(elements, e)
+		}
+	case []uint8:
+		for _, e := range x {
+			elements = append(elements, e)
+		}
+	case []bool:
+		for _, e := range x {
+			elements = append(elements, e)
+		}
+	default:
+		f, err := toFloat64(v)
+		if err != nil {
+			return nil, conversionError(v, "[]float64")
+		}
+		return []float64{f}, nil
+	}
+
+	result := make([]float64, len(elements))
+	for i, e := range elements {
+		f, err := toFloat64(e)
+		if err != nil {
+			return nil, conversionError(v, "[]float64")
+		}
+		result[i] = f
+	}
+	return result, nil
+}
@@ -0,0 +1,148 @@
+package opc
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestItemFloat64(t *testing.T) {
+	var config = []struct {
+		Value interface{}
+		Want  float64
+		Err   error
+	}{
+		{float32(1.5), 1.5, nil},
+		{int32(-7), -7, nil},
+		{uint16(7), 7, nil},
+		{int64(1 << 40), 1 << 40, nil},
+		{true, 1, nil},
+		{" 2.25 ", 2.25, nil},
+		{"abc", 0, ErrConversion},
+		{time.Now(), 0, ErrConversion},
+		{nil, 0, ErrNoValue},
+	}
+
+	for _, cfg := range config {
+		got, err := Item{Value: cfg.Value}.Float64()
+		if !errors.Is(err, cfg.Err) {
+			t.Errorf("Float64(%T %v): got error %v, want %v", cfg.Value, cfg.Value, err, cfg.Err)
+			continue
+		}
+		if got != cfg.Want {
+			t.Errorf("Float64(%T %v): got %v, want %v", cfg.Value, cfg.Value, got, cfg.Want)
+		}
+	}
+}
+
+func TestItemInt64(t *testing.T) {
+	var config = []struct {
+		Value interface{}
+		Want  int64
+		Err   error
+	}{
+		{int16(-3), -3, nil},
+		{uint32(math.MaxUint32), math.MaxUint32, nil},
+		{uint64(math.MaxUint64), 0, ErrConversion},
+		{float32(2.9), 2, nil},
+		{-2.9, -2, nil},
+		{math.NaN(), 0, ErrConversion},
+		{math.Inf(1), 0, ErrConversion},
+		{false, 0, nil},
+		{"42", 42, nil},
+		{"42.7", 42, nil},
+		{nil, 0, ErrNoValue},
+	}
+
+	for _, cfg := range config {
+		got, err := Item{Value: cfg.Value}.Int64()
+		if !errors.Is(err, cfg.Err) {
+			t.Errorf("Int64(%T %v): got error %v, want %v", cfg.Value, cfg.Value, err, cfg.Err)
+			continue
+		}
+		if got != cfg.Want {
+			t.Errorf("Int64(%T %v): got %v, want %v", cfg.Value, cfg.Value, got, cfg.Want)
+		}
+	}
+}
+
+func TestItemBool(t *testing.T) {
+	var config = []struct {
+		Value interface{}
+		Want  bool
+		Err   error
+	}{
+		{true, true, nil},
+		{int32(0), false, nil},
+		{float32(0.1), true, nil},
+		{"true", true, nil},
+		{"0", false, nil},
+		{"maybe", false, ErrConversion},
+		{nil, false, ErrNoValue},
+	}
+
+	for _, cfg := range config {
+		got, err := Item{Value: cfg.Value}.Bool()
+		if !errors.Is(err, cfg.Err) {
+			t.Errorf("Bool(%T %v): got error %v, want %v", cfg.Value, cfg.Value, err, cfg.Err)
+			continue
+		}
+		if got != cfg.Want {
+			t.Errorf("Bool(%T %v): got %v, want %v", cfg.Value, cfg.Value, got, cfg.Want)
+		}
+	}
+}
+
+func TestItemStringAndTime(t *testing.T) {
+	ts := time.Date(2019, 6, 21, 15, 23, 8, 123456789, time.UTC)
+
+	s, err := Item{Value: ts}.Text()
+	if err != nil || s != "2019-06-21T15:23:08.123456789Z" {
+		t.Fatalf("String() of timestamp: got %q, %v", s, err)
+	}
+	if s, _ = (Item{Value: float32(0.1)}).Text(); s != "0.1" {
+		t.Fatalf("String() of float32: got %q", s)
+	}
+	if s, _ = (Item{Value: int32(5)}).Text(); s != "5" {
+		t.Fatalf("String() of int32: got %q", s)
+	}
+	if _, err = (Item{}).Text(); err != ErrNoValue {
+		t.Fatalf("String() of empty item should return ErrNoValue, got %v", err)
+	}
+
+	got, err := Item{Value: ts.Format(time.RFC3339Nano)}.Time()
+	if err != nil || !got.Equal(ts) {
+		t.Fatalf("Time() of string: got %v, %v", got, err)
+	}
+	if _, err = (Item{Value: 1.0}).Time(); !errors.Is(err, ErrConversion) {
+		t.Fatalf("Time() of float64 should fail, got %v", err)
+	}
+}
+
+func TestItemFloat64Slice(t *testing.T) {
+	var config = []struct {
+		Value interface{}
+		Want  []float64
+		Err   error
+	}{
+		{[]interface{}{float32(1), int16(2), true}, []float64{1, 2, 1}, nil},
+		{[]int32{4, 5}, []float64{4, 5}, nil},
+		{[]float64{0.5}, []float64{0.5}, nil},
+		{uint8(3), []float64{3}, nil},
+		{[]interface{}{1.0, "x"}, nil, ErrConversion},
+		{nil, nil, ErrNoValue},
+	}
+
+	for _, cfg := range config {
+		got, err := Item{Value: cfg.Value}.Float64Slice()
+		if !errors.Is(err, cfg.Err) {
+			t.Errorf("Float64Slice(%v): got error %v, want %v", cfg.Value, err, cfg.Err)
+			continue
+		}
+		if !reflect.DeepEqual(got, cfg.Want) {
+			t.Errorf("Float64Slice(%v): got %v, want %v", cfg.Value, got, cfg.Want)
+		}
+	}
+}
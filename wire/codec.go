@@ -0,0 +1,141 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// JSON encodes samples and snapshots as JSON. Timestamps are RFC 3339 strings
+// with nanosecond precision. NaN and infinite floats, which JSON cannot represent,
+// are encoded as the strings "NaN", "+Inf" and "-Inf".
+var JSON Codec = jsonCodec{}
+
+// CBOR encodes samples and snapshots as CBOR (RFC 8949) with the same field
+// names as JSON. Timestamps are RFC 3339 strings with nanosecond precision.
+var CBOR Codec = newCBORCodec()
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) MarshalSample(s Sample) ([]byte, error) {
+	s.Value = finite(s.Value)
+	return json.Marshal(s)
+}
+
+func (jsonCodec) MarshalSnapshot(s Snapshot) ([]byte, error) {
+	samples := make([]Sample, len(s.Samples))
+	for i, sample := range s.Samples {
+		sample.Value = finite(sample.Value)
+		samples[i] = sample
+	}
+	s.Samples = samples
+	return json.Marshal(s)
+}
+
+// finite replaces NaN and infinite floats with strings for JSON; restore
+// parses them again for the float data types
+func finite(v interface{}) interface{} {
+	switch x := v.(type) {
+	case float32:
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return strconv.FormatFloat(float64(x), 'g', -1, 32)
+		}
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return strconv.FormatFloat(x, 'g', -1, 64)
+		}
+	case []interface{}:
+		values := make([]interface{}, len(x))
+		for i, e := range x {
+			values[i] = finite(e)
+		}
+		return values
+	}
+	return v
+}
+
+func (jsonCodec) UnmarshalSample(data []byte, s *Sample) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(s); err != nil {
+		return err
+	}
+	return s.restore()
+}
+
+func (jsonCodec) UnmarshalSnapshot(data []byte, s *Snapshot) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(s); err != nil {
+		return err
+	}
+	return s.restore()
+}
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() Codec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return &cborCodec{enc: enc, dec: dec}
+}
+
+func (c *cborCodec) Name() string        { return "cbor" }
+func (c *cborCodec) ContentType() string { return "application/cbor" }
+
+func (c *cborCodec) MarshalSample(s Sample) ([]byte, error) {
+	return c.enc.Marshal(s)
+}
+
+func (c *cborCodec) MarshalSnapshot(s Snapshot) ([]byte, error) {
+	return c.enc.Marshal(s)
+}
+
+func (c *cborCodec) UnmarshalSample(data []byte, s *Sample) error {
+	if err := c.dec.Unmarshal(data, s); err != nil {
+		return err
+	}
+	return s.restore()
+}
+
+func (c *cborCodec) UnmarshalSnapshot(data []byte, s *Snapshot) error {
+	if err := c.dec.Unmarshal(data, s); err != nil {
+		return err
+	}
+	return s.restore()
+}
+
+// restore converts the decoded value back to the Go type given by DataType
+func (s *Sample) restore() error {
+	value, err := restore(s.DataType, s.ElementType, s.Value)
+	if err != nil {
+		return err
+	}
+	s.Value = value
+	return nil
+}
+
+// restore converts the decoded values of all samples
+func (s *Snapshot) restore() error {
+	for i := range s.Samples {
+		if err := s.Samples[i].restore(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package wire
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/konimarti/opc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encodes samples and snapshots with the messages defined in wire.proto.
+// Timestamps are nanoseconds since the Unix epoch; zero means not set.
+var Protobuf Codec = protobufCodec{}
+
+// field numbers of wire.proto
+const (
+	valueDouble protowire.Number = 1
+	valueInt    protowire.Number = 2
+	valueUint   protowire.Number = 3
+	valueBool   protowire.Number = 4
+	valueString protowire.Number = 5
+	valueTime   protowire.Number = 6
+	valueArray  protowire.Number = 7
+
+	listValues protowire.Number = 1
+
+	sampleVersion       protowire.Number = 1
+	sampleTag           protowire.Number = 2
+	sampleValue         protowire.Number = 3
+	sampleType          protowire.Number = 4
+	sampleQuality       protowire.Number = 5
+	sampleQualityString protowire.Number = 6
+	sampleTimestamp     protowire.Number = 7
+	sampleElementType   protowire.Number = 8
+
+	snapshotVersion   protowire.Number = 1
+	snapshotTimestamp protowire.Number = 2
+	snapshotSamples   protowire.Number = 3
+)
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return "protobuf" }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) MarshalSample(s Sample) ([]byte, error) {
+	return appendSample(nil, s)
+}
+
+func (protobufCodec) MarshalSnapshot(s Snapshot) ([]byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, snapshotVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.Version))
+	b = protowire.AppendTag(b, snapshotTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(unixNano(s.Timestamp)))
+	for _, sample := range s.Samples {
+		msg, err := appendSample(nil, sample)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, snapshotSamples, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	return b, nil
+}
+
+func (protobufCodec) UnmarshalSample(data []byte, s *Sample) error {
+	*s = Sample{}
+	if err := consumeSample(data, s); err != nil {
+		return err
+	}
+	return s.restore()
+}
+
+func (protobufCodec) UnmarshalSnapshot(data []byte, s *Snapshot) error {
+	*s = Snapshot{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == snapshotVersion && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.Version = int(v)
+			return n, nil
+		case num == snapshotTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.Timestamp = fromUnixNano(int64(v))
+			return n, nil
+		case num == snapshotSamples && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var sample Sample
+			if err := consumeSample(msg, &sample); err != nil {
+				return 0, err
+			}
+			s.Samples = append(s.Samples, sample)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.restore()
+}
+
+// appendSample appends the Sample message
+func appendSample(b []byte, s Sample) ([]byte, error) {
+	value, err := appendValue(nil, s.Value)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, sampleVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.Version))
+	b = protowire.AppendTag(b, sampleTag, protowire.BytesType)
+	b = protowire.AppendString(b, s.Tag)
+	b = protowire.AppendTag(b, sampleValue, protowire.BytesType)
+	b = protowire.AppendBytes(b, value)
+	b = protowire.AppendTag(b, sampleType, protowire.BytesType)
+	b = protowire.AppendString(b, s.DataType)
+	b = protowire.AppendTag(b, sampleQuality, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(s.Quality)))
+	b = protowire.AppendTag(b, sampleQualityString, protowire.BytesType)
+	b = protowire.AppendString(b, s.QualityString)
+	b = protowire.AppendTag(b, sampleTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(unixNano(s.Timestamp)))
+	if s.ElementType != "" {
+		b = protowire.AppendTag(b, sampleElementType, protowire.BytesType)
+		b = protowire.AppendString(b, s.ElementType)
+	}
+	return b, nil
+}
+
+// appendValue appends the Value message with the oneof field matching v
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	switch x := normalize(v).(type) {
+	case nil:
+	case bool:
+		b = protowire.AppendTag(b, valueBool, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(x))
+	case int8, int16, int32, int64:
+		n, _ := opc.Item{Value: x}.Int64()
+		b = protowire.AppendTag(b, valueInt, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(n))
+	case uint8, uint16, uint32, uint64:
+		n, _ := toUint64(x)
+		b = protowire.AppendTag(b, valueUint, protowire.VarintType)
+		b = protowire.AppendVarint(b, n)
+	case float32:
+		b = protowire.AppendTag(b, valueDouble, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(float64(x)))
+	case float64:
+		b = protowire.AppendTag(b, valueDouble, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(x))
+	case string:
+		b = protowire.AppendTag(b, valueString, protowire.BytesType)
+		b = protowire.AppendString(b, x)
+	case time.Time:
+		b = protowire.AppendTag(b, valueTime, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(unixNano(x)))
+	case []interface{}:
+		var list []byte
+		for _, e := range x {
+			element, err := appendValue(nil, e)
+			if err != nil {
+				return nil, err
+			}
+			list = protowire.AppendTag(list, listValues, protowire.BytesType)
+			list = protowire.AppendBytes(list, element)
+		}
+		b = protowire.AppendTag(b, valueArray, protowire.BytesType)
+		b = protowire.AppendBytes(b, list)
+	default:
+		return nil, fmt.Errorf("wire: cannot encode %T", v)
+	}
+	return b, nil
+}
+
+// consumeSample parses the Sample message
+func consumeSample(data []byte, s *Sample) error {
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == sampleVersion && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.Version = int(v)
+			return n, nil
+		case num == sampleTag && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			s.Tag = v
+			return n, nil
+		case num == sampleValue && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			value, err := consumeValue(msg)
+			s.Value = value
+			return n, err
+		case num == sampleType && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			s.DataType = v
+			return n, nil
+		case num == sampleQuality && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.Quality = opc.Quality(protowire.DecodeZigZag(v))
+			return n, nil
+		case num == sampleQualityString && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			s.QualityString = v
+			return n, nil
+		case num == sampleTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.Timestamp = fromUnixNano(int64(v))
+			return n, nil
+		case num == sampleElementType && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			s.ElementType = v
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// consumeValue parses the Value message
+func consumeValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == valueDouble && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			value = math.Float64frombits(v)
+			return n, nil
+		case num == valueInt && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			value = protowire.DecodeZigZag(v)
+			return n, nil
+		case num == valueUint && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			value = v
+			return n, nil
+		case num == valueBool && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			value = protowire.DecodeBool(v)
+			return n, nil
+		case num == valueString && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			value = v
+			return n, nil
+		case num == valueTime && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			value = fromUnixNano(int64(v))
+			return n, nil
+		case num == valueArray && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			values := []interface{}{}
+			err := consumeFields(msg, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num != listValues || typ != protowire.BytesType {
+					return protowire.ConsumeFieldValue(num, typ, b), nil
+				}
+				element, n := protowire.ConsumeBytes(b)
+				if n < 0 {
+					return n, nil
+				}
+				v, err := consumeValue(element)
+				values = append(values, v)
+				return n, err
+			})
+			value = values
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return value, err
+}
+
+// consumeFields iterates over the fields of a message. The callback consumes
+// the value of the field and returns the number of bytes read.
+func consumeFields(data []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// unixNano returns the timestamp in ns since the Unix epoch or 0 for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the inverse of unixNano
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
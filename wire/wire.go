@@ -0,0 +1,327 @@
+// Package wire defines the versioned wire schema for OPC items and read
+// snapshots and provides JSON, CBOR and protobuf codecs for it. The api and the
+// bridges use this package so that every consumer sees the same encoding.
+package wire
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+// Version is the version of the wire schema
+const Version = 1
+
+// Data types of the values in the wire schema
+const (
+	TypeEmpty   = "empty"
+	TypeBool    = "bool"
+	TypeInt8    = "int8"
+	TypeInt16   = "int16"
+	TypeInt32   = "int32"
+	TypeInt64   = "int64"
+	TypeUint8   = "uint8"
+	TypeUint16  = "uint16"
+	TypeUint32  = "uint32"
+	TypeUint64  = "uint64"
+	TypeFloat32 = "float32"
+	TypeFloat64 = "float64"
+	TypeString  = "string"
+	TypeTime    = "time"
+	TypeArray   = "array"
+)
+
+// Sample is the wire representation of a single opc.Item
+type Sample struct {
+	Version       int         `json:"version" cbor:"version"`
+	Tag           string      `json:"tag" cbor:"tag"`
+	Value         interface{} `json:"value" cbor:"value"`
+	DataType      string      `json:"type" cbor:"type"`
+	ElementType   string      `json:"element_type,omitempty" cbor:"element_type,omitempty"` // type of all elements of an array value
+	Quality       opc.Quality `json:"quality" cbor:"quality"`
+	QualityString string      `json:"quality_string" cbor:"quality_string"`
+	Timestamp     time.Time   `json:"timestamp" cbor:"timestamp"`
+}
+
+// Snapshot is the wire representation of the items of one read,
+// e.g. the result of Connection.Read(). Samples are sorted by tag.
+type Snapshot struct {
+	Version   int       `json:"version" cbor:"version"`
+	Timestamp time.Time `json:"timestamp" cbor:"timestamp"`
+	Samples   []Sample  `json:"samples" cbor:"samples"`
+}
+
+// NewSample converts an opc.Item for the wire
+func NewSample(tag string, item opc.Item) Sample {
+	value := normalize(item.Value)
+	return Sample{
+		Version:       Version,
+		Tag:           tag,
+		Value:         value,
+		DataType:      DataType(value),
+		ElementType:   elementType(value),
+		Quality:       item.Quality,
+		QualityString: item.Quality.String(),
+		Timestamp:     item.Timestamp,
+	}
+}
+
+// NewSnapshot converts the result of a read for the wire
+func NewSnapshot(items map[string]opc.Item) Snapshot {
+	samples := make([]Sample, 0, len(items))
+	for tag, item := range items {
+		samples = append(samples, NewSample(tag, item))
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Tag < samples[j].Tag })
+	return Snapshot{
+		Version:   Version,
+		Timestamp: time.Now(),
+		Samples:   samples,
+	}
+}
+
+// Item converts the sample back to an opc.Item
+func (s Sample) Item() opc.Item {
+	return opc.Item{
+		Value:     s.Value,
+		Quality:   s.Quality,
+		Timestamp: s.Timestamp,
+	}
+}
+
+// Items converts the snapshot back to a map of opc.Item
+func (s Snapshot) Items() map[string]opc.Item {
+	items := make(map[string]opc.Item)
+	for _, sample := range s.Samples {
+		items[sample.Tag] = sample.Item()
+	}
+	return items
+}
+
+// DataType returns the wire data type of an item value
+func DataType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return TypeEmpty
+	case bool:
+		return TypeBool
+	case int8:
+		return TypeInt8
+	case int16:
+		return TypeInt16
+	case int32:
+		return TypeInt32
+	case int64, int:
+		return TypeInt64
+	case uint8:
+		return TypeUint8
+	case uint16:
+		return TypeUint16
+	case uint32:
+		return TypeUint32
+	case uint64, uint, uintptr:
+		return TypeUint64
+	case float32:
+		return TypeFloat32
+	case float64:
+		return TypeFloat64
+	case string:
+		return TypeString
+	case time.Time:
+		return TypeTime
+	case []interface{}:
+		return TypeArray
+	}
+	return TypeString
+}
+
+// elementType returns the data type of the elements of an array if they all
+// have the same type and an empty string otherwise
+func elementType(v interface{}) string {
+	elements, ok := v.([]interface{})
+	if !ok || len(elements) == 0 {
+		return ""
+	}
+	dataType := DataType(elements[0])
+	for _, e := range elements[1:] {
+		if DataType(e) != dataType {
+			return ""
+		}
+	}
+	if dataType == TypeArray {
+		return ""
+	}
+	return dataType
+}
+
+// normalize converts values without a wire data type to a string
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, bool, int8, int16, int32, int64, uint8, uint16, uint32, uint64,
+		float32, float64, string, time.Time:
+		return v
+	case int:
+		return int64(x)
+	case uint:
+		return uint64(x)
+	case uintptr:
+		return uint64(x)
+	case []interface{}:
+		values := make([]interface{}, len(x))
+		for i, e := range x {
+			values[i] = normalize(e)
+		}
+		return values
+	}
+	return fmt.Sprint(v)
+}
+
+// restore converts a decoded value back to the Go type of the data type. The
+// elements of arrays are converted to the element type if it is given.
+func restore(dataType, elementType string, v interface{}) (interface{}, error) {
+	if n, ok := v.(json.Number); ok {
+		v = string(n)
+	}
+	item := opc.Item{Value: v}
+	switch dataType {
+	case TypeEmpty:
+		return nil, nil
+	case TypeBool:
+		return item.Bool()
+	case TypeInt8, TypeInt16, TypeInt32, TypeInt64:
+		n, err := item.Int64()
+		if err != nil {
+			return nil, err
+		}
+		switch dataType {
+		case TypeInt8:
+			if n < math.MinInt8 || n > math.MaxInt8 {
+				return nil, fmt.Errorf("wire: value %d out of range for %s", n, dataType)
+			}
+			return int8(n), nil
+		case TypeInt16:
+			if n < math.MinInt16 || n > math.MaxInt16 {
+				return nil, fmt.Errorf("wire: value %d out of range for %s", n, dataType)
+			}
+			return int16(n), nil
+		case TypeInt32:
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("wire: value %d out of range for %s", n, dataType)
+			}
+			return int32(n), nil
+		}
+		return n, nil
+	case TypeUint8, TypeUint16, TypeUint32, TypeUint64:
+		n, err := toUint64(v)
+		if err != nil {
+			return nil, err
+		}
+		switch dataType {
+		case TypeUint8:
+			if n > math.MaxUint8 {
+				return nil, fmt.Errorf("wire: value %d out of range for %s", n, dataType)
+			}
+			return uint8(n), nil
+		case TypeUint16:
+			if n > math.MaxUint16 {
+				return nil, fmt.Errorf("wire: value %d out of range for %s", n, dataType)
+			}
+			return uint16(n), nil
+		case TypeUint32:
+			if n > math.MaxUint32 {
+				return nil, fmt.Errorf("wire: value %d out of range for %s", n, dataType)
+			}
+			return uint32(n), nil
+		}
+		return n, nil
+	case TypeFloat32:
+		f, err := item.Float64()
+		return float32(f), err
+	case TypeFloat64:
+		return item.Float64()
+	case TypeString:
+		return item.Text()
+	case TypeTime:
+		return item.Time()
+	case TypeArray:
+		elements, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("wire: array expected, got %T", v)
+		}
+		values := make([]interface{}, len(elements))
+		for i, e := range elements {
+			if elementType != "" {
+				value, err := restore(elementType, "", e)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = value
+				continue
+			}
+			if n, ok := e.(json.Number); ok {
+				e, _ = n.Float64()
+			}
+			values[i] = e
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("wire: unknown data type %q", dataType)
+}
+
+// toUint64 converts a decoded value to uint64 without losing precision
+func toUint64(v interface{}) (uint64, error) {
+	switch x := v.(type) {
+	case uint64:
+		return x, nil
+	case string:
+		return strconv.ParseUint(strings.TrimSpace(x), 10, 64)
+	}
+	n, err := opc.Item{Value: v}.Int64()
+	if err == nil && n < 0 {
+		return 0, fmt.Errorf("wire: negative value %d for unsigned data type", n)
+	}
+	return uint64(n), err
+}
+
+// Codec encodes and decodes samples and snapshots
+type Codec interface {
+	Name() string
+	ContentType() string
+	MarshalSample(Sample) ([]byte, error)
+	MarshalSnapshot(Snapshot) ([]byte, error)
+	UnmarshalSample([]byte, *Sample) error
+	UnmarshalSnapshot([]byte, *Snapshot) error
+}
+
+// Codecs lists all available codecs
+var Codecs = []Codec{JSON, CBOR, Protobuf}
+
+// Lookup returns the codec with the given name, e.g. "json", "cbor" or "protobuf"
+func Lookup(name string) (Codec, error) {
+	if name == "" {
+		return JSON, nil
+	}
+	for _, c := range Codecs {
+		if strings.EqualFold(c.Name(), name) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("wire: unknown encoding %q", name)
+}
+
+// ForContentType returns the codec for a MIME type, e.g. from an Accept header.
+// JSON is returned if no codec matches.
+func ForContentType(contentType string) Codec {
+	for _, c := range Codecs {
+		if strings.Contains(contentType, c.ContentType()) {
+			return c
+		}
+	}
+	return JSON
+}
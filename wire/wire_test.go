@@ -0,0 +1,173 @@
+package wire
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+func testingItems() map[string]opc.Item {
+	ts := time.Date(2019, 6, 21, 15, 23, 8, 123456789, time.UTC)
+	return map[string]opc.Item{
+		"numeric.sin.float": {Value: float32(1.5), Quality: opc.OPCQualityGood, Timestamp: ts},
+		"numeric.sin.int16": {Value: int16(-3), Quality: 0xC1, Timestamp: ts},
+		"numeric.sin.int64": {Value: int64(1<<62 + 1), Quality: opc.OPCQualityGood, Timestamp: ts},
+		"numeric.sin.uint":  {Value: uint64(1<<63 + 1), Quality: opc.OPCQualityGood, Timestamp: ts},
+		"textual.color":     {Value: "Brown", Quality: opc.OPCQualityCommFailure, Timestamp: ts},
+		"storage.bool":      {Value: true, Quality: opc.OPCQualityGoodButForced, Timestamp: ts},
+		"time.current":      {Value: ts, Quality: opc.OPCQualityGood, Timestamp: ts},
+		"empty":             {},
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	snapshot := NewSnapshot(testingItems())
+	snapshot.Timestamp = time.Date(2019, 6, 21, 15, 23, 9, 1, time.UTC)
+
+	for _, codec := range Codecs {
+		data, err := codec.MarshalSnapshot(snapshot)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		var got Snapshot
+		if err := codec.UnmarshalSnapshot(data, &got); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		if !reflect.DeepEqual(got, snapshot) {
+			t.Errorf("%s: snapshot changed in round trip\ngot:  %+v\nwant: %+v", codec.Name(), got, snapshot)
+		}
+	}
+}
+
+func TestSampleRoundTrip(t *testing.T) {
+	sample := NewSample("array", opc.Item{
+		Value:     []interface{}{1.5, "a", true},
+		Quality:   opc.OPCQualityGood,
+		Timestamp: time.Date(2019, 6, 21, 15, 23, 8, 1, time.UTC),
+	})
+
+	for _, codec := range Codecs {
+		data, err := codec.MarshalSample(sample)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		var got Sample
+		if err := codec.UnmarshalSample(data, &got); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		if !reflect.DeepEqual(got, sample) {
+			t.Errorf("%s: sample changed in round trip\ngot:  %+v\nwant: %+v", codec.Name(), got, sample)
+		}
+	}
+}
+
+func TestArrayElementTypes(t *testing.T) {
+	for _, value := range [][]interface{}{
+		{int32(1), int32(-2), int32(3)},
+		{uint16(1), uint16(65535)},
+		{true, false},
+		{time.Date(2019, 6, 21, 15, 23, 8, 0, time.UTC)},
+	} {
+		sample := NewSample("array", opc.Item{Value: value, Quality: opc.OPCQualityGood})
+		if sample.ElementType == "" {
+			t.Fatalf("no element type for %v", value)
+		}
+		for _, codec := range Codecs {
+			data, err := codec.MarshalSample(sample)
+			if err != nil {
+				t.Fatalf("%s: %v", codec.Name(), err)
+			}
+			var got Sample
+			if err := codec.UnmarshalSample(data, &got); err != nil {
+				t.Fatalf("%s: %v", codec.Name(), err)
+			}
+			if !reflect.DeepEqual(got.Value, sample.Value) {
+				t.Errorf("%s: array changed in round trip: got %#v, want %#v", codec.Name(), got.Value, sample.Value)
+			}
+		}
+	}
+}
+
+func TestSampleJSONSchema(t *testing.T) {
+	items := testingItems()
+	data, err := JSON.MarshalSample(NewSample("numeric.sin.int16", items["numeric.sin.int16"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	want := map[string]interface{}{
+		"version":        1.0,
+		"tag":            "numeric.sin.int16",
+		"value":          -3.0,
+		"type":           "int16",
+		"quality":        193.0,
+		"quality_string": "good, low limited",
+		"timestamp":      "2019-06-21T15:23:08.123456789Z",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("JSON schema changed\ngot:  %v\nwant: %v", m, want)
+	}
+}
+
+func TestNonFiniteFloats(t *testing.T) {
+	ts := time.Date(2019, 6, 21, 15, 23, 8, 0, time.UTC)
+	snapshot := NewSnapshot(map[string]opc.Item{
+		"nan":  {Value: math.NaN(), Quality: opc.OPCQualityGood, Timestamp: ts},
+		"inf":  {Value: float32(math.Inf(1)), Quality: opc.OPCQualityGood, Timestamp: ts},
+		"-inf": {Value: math.Inf(-1), Quality: opc.OPCQualityGood, Timestamp: ts},
+	})
+	for _, codec := range Codecs {
+		data, err := codec.MarshalSnapshot(snapshot)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		var got Snapshot
+		if err := codec.UnmarshalSnapshot(data, &got); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		values := got.Items()
+		if f, ok := values["nan"].Value.(float64); !ok || !math.IsNaN(f) {
+			t.Errorf("%s: wrong NaN %#v", codec.Name(), values["nan"].Value)
+		}
+		if values["inf"].Value != float32(math.Inf(1)) || values["-inf"].Value != math.Inf(-1) {
+			t.Errorf("%s: wrong Inf %#v %#v", codec.Name(), values["inf"].Value, values["-inf"].Value)
+		}
+	}
+	if _, err := JSON.MarshalSample(NewSample("nan", opc.Item{Value: []interface{}{math.NaN()}})); err != nil {
+		t.Errorf("array with NaN: %v", err)
+	}
+}
+
+func TestRestoreRange(t *testing.T) {
+	for _, data := range []string{
+		`{"tag": "a", "type": "int8", "value": 200}`,
+		`{"tag": "a", "type": "int16", "value": -40000}`,
+		`{"tag": "a", "type": "int32", "value": 3000000000}`,
+		`{"tag": "a", "type": "uint8", "value": 256}`,
+		`{"tag": "a", "type": "uint32", "value": 5000000000}`,
+	} {
+		var s Sample
+		if err := JSON.UnmarshalSample([]byte(data), &s); err == nil {
+			t.Errorf("expected range error for %s, got %#v", data, s.Value)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"json", "CBOR", "protobuf"} {
+		if _, err := Lookup(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := Lookup("xml"); err == nil {
+		t.Error("xml should not be a known encoding")
+	}
+	if codec := ForContentType("application/cbor, */*"); codec != CBOR {
+		t.Errorf("expected CBOR codec, got %s", codec.Name())
+	}
+}